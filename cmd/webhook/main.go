@@ -0,0 +1,68 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/net-kourier/pkg/config"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection/sharedmain"
+	"knative.dev/pkg/signals"
+	"knative.dev/pkg/webhook"
+	"knative.dev/pkg/webhook/certificates"
+	"knative.dev/pkg/webhook/configmaps"
+)
+
+func newConfigValidationController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+	impl := configmaps.NewAdmissionController(ctx,
+		// Name of the configmap webhook.
+		"config.webhook.kourier.networking.internal.knative.dev",
+		// The path on which to serve the webhook.
+		"/config-validation",
+		// The configmaps to validate.
+		configmap.Constructors{
+			config.ConfigName: validateKourierConfig,
+		},
+	)
+	impl.Reconciler = withUnknownKeyWarnings(impl.Reconciler.(admissionReconciler))
+	return impl
+}
+
+// validateKourierConfig rejects config-kourier with an error for every invalid key.
+func validateKourierConfig(cm *corev1.ConfigMap) (*config.Kourier, error) {
+	if errs := config.Validate(cm.Data); errs != nil {
+		return nil, errs
+	}
+	return config.NewConfigFromConfigMap(cm)
+}
+
+func main() {
+	// Set up a signal context with our webhook options.
+	ctx := webhook.WithOptions(signals.NewContext(), webhook.Options{
+		ServiceName: webhook.NameFromEnv(),
+		Port:        webhook.PortFromEnv(8443),
+		SecretName:  "net-kourier-webhook-certs",
+	})
+
+	sharedmain.MainWithContext(ctx, webhook.NameFromEnv(),
+		certificates.NewController,
+		newConfigValidationController,
+	)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/net-kourier/pkg/config"
+	"knative.dev/pkg/controller"
+	pkgreconciler "knative.dev/pkg/reconciler"
+	"knative.dev/pkg/system"
+	"knative.dev/pkg/webhook"
+)
+
+// admissionReconciler is the set of interfaces implemented by the configmaps
+// admission controller, all of which need to be preserved when wrapping it.
+type admissionReconciler interface {
+	controller.Reconciler
+	pkgreconciler.LeaderAware
+	webhook.AdmissionController
+	webhook.StatelessAdmissionController
+}
+
+// unknownKeyWarner adds a warning for every key of config-kourier that is not
+// understood to the responses of the wrapped admission controller.
+type unknownKeyWarner struct {
+	admissionReconciler
+}
+
+var _ webhook.AdmissionController = (*unknownKeyWarner)(nil)
+
+func withUnknownKeyWarnings(r admissionReconciler) *unknownKeyWarner {
+	return &unknownKeyWarner{admissionReconciler: r}
+}
+
+// Admit implements AdmissionController.
+func (w *unknownKeyWarner) Admit(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := w.admissionReconciler.Admit(ctx, request)
+	if !resp.Allowed || len(request.Object.Raw) == 0 {
+		return resp
+	}
+
+	var cm corev1.ConfigMap
+	if err := json.Unmarshal(request.Object.Raw, &cm); err != nil {
+		return resp
+	}
+	if cm.Name != config.ConfigName || (request.Namespace != "" && request.Namespace != system.Namespace()) {
+		return resp
+	}
+	for _, key := range config.UnknownKeys(cm.Data) {
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf("unknown key %q in %s is ignored", key, config.ConfigName))
+	}
+	return resp
+}
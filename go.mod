@@ -5,10 +5,9 @@ go 1.16
 require (
 	github.com/envoyproxy/go-control-plane v0.10.1
 	github.com/google/go-cmp v0.5.6
-	github.com/google/uuid v1.3.0
-	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pires/go-proxyproto v0.6.1
+	go.opencensus.io v0.23.0
 	go.uber.org/zap v1.19.1
 	golang.org/x/sys v0.0.0-20220110181412-a018aaa089fe // indirect
 	google.golang.org/genproto v0.0.0-20211129164237-f09f9a12af12
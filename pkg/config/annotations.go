@@ -0,0 +1,54 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+const (
+	// AnnotationPrefix is the prefix of the Ingress annotations understood by Kourier.
+	// The timeout, retry and CORS keys of config-kourier prefixed with it override
+	// the global settings for the Ingress, see the WithAnnotations methods of
+	// TimeoutConfig, RetryConfig and CORSConfig.
+	AnnotationPrefix = "kourier.knative.dev/"
+
+	// ClientCASecretAnnotationKey is the Ingress annotation naming the secret, in the
+	// Ingress' namespace, holding the CA bundle client certificates are verified
+	// against. Setting it requires clients of the external hosts to present a
+	// certificate.
+	ClientCASecretAnnotationKey = AnnotationPrefix + "client-ca-secret"
+
+	// RequireClientCertificateAnnotationKey is the Ingress annotation requiring clients
+	// of the external hosts to present a certificate signed by the CA bundle configured
+	// in config-kourier, if set to "true".
+	RequireClientCertificateAnnotationKey = AnnotationPrefix + "require-client-certificate"
+
+	// ClientCertSANsAnnotationKey is the Ingress annotation restricting the client
+	// certificates accepted to the ones with any of the given comma separated subject
+	// alternative names.
+	ClientCertSANsAnnotationKey = AnnotationPrefix + "client-cert-sans"
+
+	// HeaderMatchesAnnotationKey is the Ingress annotation adding header matchers to
+	// the routes of the Ingress, one per line as "[path] header [!]type [value]".
+	// The type is exact, prefix, suffix, contains, regex or present, and ! inverts
+	// the match. Matchers without a path apply to all paths.
+	HeaderMatchesAnnotationKey = AnnotationPrefix + "header-matches"
+
+	// PathMatchesAnnotationKey is the Ingress annotation selecting how the routes of
+	// the Ingress match paths, one per line as "[path] type". The type is prefix,
+	// matching the path and the paths below it, exact or regex. A type without a
+	// path applies to all other paths. Paths are matched as plain prefixes without
+	// a type.
+	PathMatchesAnnotationKey = AnnotationPrefix + "path-matches"
+)
@@ -38,6 +38,8 @@ const (
 	HTTPPortInternal = uint32(8081)
 	// HTTPSPortExternal is the port for external HTTPS availability.
 	HTTPSPortExternal = uint32(8443)
+	// HTTPSPortInternal is the port for internal HTTPS availability.
+	HTTPSPortInternal = uint32(8444)
 	// HTTPPortProb is the port for prob
 	HTTPPortProb = uint32(8090)
 	// HTTPSPortProb is the port for prob
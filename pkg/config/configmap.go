@@ -17,8 +17,15 @@ limitations under the License.
 package config
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 
+	"knative.dev/pkg/apis"
 	cm "knative.dev/pkg/configmap"
 )
 
@@ -32,27 +39,295 @@ const (
 
 	// enableProxyProtocol is the config map key for enabling proxy protocol
 	enableProxyProtocol = "enable-proxy-protocol"
+
+	// snapshotBatchWindowKey is the config map key for the time to wait for further
+	// changes before pushing a new configuration to the gateways.
+	snapshotBatchWindowKey = "snapshot-batch-window"
+
+	// snapshotMaxDelayKey is the config map key for the maximum time a change waits
+	// before being pushed to the gateways.
+	snapshotMaxDelayKey = "snapshot-max-delay"
+
+	// enableReadinessProbingKey is the config map key for enabling probing the gateways
+	// before marking an ingress ready, in addition to waiting for their ACKs.
+	enableReadinessProbingKey = "enable-readiness-probing"
+
+	// certsSecretNamespaceKey and certsSecretNameKey are the config map keys for the
+	// secret holding the certificate served for hosts without a certificate of their own.
+	certsSecretNamespaceKey = "certs-secret-namespace"
+	certsSecretNameKey      = "certs-secret-name"
+
+	// wildcardCertsSecretsKey is the config map key for the secrets holding wildcard
+	// certificates, served for the external hosts they cover that don't have a
+	// certificate of their own.
+	wildcardCertsSecretsKey = "wildcard-certs-secrets"
+
+	// clusterLocalCertsSecretKey is the config map key for the secret holding the
+	// certificate served by the cluster-local HTTPS listener for hosts without a
+	// certificate of their own.
+	clusterLocalCertsSecretKey = "cluster-local-certs-secret"
+
+	// clientCASecretKey is the config map key for the secret holding the CA bundle
+	// client certificates are verified against for the ingresses requiring them
+	// without naming a CA bundle of their own.
+	clientCASecretKey = "client-ca-secret"
+
+	// tlsMinVersionKey and tlsMaxVersionKey are the config map keys for the TLS
+	// versions accepted by the HTTPS listeners.
+	tlsMinVersionKey = "tls-min-version"
+	tlsMaxVersionKey = "tls-max-version"
+
+	// tlsCipherSuitesKey is the config map key for the cipher suites accepted by the
+	// HTTPS listeners.
+	tlsCipherSuitesKey = "tls-cipher-suites"
+
+	// tlsECDHCurvesKey is the config map key for the elliptic curves accepted for ECDH
+	// by the HTTPS listeners.
+	tlsECDHCurvesKey = "tls-ecdh-curves"
+
+	// enableUpstreamTLSKey is the config map key for originating TLS to the endpoints of
+	// all services, rather than just to service ports named https.
+	enableUpstreamTLSKey = "enable-upstream-tls"
+
+	// upstreamCASecretKey is the config map key for the secret holding the CA bundle
+	// the certificates of the endpoints TLS is originated to are verified against.
+	upstreamCASecretKey = "upstream-ca-secret"
+
+	// upstreamSubjectAltNamesKey is the config map key for the subject alternative names
+	// the certificates of the endpoints TLS is originated to must have any of, instead
+	// of the hostname of their service.
+	upstreamSubjectAltNamesKey = "upstream-subject-alt-names"
+
+	// upstreamClientCertsSecretKey is the config map key for the secret holding the
+	// client certificate presented to the endpoints TLS is originated to.
+	upstreamClientCertsSecretKey = "upstream-client-certs-secret"
+
+	// disableHTTPOptionKey is the config map key for ignoring the HTTPOption of the
+	// ingresses, i.e. never redirecting HTTP requests to HTTPS.
+	disableHTTPOptionKey = "disable-http-option"
+
+	// extAuthzHostKey is the config map key for the address of the external
+	// authorization service. External authorization is disabled if not set.
+	extAuthzHostKey = "extauthz-host"
+
+	// extAuthzFailureModeAllowKey is the config map key for allowing requests if the
+	// external authorization service fails.
+	extAuthzFailureModeAllowKey = "extauthz-failure-mode-allow"
+
+	// extAuthzMaxRequestBytesKey is the config map key for the maximum size of the
+	// request body sent to the external authorization service.
+	extAuthzMaxRequestBytesKey = "extauthz-max-request-bytes"
+
+	// extAuthzTimeoutKey is the config map key for the timeout of the requests to the
+	// external authorization service.
+	extAuthzTimeoutKey = "extauthz-timeout"
 )
 
 func DefaultConfig() *Kourier {
 	return &Kourier{
 		EnableServiceAccessLogging: true, // true is the default for backwards-compat
 		EnableProxyProtocol:        false,
+		SnapshotBatchWindow:        100 * time.Millisecond,
+		SnapshotMaxDelay:           time.Second,
+		EnableReadinessProbing:     true,
+		ExternalAuthz: ExternalAuthzConfig{
+			MaxRequestBytes: 8192,
+			Timeout:         2 * time.Second,
+		},
+		Retry: RetryConfig{
+			Attempts: 1,
+		},
 	}
 }
 
-// NewConfigFromMap creates a DeploymentConfig from the supplied Map.
+// NewConfigFromMap creates a DeploymentConfig from the supplied Map. Keys missing in
+// the map are taken from the deprecated environment variables, if they're set.
 func NewConfigFromMap(configMap map[string]string) (*Kourier, error) {
+	nc, errs := parseConfig(withDeprecatedEnv(configMap))
+	if errs != nil {
+		return nil, errs
+	}
+	return nc, nil
+}
+
+// Validate returns a field error for every key of the supplied map that
+// NewConfigFromMap would reject, rather than just the first one.
+func Validate(configMap map[string]string) *apis.FieldError {
+	_, errs := parseConfig(configMap)
+	return errs
+}
+
+// UnknownKeys returns the keys of the supplied map that NewConfigFromMap doesn't
+// understand, sorted. They are ignored, and usually are typos.
+func UnknownKeys(configMap map[string]string) []string {
+	parsers := keyParsers(DefaultConfig())
+	var unknown []string
+	for key := range configMap {
+		if _, ok := parsers[key]; !ok && key != cm.ExampleKey {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// keyParsers returns the parsers of all keys understood by NewConfigFromMap,
+// storing into nc.
+func keyParsers(nc *Kourier) map[string]cm.ParseFunc {
+	parsers := map[string]cm.ParseFunc{
+		enableServiceAccessLoggingKey: cm.AsBool(enableServiceAccessLoggingKey, &nc.EnableServiceAccessLogging),
+		enableProxyProtocol:           cm.AsBool(enableProxyProtocol, &nc.EnableProxyProtocol),
+		snapshotBatchWindowKey:        cm.AsDuration(snapshotBatchWindowKey, &nc.SnapshotBatchWindow),
+		snapshotMaxDelayKey:           cm.AsDuration(snapshotMaxDelayKey, &nc.SnapshotMaxDelay),
+		enableReadinessProbingKey:     cm.AsBool(enableReadinessProbingKey, &nc.EnableReadinessProbing),
+		certsSecretNamespaceKey:       cm.AsString(certsSecretNamespaceKey, &nc.CertsSecretNamespace),
+		certsSecretNameKey:            cm.AsString(certsSecretNameKey, &nc.CertsSecretName),
+		wildcardCertsSecretsKey:       asNamespacedNames(wildcardCertsSecretsKey, &nc.WildcardCertsSecrets),
+		clusterLocalCertsSecretKey:    asOptionalNamespacedName(clusterLocalCertsSecretKey, &nc.ClusterLocalCertsSecret),
+		clientCASecretKey:             asOptionalNamespacedName(clientCASecretKey, &nc.ClientCASecret),
+		tlsMinVersionKey:              cm.AsString(tlsMinVersionKey, &nc.TLS.MinVersion),
+		tlsMaxVersionKey:              cm.AsString(tlsMaxVersionKey, &nc.TLS.MaxVersion),
+		tlsCipherSuitesKey:            asStrings(tlsCipherSuitesKey, &nc.TLS.CipherSuites),
+		tlsECDHCurvesKey:              asStrings(tlsECDHCurvesKey, &nc.TLS.ECDHCurves),
+		enableUpstreamTLSKey:          cm.AsBool(enableUpstreamTLSKey, &nc.EnableUpstreamTLS),
+		upstreamCASecretKey:           asOptionalNamespacedName(upstreamCASecretKey, &nc.UpstreamCASecret),
+		upstreamSubjectAltNamesKey:    asStrings(upstreamSubjectAltNamesKey, &nc.UpstreamSubjectAltNames),
+		upstreamClientCertsSecretKey:  asOptionalNamespacedName(upstreamClientCertsSecretKey, &nc.UpstreamClientCertsSecret),
+		disableHTTPOptionKey:          cm.AsBool(disableHTTPOptionKey, &nc.DisableHTTPOption),
+		extAuthzHostKey:               cm.AsString(extAuthzHostKey, &nc.ExternalAuthz.Host),
+		extAuthzFailureModeAllowKey:   cm.AsBool(extAuthzFailureModeAllowKey, &nc.ExternalAuthz.FailureModeAllow),
+		extAuthzMaxRequestBytesKey:    cm.AsUint32(extAuthzMaxRequestBytesKey, &nc.ExternalAuthz.MaxRequestBytes),
+		extAuthzTimeoutKey:            cm.AsDuration(extAuthzTimeoutKey, &nc.ExternalAuthz.Timeout),
+	}
+	for key, parse := range timeoutKeyParsers("", &nc.Timeout) {
+		parsers[key] = parse
+	}
+	for key, parse := range retryKeyParsers("", &nc.Retry) {
+		parsers[key] = parse
+	}
+	for key, parse := range corsKeyParsers("", &nc.CORS) {
+		parsers[key] = parse
+	}
+	return parsers
+}
+
+// asNamespacedNames parses the value at key as a comma separated list of
+// namespace/name pairs.
+func asNamespacedNames(key string, target *[]types.NamespacedName) cm.ParseFunc {
+	return func(data map[string]string) error {
+		raw, ok := data[key]
+		if !ok {
+			return nil
+		}
+
+		var names []types.NamespacedName
+		for _, item := range strings.Split(raw, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			parts := strings.Split(item, "/")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("failed to parse %q: %q is not namespace/name", key, item)
+			}
+			names = append(names, types.NamespacedName{Namespace: parts[0], Name: parts[1]})
+		}
+		*target = names
+		return nil
+	}
+}
+
+// asStrings parses the value at key as a comma separated list, keeping its order.
+func asStrings(key string, target *[]string) cm.ParseFunc {
+	return func(data map[string]string) error {
+		raw, ok := data[key]
+		if !ok {
+			return nil
+		}
+
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*target = items
+		return nil
+	}
+}
+
+// asOptionalNamespacedName parses the value at key as a namespace/name pair. Unlike
+// cm.AsOptionalNamespacedName, an empty value is accepted as unset, like in the
+// example config.
+func asOptionalNamespacedName(key string, target **types.NamespacedName) cm.ParseFunc {
+	return func(data map[string]string) error {
+		if raw, ok := data[key]; ok && strings.TrimSpace(raw) == "" {
+			*target = nil
+			return nil
+		}
+		return cm.AsOptionalNamespacedName(key, target)(data)
+	}
+}
+
+// asDuration parses the value at key as a duration. Unlike cm.AsDuration, an empty
+// value is accepted as zero, so the keys disabled if unset can be set to "", like in
+// the example config.
+func asDuration(key string, target *time.Duration) cm.ParseFunc {
+	return func(data map[string]string) error {
+		if raw, ok := data[key]; ok && strings.TrimSpace(raw) == "" {
+			*target = 0
+			return nil
+		}
+		return cm.AsDuration(key, target)(data)
+	}
+}
+
+// parseConfig parses the supplied map on top of the default configuration and
+// collects the errors of all keys.
+func parseConfig(configMap map[string]string) (*Kourier, *apis.FieldError) {
 	nc := DefaultConfig()
 
-	if err := cm.Parse(configMap,
-		cm.AsBool(enableServiceAccessLoggingKey, &nc.EnableServiceAccessLogging),
-		cm.AsBool(enableProxyProtocol, &nc.EnableProxyProtocol),
-	); err != nil {
-		return nil, err
+	var errs *apis.FieldError
+	for key, parse := range keyParsers(nc) {
+		if err := parse(configMap); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(configMap[key], key, err.Error()))
+		}
 	}
 
-	return nc, nil
+	if nc.SnapshotBatchWindow < 0 {
+		errs = errs.Also(apis.ErrInvalidValue(nc.SnapshotBatchWindow, snapshotBatchWindowKey, "must not be negative"))
+	}
+	if nc.SnapshotMaxDelay < 0 {
+		errs = errs.Also(apis.ErrInvalidValue(nc.SnapshotMaxDelay, snapshotMaxDelayKey, "must not be negative"))
+	}
+	if (nc.CertsSecretNamespace == "") != (nc.CertsSecretName == "") {
+		errs = errs.Also(apis.ErrGeneric("must be set together", certsSecretNamespaceKey, certsSecretNameKey))
+	}
+	if err := validateTLSVersions(nc.TLS.MinVersion, nc.TLS.MaxVersion); err != nil {
+		errs = errs.Also(apis.ErrGeneric(err.Error(), tlsMinVersionKey, tlsMaxVersionKey))
+	}
+	if err := validateTLSCipherSuites(nc.TLS.CipherSuites); err != nil {
+		errs = errs.Also(apis.ErrInvalidValue(configMap[tlsCipherSuitesKey], tlsCipherSuitesKey, err.Error()))
+	}
+	if err := validateTLSECDHCurves(nc.TLS.ECDHCurves); err != nil {
+		errs = errs.Also(apis.ErrInvalidValue(configMap[tlsECDHCurvesKey], tlsECDHCurvesKey, err.Error()))
+	}
+	if nc.EnableUpstreamTLS && nc.UpstreamCASecret == nil {
+		errs = errs.Also(apis.ErrGeneric("requires "+upstreamCASecretKey, enableUpstreamTLSKey))
+	}
+	if nc.ExternalAuthz.Enabled() {
+		if _, _, err := splitExternalAuthzHost(nc.ExternalAuthz.Host); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(nc.ExternalAuthz.Host, extAuthzHostKey, "must be host:port: "+err.Error()))
+		}
+	}
+	if nc.ExternalAuthz.Timeout <= 0 {
+		errs = errs.Also(apis.ErrInvalidValue(nc.ExternalAuthz.Timeout, extAuthzTimeoutKey, "must be positive"))
+	}
+	errs = errs.Also(validateTimeouts("", &nc.Timeout))
+	errs = errs.Also(validateRetry("", &nc.Retry))
+	errs = errs.Also(validateCORS("", &nc.CORS))
+
+	return nc, errs
 }
 
 // NewConfigFromConfigMap creates a Kourier from the supplied configMap.
@@ -68,4 +343,66 @@ type Kourier struct {
 	EnableServiceAccessLogging bool
 	// EnableProxyProtocol specifies whether proxy protocol feature is enabled
 	EnableProxyProtocol bool
+	// SnapshotBatchWindow specifies how long to wait for further changes before
+	// pushing a new configuration to the gateways. Every change restarts the window.
+	// Zero disables batching.
+	SnapshotBatchWindow time.Duration
+	// SnapshotMaxDelay bounds how long a change can be delayed by SnapshotBatchWindow
+	// being restarted over and over. Zero means unbounded.
+	SnapshotMaxDelay time.Duration
+	// EnableReadinessProbing specifies whether the gateways are probed before marking
+	// an ingress ready. Ingresses are only marked ready once all gateways ACKed their
+	// configuration in any case.
+	EnableReadinessProbing bool
+	// CertsSecretNamespace and CertsSecretName specify the secret holding the
+	// certificate served for hosts without a certificate of their own. Both are empty
+	// if there's none.
+	CertsSecretNamespace string
+	CertsSecretName      string
+	// WildcardCertsSecrets specifies the secrets holding wildcard certificates. The
+	// hosts of external rules without a certificate of their own are served the
+	// first of these certificates covering them, if any.
+	WildcardCertsSecrets []types.NamespacedName
+	// ClusterLocalCertsSecret specifies the secret holding the certificate served by
+	// the cluster-local HTTPS listener for the hosts of cluster-local rules without a
+	// certificate of their own. Nil if there's none.
+	ClusterLocalCertsSecret *types.NamespacedName
+	// ClientCASecret specifies the secret holding the CA bundle client certificates
+	// are verified against for the ingresses requiring client certificates without
+	// naming a CA bundle of their own. Nil if there's none.
+	ClientCASecret *types.NamespacedName
+	// TLS specifies the TLS parameters of the connections to the HTTPS listeners.
+	TLS TLSConfig
+	// EnableUpstreamTLS specifies whether TLS is originated to the endpoints of all
+	// services. TLS is always originated to service ports named https.
+	EnableUpstreamTLS bool
+	// UpstreamCASecret specifies the secret holding the CA bundle the certificates of
+	// the endpoints TLS is originated to are verified against. Nil if there's none.
+	UpstreamCASecret *types.NamespacedName
+	// UpstreamSubjectAltNames restricts the certificates verified against
+	// UpstreamCASecret to the ones with any of these subject alternative names. The
+	// certificates must have the hostname of their service if empty.
+	UpstreamSubjectAltNames []string
+	// UpstreamClientCertsSecret specifies the secret holding the client certificate
+	// presented to the endpoints TLS is originated to. Nil if there's none.
+	UpstreamClientCertsSecret *types.NamespacedName
+	// DisableHTTPOption specifies whether the HTTPOption of the ingresses is ignored,
+	// i.e. HTTP requests are never redirected to HTTPS.
+	DisableHTTPOption bool
+	// ExternalAuthz specifies the external authorization service, if any.
+	ExternalAuthz ExternalAuthzConfig
+	// Timeout specifies the timeouts of the requests to the services, unless
+	// overridden by the annotations of their ingress.
+	Timeout TimeoutConfig
+	// Retry specifies how the requests to the services are retried, unless
+	// overridden by the annotations of their ingress.
+	Retry RetryConfig
+	// CORS specifies the CORS policy of the virtual hosts, unless overridden by the
+	// annotations of their ingress.
+	CORS CORSConfig
+}
+
+// HasCertsSecret returns true if a default certificate is configured.
+func (k *Kourier) HasCertsSecret() bool {
+	return k.CertsSecretNamespace != "" && k.CertsSecretName != ""
 }
@@ -17,10 +17,19 @@ limitations under the License.
 package config
 
 import (
+	"strings"
 	"testing"
+	"time"
 
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
 	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	_ "knative.dev/pkg/system/testing"
 )
@@ -37,9 +46,11 @@ func TestKourierConfig(t *testing.T) {
 		data: map[string]string{},
 	}, {
 		name: "disable logging",
-		want: &Kourier{
-			EnableServiceAccessLogging: false,
-		},
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.EnableServiceAccessLogging = false
+			return c
+		}(),
 		data: map[string]string{
 			enableServiceAccessLoggingKey: "false",
 		},
@@ -51,20 +62,24 @@ func TestKourierConfig(t *testing.T) {
 		},
 	}, {
 		name: "enable proxy protocol and logging",
-		want: &Kourier{
-			EnableServiceAccessLogging: true,
-			EnableProxyProtocol:        true,
-		},
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.EnableServiceAccessLogging = true
+			c.EnableProxyProtocol = true
+			return c
+		}(),
 		data: map[string]string{
 			enableServiceAccessLoggingKey: "true",
 			enableProxyProtocol:           "true",
 		},
 	}, {
 		name: "enable proxy protocol and disable logging",
-		want: &Kourier{
-			EnableServiceAccessLogging: false,
-			EnableProxyProtocol:        true,
-		},
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.EnableServiceAccessLogging = false
+			c.EnableProxyProtocol = true
+			return c
+		}(),
 		data: map[string]string{
 			enableServiceAccessLoggingKey: "false",
 			enableProxyProtocol:           "true",
@@ -75,6 +90,422 @@ func TestKourierConfig(t *testing.T) {
 		data: map[string]string{
 			enableProxyProtocol: "foo",
 		},
+	}, {
+		name: "snapshot batching",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.SnapshotBatchWindow = 500 * time.Millisecond
+			c.SnapshotMaxDelay = 5 * time.Second
+			return c
+		}(),
+		data: map[string]string{
+			snapshotBatchWindowKey: "500ms",
+			snapshotMaxDelayKey:    "5s",
+		},
+	}, {
+		name: "snapshot batching disabled",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.SnapshotBatchWindow = 0
+			return c
+		}(),
+		data: map[string]string{
+			snapshotBatchWindowKey: "0s",
+		},
+	}, {
+		name: "disable readiness probing",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.EnableReadinessProbing = false
+			return c
+		}(),
+		data: map[string]string{
+			enableReadinessProbingKey: "false",
+		},
+	}, {
+		name:    "not a duration for snapshot batch window",
+		wantErr: true,
+		data: map[string]string{
+			snapshotBatchWindowKey: "foo",
+		},
+	}, {
+		name:    "negative snapshot batch window",
+		wantErr: true,
+		data: map[string]string{
+			snapshotBatchWindowKey: "-1s",
+		},
+	}, {
+		name:    "negative snapshot max delay",
+		wantErr: true,
+		data: map[string]string{
+			snapshotMaxDelayKey: "-1s",
+		},
+	}, {
+		name: "default certificate",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.CertsSecretNamespace = "certns"
+			c.CertsSecretName = "certname"
+			return c
+		}(),
+		data: map[string]string{
+			certsSecretNamespaceKey: "certns",
+			certsSecretNameKey:      "certname",
+		},
+	}, {
+		name:    "default certificate without namespace",
+		wantErr: true,
+		data: map[string]string{
+			certsSecretNameKey: "certname",
+		},
+	}, {
+		name: "wildcard certificates",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.WildcardCertsSecrets = []types.NamespacedName{
+				{Namespace: "certns", Name: "example-com"},
+				{Namespace: "certns", Name: "example-org"},
+			}
+			return c
+		}(),
+		data: map[string]string{
+			wildcardCertsSecretsKey: "certns/example-com, certns/example-org",
+		},
+	}, {
+		name:    "wildcard certificate without namespace",
+		wantErr: true,
+		data: map[string]string{
+			wildcardCertsSecretsKey: "certns/example-com,example-org",
+		},
+	}, {
+		name: "cluster-local certificate",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.ClusterLocalCertsSecret = &types.NamespacedName{Namespace: "certns", Name: "cluster-local"}
+			return c
+		}(),
+		data: map[string]string{
+			clusterLocalCertsSecretKey: "certns/cluster-local",
+		},
+	}, {
+		name: "empty cluster-local certificate",
+		want: DefaultConfig(),
+		data: map[string]string{
+			clusterLocalCertsSecretKey: "",
+		},
+	}, {
+		name:    "cluster-local certificate without namespace",
+		wantErr: true,
+		data: map[string]string{
+			clusterLocalCertsSecretKey: "cluster-local",
+		},
+	}, {
+		name: "client CA secret",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.ClientCASecret = &types.NamespacedName{Namespace: "certns", Name: "client-ca"}
+			return c
+		}(),
+		data: map[string]string{
+			clientCASecretKey: "certns/client-ca",
+		},
+	}, {
+		name: "empty client CA secret",
+		want: DefaultConfig(),
+		data: map[string]string{
+			clientCASecretKey: "",
+		},
+	}, {
+		name:    "client CA secret without namespace",
+		wantErr: true,
+		data: map[string]string{
+			clientCASecretKey: "client-ca",
+		},
+	}, {
+		name: "upstream TLS",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.EnableUpstreamTLS = true
+			c.UpstreamCASecret = &types.NamespacedName{Namespace: "certns", Name: "upstream-ca"}
+			c.UpstreamSubjectAltNames = []string{"*.svc", "backend.example.com"}
+			c.UpstreamClientCertsSecret = &types.NamespacedName{Namespace: "certns", Name: "upstream-client"}
+			return c
+		}(),
+		data: map[string]string{
+			enableUpstreamTLSKey:         "true",
+			upstreamCASecretKey:          "certns/upstream-ca",
+			upstreamSubjectAltNamesKey:   "*.svc, backend.example.com",
+			upstreamClientCertsSecretKey: "certns/upstream-client",
+		},
+	}, {
+		name: "empty upstream TLS secrets",
+		want: DefaultConfig(),
+		data: map[string]string{
+			upstreamCASecretKey:          "",
+			upstreamClientCertsSecretKey: "",
+		},
+	}, {
+		name:    "upstream TLS without CA bundle",
+		wantErr: true,
+		data: map[string]string{
+			enableUpstreamTLSKey: "true",
+		},
+	}, {
+		name: "TLS parameters",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.TLS = TLSConfig{
+				MinVersion:   "1.2",
+				MaxVersion:   "1.3",
+				CipherSuites: []string{"[ECDHE-ECDSA-AES128-GCM-SHA256|ECDHE-ECDSA-CHACHA20-POLY1305]", "ECDHE-RSA-AES128-GCM-SHA256"},
+				ECDHCurves:   []string{"X25519", "P-256"},
+			}
+			return c
+		}(),
+		data: map[string]string{
+			tlsMinVersionKey:   "1.2",
+			tlsMaxVersionKey:   "1.3",
+			tlsCipherSuitesKey: "[ECDHE-ECDSA-AES128-GCM-SHA256|ECDHE-ECDSA-CHACHA20-POLY1305], ECDHE-RSA-AES128-GCM-SHA256",
+			tlsECDHCurvesKey:   "X25519,P-256",
+		},
+	}, {
+		name:    "unsupported TLS version",
+		wantErr: true,
+		data: map[string]string{
+			tlsMinVersionKey: "1.4",
+		},
+	}, {
+		name:    "TLS minimum version greater than maximum version",
+		wantErr: true,
+		data: map[string]string{
+			tlsMinVersionKey: "1.3",
+			tlsMaxVersionKey: "1.2",
+		},
+	}, {
+		name:    "unsupported cipher suite",
+		wantErr: true,
+		data: map[string]string{
+			tlsCipherSuitesKey: "ECDHE-ECDSA-AES128-GCM-SHA256,TLS_AES_128_GCM_SHA256",
+		},
+	}, {
+		name:    "unsupported ECDH curve",
+		wantErr: true,
+		data: map[string]string{
+			tlsECDHCurvesKey: "P-224",
+		},
+	}, {
+		name: "disable http option",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.DisableHTTPOption = true
+			return c
+		}(),
+		data: map[string]string{
+			disableHTTPOptionKey: "true",
+		},
+	}, {
+		name: "external authorization",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.ExternalAuthz = ExternalAuthzConfig{
+				Host:             "authz.example.com:6000",
+				FailureModeAllow: true,
+				MaxRequestBytes:  1024,
+				Timeout:          500 * time.Millisecond,
+			}
+			return c
+		}(),
+		data: map[string]string{
+			extAuthzHostKey:             "authz.example.com:6000",
+			extAuthzFailureModeAllowKey: "true",
+			extAuthzMaxRequestBytesKey:  "1024",
+			extAuthzTimeoutKey:          "500ms",
+		},
+	}, {
+		name:    "external authorization host without port",
+		wantErr: true,
+		data: map[string]string{
+			extAuthzHostKey: "authz.example.com",
+		},
+	}, {
+		name:    "external authorization port out of range",
+		wantErr: true,
+		data: map[string]string{
+			extAuthzHostKey: "authz.example.com:70000",
+		},
+	}, {
+		name:    "not a number for external authorization max request bytes",
+		wantErr: true,
+		data: map[string]string{
+			extAuthzMaxRequestBytesKey: "foo",
+		},
+	}, {
+		name:    "zero external authorization timeout",
+		wantErr: true,
+		data: map[string]string{
+			extAuthzTimeoutKey: "0s",
+		},
+	}, {
+		name: "timeouts",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.Timeout = TimeoutConfig{
+				Request:              time.Minute,
+				Idle:                 30 * time.Second,
+				MaxStreamDuration:    time.Hour,
+				GrpcTimeoutHeaderMax: 5 * time.Minute,
+			}
+			return c
+		}(),
+		data: map[string]string{
+			routeTimeoutKey:         "1m",
+			routeIdleTimeoutKey:     "30s",
+			maxStreamDurationKey:    "1h",
+			grpcTimeoutHeaderMaxKey: "5m",
+		},
+	}, {
+		name: "empty timeouts",
+		want: DefaultConfig(),
+		data: map[string]string{
+			routeTimeoutKey:         "",
+			routeIdleTimeoutKey:     "",
+			maxStreamDurationKey:    "",
+			grpcTimeoutHeaderMaxKey: "",
+		},
+	}, {
+		name:    "negative route timeout",
+		wantErr: true,
+		data: map[string]string{
+			routeTimeoutKey: "-1s",
+		},
+	}, {
+		name:    "not a duration for max stream duration",
+		wantErr: true,
+		data: map[string]string{
+			maxStreamDurationKey: "forever",
+		},
+	}, {
+		name: "CORS",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.CORS = CORSConfig{
+				AllowOrigins:     []string{"https://example.com", "prefix:https://app-", `regex:https://.*\.example\.org`},
+				AllowMethods:     []string{"GET", "POST"},
+				AllowHeaders:     []string{"authorization"},
+				ExposeHeaders:    []string{"x-request-id"},
+				MaxAge:           10 * time.Minute,
+				AllowCredentials: true,
+			}
+			return c
+		}(),
+		data: map[string]string{
+			corsAllowOriginsKey:     `https://example.com, prefix:https://app-, regex:https://.*\.example\.org`,
+			corsAllowMethodsKey:     "GET,POST",
+			corsAllowHeadersKey:     "authorization",
+			corsExposeHeadersKey:    "x-request-id",
+			corsMaxAgeKey:           "10m",
+			corsAllowCredentialsKey: "true",
+		},
+	}, {
+		name:    "invalid CORS origin regex",
+		wantErr: true,
+		data: map[string]string{
+			corsAllowOriginsKey: "regex:https://(",
+		},
+	}, {
+		name:    "empty CORS origin prefix",
+		wantErr: true,
+		data: map[string]string{
+			corsAllowOriginsKey: "prefix:",
+		},
+	}, {
+		name:    "negative CORS max age",
+		wantErr: true,
+		data: map[string]string{
+			corsMaxAgeKey: "-1s",
+		},
+	}, {
+		name: "retries",
+		want: func() *Kourier {
+			c := DefaultConfig()
+			c.Retry = RetryConfig{
+				On:                   []string{"connect-failure", "reset", "retriable-status-codes"},
+				RetriableStatusCodes: []uint32{503, 504},
+				Attempts:             3,
+				PerTryTimeout:        2 * time.Second,
+				BackoffBaseInterval:  25 * time.Millisecond,
+				BackoffMaxInterval:   250 * time.Millisecond,
+			}
+			return c
+		}(),
+		data: map[string]string{
+			retryOnKey:                   "connect-failure, reset, retriable-status-codes",
+			retryRetriableStatusCodesKey: "503,504",
+			retryAttemptsKey:             "3",
+			retryPerTryTimeoutKey:        "2s",
+			retryBackoffBaseIntervalKey:  "25ms",
+			retryBackoffMaxIntervalKey:   "250ms",
+		},
+	}, {
+		name: "empty retries",
+		want: DefaultConfig(),
+		data: map[string]string{
+			retryOnKey:                   "",
+			retryRetriableStatusCodesKey: "",
+			retryAttemptsKey:             "1",
+			retryPerTryTimeoutKey:        "",
+			retryBackoffBaseIntervalKey:  "",
+			retryBackoffMaxIntervalKey:   "",
+		},
+	}, {
+		name:    "unsupported retry condition",
+		wantErr: true,
+		data: map[string]string{
+			retryOnKey: "connect-failure,sometimes",
+		},
+	}, {
+		name:    "retriable status codes condition without status codes",
+		wantErr: true,
+		data: map[string]string{
+			retryOnKey: "retriable-status-codes",
+		},
+	}, {
+		name:    "not a number for retriable status codes",
+		wantErr: true,
+		data: map[string]string{
+			retryRetriableStatusCodesKey: "503,five-hundred",
+		},
+	}, {
+		name:    "retriable status code out of range",
+		wantErr: true,
+		data: map[string]string{
+			retryRetriableStatusCodesKey: "600",
+		},
+	}, {
+		name:    "zero retry attempts",
+		wantErr: true,
+		data: map[string]string{
+			retryAttemptsKey: "0",
+		},
+	}, {
+		name:    "negative retry per-try timeout",
+		wantErr: true,
+		data: map[string]string{
+			retryPerTryTimeoutKey: "-1s",
+		},
+	}, {
+		name:    "retry backoff max interval less than base interval",
+		wantErr: true,
+		data: map[string]string{
+			retryBackoffBaseIntervalKey: "1s",
+			retryBackoffMaxIntervalKey:  "100ms",
+		},
+	}, {
+		name:    "retry backoff max interval without base interval",
+		wantErr: true,
+		data: map[string]string{
+			retryBackoffMaxIntervalKey: "100ms",
+		},
 	}}
 
 	for _, tt := range configTests {
@@ -99,3 +530,311 @@ func TestKourierConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestDeprecatedEnv(t *testing.T) {
+	t.Setenv("CERTS_SECRET_NAMESPACE", "certns")
+	t.Setenv("CERTS_SECRET_NAME", "certname")
+	t.Setenv("KOURIER_HTTPOPTION_DISABLED", "")
+	t.Setenv("KOURIER_EXTAUTHZ_HOST", "authz.example.com:6000")
+	t.Setenv("KOURIER_EXTAUTHZ_TIMEOUT", "500")
+
+	// The config map takes precedence over the environment.
+	got, err := NewConfigFromMap(map[string]string{
+		extAuthzHostKey: "other.example.com:6000",
+	})
+	if err != nil {
+		t.Fatal("NewConfigFromMap() =", err)
+	}
+
+	want := DefaultConfig()
+	want.CertsSecretNamespace = "certns"
+	want.CertsSecretName = "certname"
+	want.DisableHTTPOption = true
+	want.ExternalAuthz.Host = "other.example.com:6000"
+	want.ExternalAuthz.Timeout = 500 * time.Millisecond
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Config mismatch: diff(-want,+got):\n%s", diff)
+	}
+
+	wantEnv := map[string]string{
+		"CERTS_SECRET_NAMESPACE":      certsSecretNamespaceKey,
+		"CERTS_SECRET_NAME":           certsSecretNameKey,
+		"KOURIER_HTTPOPTION_DISABLED": disableHTTPOptionKey,
+		"KOURIER_EXTAUTHZ_HOST":       extAuthzHostKey,
+		"KOURIER_EXTAUTHZ_TIMEOUT":    extAuthzTimeoutKey,
+	}
+	if diff := cmp.Diff(wantEnv, DeprecatedEnv()); diff != "" {
+		t.Errorf("DeprecatedEnv() mismatch (-want,+got):\n%s", diff)
+	}
+
+	// The webhook validates the config map on its own.
+	if errs := Validate(map[string]string{certsSecretNameKey: "certname"}); errs == nil {
+		t.Error("Validate() = nil, want an error for the incomplete default certificate")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      map[string]string
+		wantPaths []string
+	}{{
+		name: "valid",
+		data: map[string]string{
+			enableProxyProtocol:    "true",
+			snapshotBatchWindowKey: "1s",
+		},
+	}, {
+		name: "every invalid key is reported",
+		data: map[string]string{
+			enableServiceAccessLoggingKey: "foo",
+			snapshotBatchWindowKey:        "-1s",
+			extAuthzMaxRequestBytesKey:    "bar",
+			extAuthzHostKey:               "authz.example.com",
+		},
+		wantPaths: []string{
+			enableServiceAccessLoggingKey,
+			extAuthzHostKey,
+			extAuthzMaxRequestBytesKey,
+			snapshotBatchWindowKey,
+		},
+	}, {
+		name: "keys to be set together",
+		data: map[string]string{
+			certsSecretNameKey: "certname",
+		},
+		wantPaths: []string{certsSecretNameKey, certsSecretNamespaceKey},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Validate(tt.data)
+			if (errs != nil) != (len(tt.wantPaths) > 0) {
+				t.Fatalf("Validate() = %v, want errors for %v", errs, tt.wantPaths)
+			}
+			for _, path := range tt.wantPaths {
+				if !strings.Contains(errs.Error(), path) {
+					t.Errorf("Validate() = %v, want an error for %s", errs, path)
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	got := UnknownKeys(map[string]string{
+		"_example":                     "foo",
+		enableProxyProtocol:            "true",
+		"enable-proxy-protocoll":       "true",
+		"enable-service-acess-logging": "false",
+	})
+	want := []string{"enable-proxy-protocoll", "enable-service-acess-logging"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("UnknownKeys() mismatch (-want,+got):\n%s", diff)
+	}
+}
+
+func TestTLSParameters(t *testing.T) {
+	if params := (&TLSConfig{}).Parameters(); params != nil {
+		t.Errorf("Parameters() = %v, want nil", params)
+	}
+
+	params := (&TLSConfig{MinVersion: "1.2", ECDHCurves: []string{"X25519"}}).Parameters()
+	want := &auth.TlsParameters{
+		TlsMinimumProtocolVersion: auth.TlsParameters_TLSv1_2,
+		TlsMaximumProtocolVersion: auth.TlsParameters_TLS_AUTO,
+		EcdhCurves:                []string{"X25519"},
+	}
+	if !cmp.Equal(params, want, protocmp.Transform()) {
+		t.Errorf("Parameters() = %v, want %v", params, want)
+	}
+}
+
+func TestTimeoutWithAnnotations(t *testing.T) {
+	global := TimeoutConfig{
+		Request: time.Minute,
+		Idle:    30 * time.Second,
+	}
+
+	got, err := global.WithAnnotations(map[string]string{
+		AnnotationPrefix + routeTimeoutKey:         "10m",
+		AnnotationPrefix + grpcTimeoutHeaderMaxKey: "1m",
+	})
+	if err != nil {
+		t.Fatal("WithAnnotations() =", err)
+	}
+	want := &TimeoutConfig{
+		Request:              10 * time.Minute,
+		Idle:                 30 * time.Second,
+		GrpcTimeoutHeaderMax: time.Minute,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("WithAnnotations() mismatch (-want,+got):\n%s", diff)
+	}
+	if global.Request != time.Minute {
+		t.Errorf("WithAnnotations() modified the global config: %v", global)
+	}
+
+	if _, err := global.WithAnnotations(map[string]string{AnnotationPrefix + routeIdleTimeoutKey: "-1s"}); err == nil {
+		t.Error("WithAnnotations() = nil, want an error for a negative timeout")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	if policy := DefaultConfig().Retry.Policy(); policy != nil {
+		t.Errorf("Policy() = %v, want nil", policy)
+	}
+
+	policy := (&RetryConfig{
+		On:                   []string{"connect-failure", "retriable-status-codes"},
+		RetriableStatusCodes: []uint32{503},
+		Attempts:             2,
+		PerTryTimeout:        time.Second,
+		BackoffBaseInterval:  10 * time.Millisecond,
+	}).Policy()
+	want := &route.RetryPolicy{
+		RetryOn:              "connect-failure,retriable-status-codes",
+		NumRetries:           wrapperspb.UInt32(2),
+		PerTryTimeout:        durationpb.New(time.Second),
+		RetriableStatusCodes: []uint32{503},
+		RetryBackOff: &route.RetryPolicy_RetryBackOff{
+			BaseInterval: durationpb.New(10 * time.Millisecond),
+		},
+	}
+	if !cmp.Equal(policy, want, protocmp.Transform()) {
+		t.Errorf("Policy() = %v, want %v", policy, want)
+	}
+}
+
+func TestRetryWithAnnotations(t *testing.T) {
+	global := RetryConfig{
+		On:            []string{"connect-failure"},
+		Attempts:      1,
+		PerTryTimeout: time.Second,
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *RetryConfig
+		wantErr     bool
+	}{{
+		name: "no annotations",
+		want: &global,
+	}, {
+		name: "overridden",
+		annotations: map[string]string{
+			AnnotationPrefix + retryOnKey:       "reset,5xx",
+			AnnotationPrefix + retryAttemptsKey: "5",
+			"unrelated":                         "annotation",
+		},
+		want: &RetryConfig{
+			On:            []string{"reset", "5xx"},
+			Attempts:      5,
+			PerTryTimeout: time.Second,
+		},
+	}, {
+		name: "disabled",
+		annotations: map[string]string{
+			AnnotationPrefix + retryOnKey: "",
+		},
+		want: &RetryConfig{
+			Attempts:      1,
+			PerTryTimeout: time.Second,
+		},
+	}, {
+		name: "invalid",
+		annotations: map[string]string{
+			AnnotationPrefix + retryAttemptsKey: "many",
+		},
+		wantErr: true,
+	}, {
+		name: "keys of config-kourier are ignored",
+		annotations: map[string]string{
+			retryAttemptsKey: "many",
+		},
+		want: &global,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := global.WithAnnotations(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithAnnotations() error = %v, WantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("WithAnnotations() mismatch (-want,+got):\n%s", diff)
+			}
+		})
+	}
+
+	if global.Attempts != 1 || len(global.On) != 1 {
+		t.Errorf("WithAnnotations() modified the global config: %v", global)
+	}
+}
+
+func TestCORSPolicy(t *testing.T) {
+	if policy := DefaultConfig().CORS.Policy(); policy != nil {
+		t.Errorf("Policy() = %v, want nil", policy)
+	}
+
+	policy := (&CORSConfig{
+		AllowOrigins:     []string{"https://example.com", "prefix:https://app-", "regex:https://.*"},
+		AllowMethods:     []string{"GET", "POST"},
+		MaxAge:           90 * time.Second,
+		AllowCredentials: true,
+	}).Policy()
+	want := &route.CorsPolicy{
+		AllowOriginStringMatch: []*matcher.StringMatcher{{
+			MatchPattern: &matcher.StringMatcher_Exact{Exact: "https://example.com"},
+		}, {
+			MatchPattern: &matcher.StringMatcher_Prefix{Prefix: "https://app-"},
+		}, {
+			MatchPattern: &matcher.StringMatcher_SafeRegex{
+				SafeRegex: &matcher.RegexMatcher{
+					EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+					Regex:      "https://.*",
+				},
+			},
+		}},
+		AllowMethods:     "GET,POST",
+		MaxAge:           "90",
+		AllowCredentials: wrapperspb.Bool(true),
+	}
+	if !cmp.Equal(policy, want, protocmp.Transform()) {
+		t.Errorf("Policy() = %v, want %v", policy, want)
+	}
+}
+
+func TestCORSWithAnnotations(t *testing.T) {
+	global := CORSConfig{
+		AllowOrigins: []string{"https://example.com"},
+		AllowMethods: []string{"GET"},
+	}
+
+	got, err := global.WithAnnotations(map[string]string{
+		AnnotationPrefix + corsAllowOriginsKey: "prefix:https://app-",
+	})
+	if err != nil {
+		t.Fatal("WithAnnotations() =", err)
+	}
+	want := &CORSConfig{
+		AllowOrigins: []string{"prefix:https://app-"},
+		AllowMethods: []string{"GET"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("WithAnnotations() mismatch (-want,+got):\n%s", diff)
+	}
+
+	got, err = global.WithAnnotations(map[string]string{AnnotationPrefix + corsAllowOriginsKey: ""})
+	if err != nil {
+		t.Fatal("WithAnnotations() =", err)
+	}
+	if got.Enabled() {
+		t.Errorf("WithAnnotations() = %v, want CORS disabled", got)
+	}
+
+	if _, err := global.WithAnnotations(map[string]string{AnnotationPrefix + corsAllowOriginsKey: "regex:("}); err == nil {
+		t.Error("WithAnnotations() = nil, want an error for an invalid regular expression")
+	}
+}
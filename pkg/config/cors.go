@@ -0,0 +1,189 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"knative.dev/pkg/apis"
+	cm "knative.dev/pkg/configmap"
+)
+
+const (
+	// corsAllowOriginsKey is the config map key for the comma separated origins
+	// allowed to make cross-origin requests. CORS is disabled if empty.
+	corsAllowOriginsKey = "cors-allow-origins"
+
+	// corsAllowMethodsKey, corsAllowHeadersKey and corsExposeHeadersKey are the
+	// config map keys for the comma separated methods and headers allowed in
+	// cross-origin requests, and the headers exposed of their responses.
+	corsAllowMethodsKey  = "cors-allow-methods"
+	corsAllowHeadersKey  = "cors-allow-headers"
+	corsExposeHeadersKey = "cors-expose-headers"
+
+	// corsMaxAgeKey is the config map key for the time the responses to preflight
+	// requests may be cached.
+	corsMaxAgeKey = "cors-max-age"
+
+	// corsAllowCredentialsKey is the config map key for allowing cross-origin
+	// requests with credentials.
+	corsAllowCredentialsKey = "cors-allow-credentials"
+
+	// originPrefixPrefix and originRegexPrefix mark the allowed origins matched
+	// by prefix and by regular expression rather than exactly.
+	originPrefixPrefix = "prefix:"
+	originRegexPrefix  = "regex:"
+)
+
+// CORSConfig specifies the CORS policy of the virtual hosts. CORS is disabled if
+// AllowOrigins is empty.
+// +k8s:deepcopy-gen=true
+type CORSConfig struct {
+	// AllowOrigins are the origins allowed to make cross-origin requests. They are
+	// matched exactly, unless prefixed with prefix: or regex:. * allows all
+	// origins.
+	AllowOrigins []string
+	// AllowMethods are the methods allowed in cross-origin requests.
+	AllowMethods []string
+	// AllowHeaders are the headers allowed in cross-origin requests.
+	AllowHeaders []string
+	// ExposeHeaders are the headers of the responses exposed to the origins.
+	ExposeHeaders []string
+	// MaxAge is the time the responses to preflight requests may be cached, with
+	// second precision. Zero leaves it to the browsers.
+	MaxAge time.Duration
+	// AllowCredentials specifies whether cross-origin requests may carry
+	// credentials.
+	AllowCredentials bool
+}
+
+// Enabled returns true if cross-origin requests are allowed.
+func (c *CORSConfig) Enabled() bool {
+	return len(c.AllowOrigins) != 0
+}
+
+// Policy returns the CORS policy of the virtual hosts, or nil if CORS is disabled.
+// Must only be called for validated configs.
+func (c *CORSConfig) Policy() *route.CorsPolicy {
+	if !c.Enabled() {
+		return nil
+	}
+
+	policy := &route.CorsPolicy{
+		AllowMethods:  strings.Join(c.AllowMethods, ","),
+		AllowHeaders:  strings.Join(c.AllowHeaders, ","),
+		ExposeHeaders: strings.Join(c.ExposeHeaders, ","),
+	}
+	for _, origin := range c.AllowOrigins {
+		// The origins have been validated when parsing the config.
+		originMatcher, _ := originStringMatcher(origin)
+		policy.AllowOriginStringMatch = append(policy.AllowOriginStringMatch, originMatcher)
+	}
+	if c.MaxAge > 0 {
+		policy.MaxAge = strconv.FormatInt(int64(c.MaxAge/time.Second), 10)
+	}
+	if c.AllowCredentials {
+		policy.AllowCredentials = wrapperspb.Bool(true)
+	}
+	return policy
+}
+
+// WithAnnotations returns a copy of the config overridden by the CORS keys of
+// config-kourier prefixed with AnnotationPrefix in the given annotations, e.g.
+// kourier.knative.dev/cors-allow-origins. Setting
+// kourier.knative.dev/cors-allow-origins to an empty value disables CORS.
+func (c *CORSConfig) WithAnnotations(annotations map[string]string) (*CORSConfig, error) {
+	cc := c.DeepCopy()
+
+	var errs *apis.FieldError
+	for key, parse := range corsKeyParsers(AnnotationPrefix, cc) {
+		if err := parse(annotations); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(annotations[key], key, err.Error()))
+		}
+	}
+	if errs = errs.Also(validateCORS(AnnotationPrefix, cc)); errs != nil {
+		return nil, errs
+	}
+	return cc, nil
+}
+
+// corsKeyParsers returns the parsers of the CORS keys prefixed with prefix,
+// storing into cc.
+func corsKeyParsers(prefix string, cc *CORSConfig) map[string]cm.ParseFunc {
+	return map[string]cm.ParseFunc{
+		prefix + corsAllowOriginsKey:     asStrings(prefix+corsAllowOriginsKey, &cc.AllowOrigins),
+		prefix + corsAllowMethodsKey:     asStrings(prefix+corsAllowMethodsKey, &cc.AllowMethods),
+		prefix + corsAllowHeadersKey:     asStrings(prefix+corsAllowHeadersKey, &cc.AllowHeaders),
+		prefix + corsExposeHeadersKey:    asStrings(prefix+corsExposeHeadersKey, &cc.ExposeHeaders),
+		prefix + corsMaxAgeKey:           asDuration(prefix+corsMaxAgeKey, &cc.MaxAge),
+		prefix + corsAllowCredentialsKey: cm.AsBool(prefix+corsAllowCredentialsKey, &cc.AllowCredentials),
+	}
+}
+
+// validateCORS returns a field error for every CORS setting Envoy would reject,
+// naming the keys prefixed with prefix.
+func validateCORS(prefix string, cc *CORSConfig) *apis.FieldError {
+	var errs *apis.FieldError
+	for _, origin := range cc.AllowOrigins {
+		if _, err := originStringMatcher(origin); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(origin, prefix+corsAllowOriginsKey, err.Error()))
+		}
+	}
+	if cc.MaxAge < 0 {
+		errs = errs.Also(apis.ErrInvalidValue(cc.MaxAge, prefix+corsMaxAgeKey, "must not be negative"))
+	}
+	return errs
+}
+
+// originStringMatcher returns the matcher of the given allowed origin.
+func originStringMatcher(origin string) (*matcher.StringMatcher, error) {
+	switch {
+	case strings.HasPrefix(origin, originPrefixPrefix):
+		prefix := strings.TrimPrefix(origin, originPrefixPrefix)
+		if prefix == "" {
+			return nil, fmt.Errorf("empty prefix")
+		}
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Prefix{Prefix: prefix},
+		}, nil
+	case strings.HasPrefix(origin, originRegexPrefix):
+		regex := strings.TrimPrefix(origin, originRegexPrefix)
+		// Go's regular expressions share the RE2 syntax of Envoy's safe regexes.
+		if _, err := regexp.Compile(regex); err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_SafeRegex{
+				SafeRegex: &matcher.RegexMatcher{
+					EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+					Regex:      regex,
+				},
+			},
+		}, nil
+	default:
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Exact{Exact: origin},
+		}, nil
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+)
+
+// deprecatedEnv describes an environment variable the controller used to be
+// configured with before the respective config map key was introduced.
+type deprecatedEnv struct {
+	// name is the name of the environment variable.
+	name string
+	// key is the config map key replacing the environment variable.
+	key string
+	// value converts the value of the environment variable to the format of key.
+	value func(string) string
+}
+
+// deprecatedEnvs are read as a fallback for the keys missing in the config map.
+// Variables with the same key are listed in order of precedence.
+var deprecatedEnvs = []deprecatedEnv{
+	{name: "CERTS_SECRET_NAMESPACE", key: certsSecretNamespaceKey},
+	{name: "CERTS_SECRET_NAME", key: certsSecretNameKey},
+	// The HTTPOption used to be disabled by the variable being set at all.
+	{name: "KOURIER_HTTPOPTION_DISABLED", key: disableHTTPOptionKey, value: func(string) string { return "true" }},
+	{name: "KOURIER_EXTAUTHZ_HOST", key: extAuthzHostKey},
+	{name: "KOURIER_EXTAUTHZ_FAILURE_MODE_ALLOW", key: extAuthzFailureModeAllowKey},
+	{name: "KOURIER_EXTAUTHZ_FAILUREMODEALLOW", key: extAuthzFailureModeAllowKey},
+	{name: "KOURIER_EXTAUTHZ_MAX_REQUEST_BYTES", key: extAuthzMaxRequestBytesKey},
+	{name: "KOURIER_EXTAUTHZ_MAXREQUESTBYTES", key: extAuthzMaxRequestBytesKey},
+	// The timeout used to be given in milliseconds.
+	{name: "KOURIER_EXTAUTHZ_TIMEOUT", key: extAuthzTimeoutKey, value: func(v string) string { return v + "ms" }},
+}
+
+// DeprecatedEnv returns the deprecated environment variables that are set, mapped to
+// the config map keys replacing them.
+func DeprecatedEnv() map[string]string {
+	set := make(map[string]string)
+	for _, env := range deprecatedEnvs {
+		if _, ok := os.LookupEnv(env.name); ok {
+			set[env.name] = env.key
+		}
+	}
+	return set
+}
+
+// withDeprecatedEnv returns the supplied map with the keys missing in it taken from
+// the deprecated environment variables, if they're set. The supplied map is not
+// modified.
+func withDeprecatedEnv(configMap map[string]string) map[string]string {
+	var merged map[string]string
+	for _, env := range deprecatedEnvs {
+		raw, ok := os.LookupEnv(env.name)
+		if !ok {
+			continue
+		}
+		if _, ok := configMap[env.key]; ok {
+			continue
+		}
+		if _, ok := merged[env.key]; ok {
+			continue
+		}
+		if merged == nil {
+			merged = make(map[string]string, len(configMap)+len(deprecatedEnvs))
+			for k, v := range configMap {
+				merged[k] = v
+			}
+		}
+		if env.value != nil {
+			raw = env.value(raw)
+		}
+		merged[env.key] = raw
+	}
+	if merged == nil {
+		return configMap
+	}
+	return merged
+}
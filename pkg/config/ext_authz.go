@@ -19,7 +19,6 @@ package config
 import (
 	"fmt"
 	"net"
-	"os"
 	"strconv"
 	"time"
 
@@ -30,7 +29,6 @@ import (
 	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 	httpOptions "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
-	"github.com/kelseyhightower/envconfig"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
 )
@@ -41,56 +39,56 @@ const (
 	unixMaxPort = 65535
 )
 
-// ExternalAuthz is the configuration of external authorization.
-var ExternalAuthz = &ExternalAuthzConfig{
-	Enabled: false,
-}
-
 // ExternalAuthzConfig specifies parameters for external authorization configuration.
 type ExternalAuthzConfig struct {
-	Enabled    bool
-	Cluster    *v3Cluster.Cluster
-	HTTPFilter *hcm.HttpFilter
+	// Host is the address of the external authorization service as host:port.
+	// External authorization is disabled if empty.
+	Host string
+	// FailureModeAllow specifies whether requests are allowed if the external
+	// authorization service fails.
+	FailureModeAllow bool
+	// MaxRequestBytes is the maximum size of the request body sent to the external
+	// authorization service.
+	MaxRequestBytes uint32
+	// Timeout is the timeout of the requests to the external authorization service.
+	Timeout time.Duration
 }
 
-type config struct {
-	Host             string `split_words:"true"`
-	FailureModeAllow bool   `split_words:"true"`
-	MaxRequestBytes  uint32 `split_words:"true" default:"8192"`
-	Timeout          int    `split_words:"true" default:"2000"`
+// Enabled returns true if external authorization is configured.
+func (c *ExternalAuthzConfig) Enabled() bool {
+	return c.Host != ""
 }
 
-func init() {
-	if host := os.Getenv("KOURIER_EXTAUTHZ_HOST"); host == "" {
-		// No ExtAuthz setup.
-		return
-	}
+// Cluster returns the cluster of the external authorization service. Must only be
+// called if external authorization is enabled.
+func (c *ExternalAuthzConfig) Cluster() *v3Cluster.Cluster {
+	// The host has been validated when parsing the config.
+	host, port, _ := splitExternalAuthzHost(c.Host)
+	return extAuthzCluster(host, port)
+}
 
-	var env config
-	envconfig.MustProcess("KOURIER_EXTAUTHZ", &env)
+// HTTPFilter returns the filter calling the external authorization service. Must only
+// be called if external authorization is enabled.
+func (c *ExternalAuthzConfig) HTTPFilter() *hcm.HttpFilter {
+	return externalAuthZFilter(extAuthzClusterName, c.Timeout, c.FailureModeAllow, c.MaxRequestBytes)
+}
 
-	host, portStr, err := net.SplitHostPort(env.Host)
+func splitExternalAuthzHost(hostPort string) (string, uint32, error) {
+	host, portStr, err := net.SplitHostPort(hostPort)
 	if err != nil {
-		panic(err)
+		return "", 0, err
 	}
 
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
-		panic(err)
+		return "", 0, err
 	}
 
-	if port > unixMaxPort {
+	if port <= 0 || port > unixMaxPort {
 		// Bail out if we exceed the maximum port number.
-		panic(fmt.Sprintf("port %d bigger than %d", port, unixMaxPort))
-	}
-
-	timeout := time.Duration(env.Timeout) * time.Millisecond
-
-	ExternalAuthz = &ExternalAuthzConfig{
-		Enabled:    true,
-		Cluster:    extAuthzCluster(host, uint32(port)),
-		HTTPFilter: externalAuthZFilter(extAuthzClusterName, timeout, env.FailureModeAllow, env.MaxRequestBytes),
+		return "", 0, fmt.Errorf("port %d not between 1 and %d", port, unixMaxPort)
 	}
+	return host, uint32(port), nil
 }
 
 func extAuthzCluster(host string, port uint32) *v3Cluster.Cluster {
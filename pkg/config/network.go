@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	network "knative.dev/networking/pkg"
+	cm "knative.dev/pkg/configmap"
+)
+
+const (
+	// internalEncryptionKey is the config-network key for encrypting the traffic from
+	// the gateways to the Knative services.
+	internalEncryptionKey = "internal-encryption"
+
+	// clusterLocalDomainTLSKey is the config-network key for serving the cluster-local
+	// hosts via HTTPS.
+	clusterLocalDomainTLSKey = "cluster-local-domain-tls"
+)
+
+// Network holds the settings of Knative's config-network config map Kourier honors.
+// +k8s:deepcopy-gen=true
+type Network struct {
+	// AutoTLS specifies whether Knative provisions the certificates of the ingresses.
+	AutoTLS bool
+	// HTTPProtocol specifies how plain HTTP requests to external hosts are handled.
+	HTTPProtocol network.HTTPProtocol
+	// DefaultExternalScheme is the scheme of the external URLs if AutoTLS is disabled.
+	// It's https if TLS is terminated in front of the gateways.
+	DefaultExternalScheme string
+	// InternalEncryption specifies whether TLS is originated to the endpoints of all
+	// Knative services.
+	InternalEncryption bool
+	// ActivatorCA is the name of the secret in the system namespace holding the CA
+	// bundle the certificates of the endpoints are verified against, if any.
+	ActivatorCA string
+	// ActivatorSAN is the subject alternative name the certificates of the endpoints
+	// are verified against, if ActivatorCA is set.
+	ActivatorSAN string
+	// ClusterLocalDomainTLS specifies whether plain HTTP requests to cluster-local
+	// hosts with a certificate are redirected to HTTPS.
+	ClusterLocalDomainTLS bool
+}
+
+// DefaultNetwork returns the settings of an empty config-network config map.
+func DefaultNetwork() *Network {
+	nc, _ := NewNetworkFromMap(nil)
+	return nc
+}
+
+// NewNetworkFromMap creates a Network from the supplied config-network data.
+func NewNetworkFromMap(data map[string]string) (*Network, error) {
+	knc, err := network.NewConfigFromMap(data)
+	if err != nil {
+		return nil, err
+	}
+
+	nc := &Network{
+		AutoTLS:               knc.AutoTLS,
+		HTTPProtocol:          knc.HTTPProtocol,
+		DefaultExternalScheme: knc.DefaultExternalScheme,
+		ActivatorCA:           knc.ActivatorCA,
+		ActivatorSAN:          knc.ActivatorSAN,
+	}
+
+	// These keys are not known to the networking library (yet).
+	if err := cm.Parse(data,
+		cm.AsBool(internalEncryptionKey, &nc.InternalEncryption),
+		asEnabled(clusterLocalDomainTLSKey, &nc.ClusterLocalDomainTLS),
+	); err != nil {
+		return nil, err
+	}
+	return nc, nil
+}
+
+// NewNetworkFromConfigMap creates a Network from the supplied config-network
+// configMap.
+func NewNetworkFromConfigMap(config *corev1.ConfigMap) (*Network, error) {
+	return NewNetworkFromMap(config.Data)
+}
+
+// asEnabled parses the value at key as either enabled or disabled, like the
+// networking library does for its switches.
+func asEnabled(key string, target *bool) cm.ParseFunc {
+	return func(data map[string]string) error {
+		raw, ok := data[key]
+		if !ok {
+			return nil
+		}
+
+		switch strings.ToLower(raw) {
+		case "enabled":
+			*target = true
+		case "disabled", "":
+			*target = false
+		default:
+			return fmt.Errorf("failed to parse %q: %q is neither enabled nor disabled", key, raw)
+		}
+		return nil
+	}
+}
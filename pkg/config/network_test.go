@@ -0,0 +1,91 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	network "knative.dev/networking/pkg"
+)
+
+func TestNetworkConfig(t *testing.T) {
+	configTests := []struct {
+		name    string
+		wantErr bool
+		want    *Network
+		data    map[string]string
+	}{{
+		name: "default configuration",
+		want: &Network{
+			HTTPProtocol:          network.HTTPEnabled,
+			DefaultExternalScheme: "http",
+		},
+		data: map[string]string{},
+	}, {
+		name: "all settings",
+		want: &Network{
+			AutoTLS:               true,
+			HTTPProtocol:          network.HTTPRedirected,
+			DefaultExternalScheme: "https",
+			InternalEncryption:    true,
+			ActivatorCA:           "serving-ca",
+			ActivatorSAN:          "knative",
+			ClusterLocalDomainTLS: true,
+		},
+		data: map[string]string{
+			network.AutoTLSKey:               "enabled",
+			network.HTTPProtocolKey:          "redirected",
+			network.DefaultExternalSchemeKey: "https",
+			network.ActivatorCAKey:           "serving-ca",
+			network.ActivatorSANKey:          "knative",
+			internalEncryptionKey:            "true",
+			clusterLocalDomainTLSKey:         "Enabled",
+		},
+	}, {
+		name:    "invalid HTTP protocol",
+		wantErr: true,
+		data: map[string]string{
+			network.HTTPProtocolKey: "sometimes",
+		},
+	}, {
+		name:    "invalid internal encryption",
+		wantErr: true,
+		data: map[string]string{
+			internalEncryptionKey: "sometimes",
+		},
+	}, {
+		name:    "invalid cluster-local domain TLS",
+		wantErr: true,
+		data: map[string]string{
+			clusterLocalDomainTLSKey: "true",
+		},
+	}}
+
+	for _, tt := range configTests {
+		t.Run(tt.name, func(t *testing.T) {
+			actualCM, err := NewNetworkFromMap(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewNetworkFromMap() error = %v, WantErr %v", err, tt.wantErr)
+			}
+
+			if diff := cmp.Diff(actualCM, tt.want); diff != "" {
+				t.Errorf("Config mismatch: diff(-want,+got):\n%s", diff)
+			}
+		})
+	}
+}
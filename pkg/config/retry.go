@@ -0,0 +1,220 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/apis"
+	cm "knative.dev/pkg/configmap"
+)
+
+const (
+	// retryOnKey is the config map key for the comma separated conditions requests
+	// are retried on. Retries are disabled if empty.
+	retryOnKey = "retry-on"
+
+	// retryRetriableStatusCodesKey is the config map key for the comma separated
+	// status codes retried on with the retriable-status-codes condition.
+	retryRetriableStatusCodesKey = "retry-retriable-status-codes"
+
+	// retryAttemptsKey is the config map key for the number of retries of a request.
+	retryAttemptsKey = "retry-attempts"
+
+	// retryPerTryTimeoutKey is the config map key for the timeout of every attempt.
+	retryPerTryTimeoutKey = "retry-per-try-timeout"
+
+	// retryBackoffBaseIntervalKey and retryBackoffMaxIntervalKey are the config map
+	// keys for the intervals of the exponential backoff between the attempts.
+	retryBackoffBaseIntervalKey = "retry-backoff-base-interval"
+	retryBackoffMaxIntervalKey  = "retry-backoff-max-interval"
+
+	// retriableStatusCodesCondition is the retry condition the retriable status codes
+	// apply to.
+	retriableStatusCodesCondition = "retriable-status-codes"
+)
+
+// retryConditions are the retry conditions supported by Envoy, including the gRPC
+// ones.
+var retryConditions = sets.NewString(
+	"5xx",
+	"gateway-error",
+	"reset",
+	"connect-failure",
+	"envoy-ratelimited",
+	"retriable-4xx",
+	"refused-stream",
+	retriableStatusCodesCondition,
+	"retriable-headers",
+	"cancelled",
+	"deadline-exceeded",
+	"internal",
+	"resource-exhausted",
+	"unavailable",
+)
+
+// RetryConfig specifies how the requests to the services are retried. Retries are
+// disabled if On is empty.
+// +k8s:deepcopy-gen=true
+type RetryConfig struct {
+	// On are the conditions requests are retried on, e.g. connect-failure.
+	On []string
+	// RetriableStatusCodes are the status codes retried on with the
+	// retriable-status-codes condition.
+	RetriableStatusCodes []uint32
+	// Attempts is the number of retries of a request.
+	Attempts uint32
+	// PerTryTimeout is the timeout of every attempt. Zero leaves every attempt to
+	// the timeout of the route.
+	PerTryTimeout time.Duration
+	// BackoffBaseInterval and BackoffMaxInterval bound the exponential backoff
+	// between the attempts. Zero leaves them to the Envoy defaults.
+	BackoffBaseInterval time.Duration
+	BackoffMaxInterval  time.Duration
+}
+
+// Enabled returns true if requests are retried.
+func (c *RetryConfig) Enabled() bool {
+	return len(c.On) != 0
+}
+
+// Policy returns the retry policy of the routes, or nil if retries are disabled.
+func (c *RetryConfig) Policy() *route.RetryPolicy {
+	if !c.Enabled() {
+		return nil
+	}
+
+	policy := &route.RetryPolicy{
+		RetryOn:              strings.Join(c.On, ","),
+		NumRetries:           wrapperspb.UInt32(c.Attempts),
+		RetriableStatusCodes: c.RetriableStatusCodes,
+	}
+	if c.PerTryTimeout > 0 {
+		policy.PerTryTimeout = durationpb.New(c.PerTryTimeout)
+	}
+	if c.BackoffBaseInterval > 0 {
+		policy.RetryBackOff = &route.RetryPolicy_RetryBackOff{
+			BaseInterval: durationpb.New(c.BackoffBaseInterval),
+		}
+		if c.BackoffMaxInterval > 0 {
+			policy.RetryBackOff.MaxInterval = durationpb.New(c.BackoffMaxInterval)
+		}
+	}
+	return policy
+}
+
+// WithAnnotations returns a copy of the config overridden by the retry keys of
+// config-kourier prefixed with AnnotationPrefix in the given annotations, e.g.
+// kourier.knative.dev/retry-on. Setting kourier.knative.dev/retry-on to an empty
+// value disables retries.
+func (c *RetryConfig) WithAnnotations(annotations map[string]string) (*RetryConfig, error) {
+	rc := c.DeepCopy()
+
+	var errs *apis.FieldError
+	for key, parse := range retryKeyParsers(AnnotationPrefix, rc) {
+		if err := parse(annotations); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(annotations[key], key, err.Error()))
+		}
+	}
+	if errs = errs.Also(validateRetry(AnnotationPrefix, rc)); errs != nil {
+		return nil, errs
+	}
+	return rc, nil
+}
+
+// retryKeyParsers returns the parsers of the retry keys prefixed with prefix,
+// storing into rc.
+func retryKeyParsers(prefix string, rc *RetryConfig) map[string]cm.ParseFunc {
+	return map[string]cm.ParseFunc{
+		prefix + retryOnKey:                   asStrings(prefix+retryOnKey, &rc.On),
+		prefix + retryRetriableStatusCodesKey: asUint32s(prefix+retryRetriableStatusCodesKey, &rc.RetriableStatusCodes),
+		prefix + retryAttemptsKey:             cm.AsUint32(prefix+retryAttemptsKey, &rc.Attempts),
+		prefix + retryPerTryTimeoutKey:        asDuration(prefix+retryPerTryTimeoutKey, &rc.PerTryTimeout),
+		prefix + retryBackoffBaseIntervalKey:  asDuration(prefix+retryBackoffBaseIntervalKey, &rc.BackoffBaseInterval),
+		prefix + retryBackoffMaxIntervalKey:   asDuration(prefix+retryBackoffMaxIntervalKey, &rc.BackoffMaxInterval),
+	}
+}
+
+// validateRetry returns a field error for every retry setting Envoy would reject,
+// naming the keys prefixed with prefix.
+func validateRetry(prefix string, rc *RetryConfig) *apis.FieldError {
+	var errs *apis.FieldError
+	for _, condition := range rc.On {
+		if !retryConditions.Has(condition) {
+			errs = errs.Also(apis.ErrInvalidValue(condition, prefix+retryOnKey, "unsupported retry condition"))
+		}
+	}
+	retriesOnStatusCodes := sets.NewString(rc.On...).Has(retriableStatusCodesCondition)
+	if retriesOnStatusCodes && len(rc.RetriableStatusCodes) == 0 {
+		errs = errs.Also(apis.ErrGeneric("requires "+prefix+retryRetriableStatusCodesKey, prefix+retryOnKey))
+	}
+	for _, code := range rc.RetriableStatusCodes {
+		if code < 100 || code > 599 {
+			errs = errs.Also(apis.ErrInvalidValue(code, prefix+retryRetriableStatusCodesKey, "must be between 100 and 599"))
+		}
+	}
+	if rc.Attempts == 0 {
+		errs = errs.Also(apis.ErrInvalidValue(rc.Attempts, prefix+retryAttemptsKey, "must be positive"))
+	}
+	if rc.PerTryTimeout < 0 {
+		errs = errs.Also(apis.ErrInvalidValue(rc.PerTryTimeout, prefix+retryPerTryTimeoutKey, "must not be negative"))
+	}
+	if rc.BackoffBaseInterval < 0 {
+		errs = errs.Also(apis.ErrInvalidValue(rc.BackoffBaseInterval, prefix+retryBackoffBaseIntervalKey, "must not be negative"))
+	}
+	if rc.BackoffMaxInterval < 0 {
+		errs = errs.Also(apis.ErrInvalidValue(rc.BackoffMaxInterval, prefix+retryBackoffMaxIntervalKey, "must not be negative"))
+	}
+	if rc.BackoffMaxInterval > 0 && rc.BackoffMaxInterval < rc.BackoffBaseInterval {
+		errs = errs.Also(apis.ErrGeneric("must not be less than "+prefix+retryBackoffBaseIntervalKey, prefix+retryBackoffMaxIntervalKey))
+	}
+	if rc.BackoffMaxInterval > 0 && rc.BackoffBaseInterval == 0 {
+		errs = errs.Also(apis.ErrGeneric("requires "+prefix+retryBackoffBaseIntervalKey, prefix+retryBackoffMaxIntervalKey))
+	}
+	return errs
+}
+
+// asUint32s parses the value at key as a comma separated list of uint32s.
+func asUint32s(key string, target *[]uint32) cm.ParseFunc {
+	return func(data map[string]string) error {
+		raw, ok := data[key]
+		if !ok {
+			return nil
+		}
+
+		var values []uint32
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			value, err := strconv.ParseUint(item, 10, 32)
+			if err != nil {
+				return fmt.Errorf("failed to parse %q: %w", key, err)
+			}
+			values = append(values, uint32(value))
+		}
+		*target = values
+		return nil
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"time"
+
+	"knative.dev/pkg/apis"
+	cm "knative.dev/pkg/configmap"
+)
+
+const (
+	// routeTimeoutKey is the config map key for the timeout of the requests to the
+	// services, until their response is complete.
+	routeTimeoutKey = "route-timeout"
+
+	// routeIdleTimeoutKey is the config map key for the time the requests to the
+	// services may be idle.
+	routeIdleTimeoutKey = "route-idle-timeout"
+
+	// maxStreamDurationKey is the config map key for the maximum duration of the
+	// requests to the services, including streaming ones.
+	maxStreamDurationKey = "max-stream-duration"
+
+	// grpcTimeoutHeaderMaxKey is the config map key for the maximum timeout the
+	// grpc-timeout header of gRPC requests is honored up to.
+	grpcTimeoutHeaderMaxKey = "grpc-timeout-header-max"
+)
+
+// TimeoutConfig specifies the timeouts of the requests to the services. Zero
+// disables the respective timeout.
+// +k8s:deepcopy-gen=true
+type TimeoutConfig struct {
+	// Request is the timeout of a request until its response is complete. It
+	// applies to streaming requests as well, e.g. websockets, which are reset once
+	// it elapses.
+	Request time.Duration
+	// Idle is the time a request may be idle, i.e. without any data sent in either
+	// direction. The stream idle timeout of the gateways applies if zero.
+	Idle time.Duration
+	// MaxStreamDuration is the maximum duration of a request, including streaming
+	// ones.
+	MaxStreamDuration time.Duration
+	// GrpcTimeoutHeaderMax is the maximum timeout the grpc-timeout header of gRPC
+	// requests is honored up to, overriding MaxStreamDuration. The header is
+	// ignored if zero.
+	GrpcTimeoutHeaderMax time.Duration
+}
+
+// WithAnnotations returns a copy of the config overridden by the timeout keys of
+// config-kourier prefixed with AnnotationPrefix in the given annotations, e.g.
+// kourier.knative.dev/route-timeout.
+func (c *TimeoutConfig) WithAnnotations(annotations map[string]string) (*TimeoutConfig, error) {
+	tc := c.DeepCopy()
+
+	var errs *apis.FieldError
+	for key, parse := range timeoutKeyParsers(AnnotationPrefix, tc) {
+		if err := parse(annotations); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(annotations[key], key, err.Error()))
+		}
+	}
+	if errs = errs.Also(validateTimeouts(AnnotationPrefix, tc)); errs != nil {
+		return nil, errs
+	}
+	return tc, nil
+}
+
+// timeoutKeyParsers returns the parsers of the timeout keys prefixed with prefix,
+// storing into tc.
+func timeoutKeyParsers(prefix string, tc *TimeoutConfig) map[string]cm.ParseFunc {
+	return map[string]cm.ParseFunc{
+		prefix + routeTimeoutKey:         asDuration(prefix+routeTimeoutKey, &tc.Request),
+		prefix + routeIdleTimeoutKey:     asDuration(prefix+routeIdleTimeoutKey, &tc.Idle),
+		prefix + maxStreamDurationKey:    asDuration(prefix+maxStreamDurationKey, &tc.MaxStreamDuration),
+		prefix + grpcTimeoutHeaderMaxKey: asDuration(prefix+grpcTimeoutHeaderMaxKey, &tc.GrpcTimeoutHeaderMax),
+	}
+}
+
+// validateTimeouts returns a field error for every negative timeout, naming the
+// keys prefixed with prefix.
+func validateTimeouts(prefix string, tc *TimeoutConfig) *apis.FieldError {
+	var errs *apis.FieldError
+	for key, timeout := range map[string]time.Duration{
+		routeTimeoutKey:         tc.Request,
+		routeIdleTimeoutKey:     tc.Idle,
+		maxStreamDurationKey:    tc.MaxStreamDuration,
+		grpcTimeoutHeaderMaxKey: tc.GrpcTimeoutHeaderMax,
+	} {
+		if timeout < 0 {
+			errs = errs.Also(apis.ErrInvalidValue(timeout, prefix+key, "must not be negative"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// tlsVersions maps the TLS versions accepted in the config map to their Envoy
+// counterparts.
+var tlsVersions = map[string]auth.TlsParameters_TlsProtocol{
+	"1.0": auth.TlsParameters_TLSv1_0,
+	"1.1": auth.TlsParameters_TLSv1_1,
+	"1.2": auth.TlsParameters_TLSv1_2,
+	"1.3": auth.TlsParameters_TLSv1_3,
+}
+
+// tlsCipherSuites are the cipher suites Envoy supports for TLS 1.0 to 1.2. The
+// TLS 1.3 cipher suites are not configurable.
+var tlsCipherSuites = sets.NewString(
+	"ECDHE-ECDSA-AES128-GCM-SHA256",
+	"ECDHE-RSA-AES128-GCM-SHA256",
+	"ECDHE-ECDSA-AES256-GCM-SHA384",
+	"ECDHE-RSA-AES256-GCM-SHA384",
+	"ECDHE-ECDSA-CHACHA20-POLY1305",
+	"ECDHE-RSA-CHACHA20-POLY1305",
+	"ECDHE-PSK-CHACHA20-POLY1305",
+	"ECDHE-ECDSA-AES128-SHA",
+	"ECDHE-RSA-AES128-SHA",
+	"ECDHE-PSK-AES128-CBC-SHA",
+	"ECDHE-ECDSA-AES256-SHA",
+	"ECDHE-RSA-AES256-SHA",
+	"ECDHE-PSK-AES256-CBC-SHA",
+	"AES128-GCM-SHA256",
+	"AES256-GCM-SHA384",
+	"AES128-SHA",
+	"PSK-AES128-CBC-SHA",
+	"AES256-SHA",
+	"PSK-AES256-CBC-SHA",
+	"DES-CBC3-SHA",
+)
+
+// tlsECDHCurves are the elliptic curves Envoy supports for ECDH.
+var tlsECDHCurves = sets.NewString(
+	"X25519",
+	"P-256",
+	"P-384",
+	"P-521",
+)
+
+// TLSConfig specifies the TLS parameters of the connections to the HTTPS listeners.
+// Empty values are left to the Envoy defaults.
+// +k8s:deepcopy-gen=true
+type TLSConfig struct {
+	// MinVersion and MaxVersion bound the TLS versions accepted, as 1.0 to 1.3.
+	MinVersion string
+	MaxVersion string
+	// CipherSuites are the cipher suites accepted for TLS 1.2 and below, in order of
+	// preference. A group of equally preferred cipher suites is written as [A|B].
+	CipherSuites []string
+	// ECDHCurves are the elliptic curves accepted for ECDH, in order of preference.
+	ECDHCurves []string
+}
+
+// Parameters returns the Envoy TLS parameters, nil if all are left to the Envoy
+// defaults.
+func (c *TLSConfig) Parameters() *auth.TlsParameters {
+	if c.MinVersion == "" && c.MaxVersion == "" && len(c.CipherSuites) == 0 && len(c.ECDHCurves) == 0 {
+		return nil
+	}
+
+	// The versions have been validated when parsing the config, unset ones map to
+	// TLS_AUTO.
+	return &auth.TlsParameters{
+		TlsMinimumProtocolVersion: tlsVersions[c.MinVersion],
+		TlsMaximumProtocolVersion: tlsVersions[c.MaxVersion],
+		CipherSuites:              c.CipherSuites,
+		EcdhCurves:                c.ECDHCurves,
+	}
+}
+
+// validateTLSVersions returns an error if the given versions are not supported or
+// contradict each other.
+func validateTLSVersions(minVersion, maxVersion string) error {
+	for _, version := range []string{minVersion, maxVersion} {
+		if _, ok := tlsVersions[version]; version != "" && !ok {
+			return fmt.Errorf("unsupported TLS version %q", version)
+		}
+	}
+	if minVersion != "" && maxVersion != "" && tlsVersions[minVersion] > tlsVersions[maxVersion] {
+		return fmt.Errorf("minimum TLS version %s is greater than maximum TLS version %s", minVersion, maxVersion)
+	}
+	return nil
+}
+
+// validateTLSCipherSuites returns an error if any of the given cipher suites, or
+// groups of equally preferred ones, is not supported.
+func validateTLSCipherSuites(cipherSuites []string) error {
+	for _, cipherSuite := range cipherSuites {
+		members := []string{cipherSuite}
+		if strings.HasPrefix(cipherSuite, "[") && strings.HasSuffix(cipherSuite, "]") {
+			members = strings.Split(strings.TrimSuffix(strings.TrimPrefix(cipherSuite, "["), "]"), "|")
+		}
+		for _, member := range members {
+			if !tlsCipherSuites.Has(member) {
+				return fmt.Errorf("unsupported cipher suite %q", member)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTLSECDHCurves returns an error if any of the given curves is not supported.
+func validateTLSECDHCurves(curves []string) error {
+	for _, curve := range curves {
+		if !tlsECDHCurves.Has(curve) {
+			return fmt.Errorf("unsupported ECDH curve %q", curve)
+		}
+	}
+	return nil
+}
@@ -21,9 +21,84 @@ limitations under the License.
 
 package config
 
+import (
+	types "k8s.io/apimachinery/pkg/types"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CORSConfig) DeepCopyInto(out *CORSConfig) {
+	*out = *in
+	if in.AllowOrigins != nil {
+		in, out := &in.AllowOrigins, &out.AllowOrigins
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowMethods != nil {
+		in, out := &in.AllowMethods, &out.AllowMethods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowHeaders != nil {
+		in, out := &in.AllowHeaders, &out.AllowHeaders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExposeHeaders != nil {
+		in, out := &in.ExposeHeaders, &out.ExposeHeaders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CORSConfig.
+func (in *CORSConfig) DeepCopy() *CORSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(CORSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Kourier) DeepCopyInto(out *Kourier) {
 	*out = *in
+	if in.WildcardCertsSecrets != nil {
+		in, out := &in.WildcardCertsSecrets, &out.WildcardCertsSecrets
+		*out = make([]types.NamespacedName, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterLocalCertsSecret != nil {
+		in, out := &in.ClusterLocalCertsSecret, &out.ClusterLocalCertsSecret
+		*out = new(types.NamespacedName)
+		**out = **in
+	}
+	if in.ClientCASecret != nil {
+		in, out := &in.ClientCASecret, &out.ClientCASecret
+		*out = new(types.NamespacedName)
+		**out = **in
+	}
+	in.TLS.DeepCopyInto(&out.TLS)
+	if in.UpstreamCASecret != nil {
+		in, out := &in.UpstreamCASecret, &out.UpstreamCASecret
+		*out = new(types.NamespacedName)
+		**out = **in
+	}
+	if in.UpstreamSubjectAltNames != nil {
+		in, out := &in.UpstreamSubjectAltNames, &out.UpstreamSubjectAltNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.UpstreamClientCertsSecret != nil {
+		in, out := &in.UpstreamClientCertsSecret, &out.UpstreamClientCertsSecret
+		*out = new(types.NamespacedName)
+		**out = **in
+	}
+	out.ExternalAuthz = in.ExternalAuthz
+	out.Timeout = in.Timeout
+	in.Retry.DeepCopyInto(&out.Retry)
+	in.CORS.DeepCopyInto(&out.CORS)
 	return
 }
 
@@ -36,3 +111,87 @@ func (in *Kourier) DeepCopy() *Kourier {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Network) DeepCopyInto(out *Network) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Network.
+func (in *Network) DeepCopy() *Network {
+	if in == nil {
+		return nil
+	}
+	out := new(Network)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RetryConfig) DeepCopyInto(out *RetryConfig) {
+	*out = *in
+	if in.On != nil {
+		in, out := &in.On, &out.On
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RetriableStatusCodes != nil {
+		in, out := &in.RetriableStatusCodes, &out.RetriableStatusCodes
+		*out = make([]uint32, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RetryConfig.
+func (in *RetryConfig) DeepCopy() *RetryConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RetryConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TLSConfig) DeepCopyInto(out *TLSConfig) {
+	*out = *in
+	if in.CipherSuites != nil {
+		in, out := &in.CipherSuites, &out.CipherSuites
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ECDHCurves != nil {
+		in, out := &in.ECDHCurves, &out.ECDHCurves
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSConfig.
+func (in *TLSConfig) DeepCopy() *TLSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TimeoutConfig) DeepCopyInto(out *TimeoutConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TimeoutConfig.
+func (in *TimeoutConfig) DeepCopy() *TimeoutConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(TimeoutConfig)
+	in.DeepCopyInto(out)
+	return out
+}
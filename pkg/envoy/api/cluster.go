@@ -20,10 +20,15 @@ import (
 	"time"
 
 	envoyCluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	httpOptions "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // NewCluster generates a new v3.Cluster with the given settings.
@@ -40,27 +45,135 @@ func NewCluster(
 			Type: discoveryType,
 		},
 		ConnectTimeout: durationpb.New(connectTimeout),
-		LoadAssignment: &endpoint.ClusterLoadAssignment{
-			ClusterName: name,
-			Endpoints: []*endpoint.LocalityLbEndpoints{{
-				LbEndpoints: endpoints,
-			}},
+		LoadAssignment: NewClusterLoadAssignment(name, endpoints),
+	}
+
+	if isHTTP2 {
+		setHTTP2ProtocolOptions(cluster)
+	}
+
+	return cluster
+}
+
+// NewEDSCluster generates a new v3.Cluster whose endpoints are fetched via EDS. The
+// endpoints are expected to be served as a ClusterLoadAssignment with the same name
+// as the cluster.
+func NewEDSCluster(
+	name string,
+	connectTimeout time.Duration,
+	isHTTP2 bool) *envoyCluster.Cluster {
+
+	cluster := &envoyCluster.Cluster{
+		Name: name,
+		ClusterDiscoveryType: &envoyCluster.Cluster_Type{
+			Type: envoyCluster.Cluster_EDS,
+		},
+		ConnectTimeout: durationpb.New(connectTimeout),
+		EdsClusterConfig: &envoyCluster.Cluster_EdsClusterConfig{
+			EdsConfig:   adsConfigSource(),
+			ServiceName: name,
 		},
 	}
 
 	if isHTTP2 {
-		opts, _ := anypb.New(&httpOptions.HttpProtocolOptions{
-			UpstreamProtocolOptions: &httpOptions.HttpProtocolOptions_ExplicitHttpConfig_{
-				ExplicitHttpConfig: &httpOptions.HttpProtocolOptions_ExplicitHttpConfig{
-					ProtocolConfig: &httpOptions.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{},
-				},
+		setHTTP2ProtocolOptions(cluster)
+	}
+
+	return cluster
+}
+
+func setHTTP2ProtocolOptions(cluster *envoyCluster.Cluster) {
+	opts, _ := anypb.New(&httpOptions.HttpProtocolOptions{
+		UpstreamProtocolOptions: &httpOptions.HttpProtocolOptions_ExplicitHttpConfig_{
+			ExplicitHttpConfig: &httpOptions.HttpProtocolOptions_ExplicitHttpConfig{
+				ProtocolConfig: &httpOptions.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{},
 			},
+		},
+	})
+
+	cluster.TypedExtensionProtocolOptions = map[string]*anypb.Any{
+		"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": opts,
+	}
+}
+
+// UpstreamTLS holds the certificates of the TLS connections to the endpoints of the
+// clusters.
+//
+// Like the certificates of the listeners, the CA bundle and the client certificate
+// are served via SDS.
+type UpstreamTLS struct {
+	// CASource is the secret holding the CA bundle.
+	CASource types.NamespacedName
+	// CABundle holds the PEM encoded CAs the certificates of the endpoints are
+	// verified against.
+	CABundle []byte
+	// SubjectAltNames restricts the certificates of the endpoints to the ones with any
+	// of these subject alternative names, if not empty.
+	SubjectAltNames []string
+	// MatchSNI restricts the certificates of the endpoints to the ones with the server
+	// name sent as subject alternative name, if SubjectAltNames is empty.
+	MatchSNI bool
+	// ClientCertificate is presented to the endpoints if set.
+	ClientCertificate *Certificate
+}
+
+// Secrets returns the secrets the clusters originating TLS with the given
+// certificates refer to, to be served via SDS.
+func (tls *UpstreamTLS) Secrets() []*auth.Secret {
+	secrets := []*auth.Secret{NewValidationSecret(ValidationSecretName(tls.CASource), tls.CABundle)}
+	if tls.ClientCertificate != nil {
+		secrets = append(secrets, NewSecret(SecretName(tls.ClientCertificate.CertSource),
+			tls.ClientCertificate.CertificateChain, tls.ClientCertificate.PrivateKey))
+	}
+	return secrets
+}
+
+// SetUpstreamTLS makes the given cluster originate TLS connections to its endpoints,
+// sending the given server name. The certificates are referenced by name, the
+// secrets returned by tls.Secrets() have to be served along with the cluster.
+func SetUpstreamTLS(cluster *envoyCluster.Cluster, tls *UpstreamTLS, sni string, isHTTP2 bool) error {
+	sans := tls.SubjectAltNames
+	if len(sans) == 0 && tls.MatchSNI {
+		sans = []string{sni}
+	}
+	// The SAN matchers are combined with the CA bundle served via SDS.
+	sanMatchers := make([]*matcher.StringMatcher, 0, len(sans))
+	for _, san := range sans {
+		sanMatchers = append(sanMatchers, &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Exact{Exact: san},
 		})
+	}
 
-		cluster.TypedExtensionProtocolOptions = map[string]*anypb.Any{
-			"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": opts,
+	commonTLSContext := &auth.CommonTlsContext{
+		ValidationContextType: &auth.CommonTlsContext_CombinedValidationContext{
+			CombinedValidationContext: &auth.CommonTlsContext_CombinedCertificateValidationContext{
+				DefaultValidationContext: &auth.CertificateValidationContext{
+					MatchSubjectAltNames: sanMatchers,
+				},
+				ValidationContextSdsSecretConfig: newSdsSecretConfig(ValidationSecretName(tls.CASource)),
+			},
+		},
+	}
+	if tls.ClientCertificate != nil {
+		commonTLSContext.TlsCertificateSdsSecretConfigs = []*auth.SdsSecretConfig{
+			newSdsSecretConfig(SecretName(tls.ClientCertificate.CertSource)),
 		}
 	}
+	if isHTTP2 {
+		commonTLSContext.AlpnProtocols = []string{"h2"}
+	}
 
-	return cluster
+	tlsAny, err := anypb.New(&auth.UpstreamTlsContext{
+		CommonTlsContext: commonTLSContext,
+		Sni:              sni,
+	})
+	if err != nil {
+		return err
+	}
+
+	cluster.TransportSocket = &core.TransportSocket{
+		Name:       wellknown.TransportSocketTls,
+		ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: tlsAny},
+	}
+	return nil
 }
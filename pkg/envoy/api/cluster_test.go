@@ -22,8 +22,13 @@ import (
 
 	v3Cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/anypb"
 	"gotest.tools/v3/assert"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 func TestNewCluster(t *testing.T) {
@@ -45,3 +50,106 @@ func TestNewCluster(t *testing.T) {
 	c = NewCluster(name, connectTimeout, endpoints, false, v3Cluster.Cluster_STATIC)
 	assert.Assert(t, c.TypedExtensionProtocolOptions["envoy.extensions.upstreams.http.v3.HttpProtocolOptions"] == nil)
 }
+
+func TestNewEDSCluster(t *testing.T) {
+	name := "myTestCluster_12345"
+	connectTimeout := 5 * time.Second
+
+	// With HTTP2
+	c := NewEDSCluster(name, connectTimeout, true)
+	assert.Equal(t, c.GetConnectTimeout().Seconds, int64(connectTimeout.Seconds()))
+	assert.Assert(t, c.TypedExtensionProtocolOptions["envoy.extensions.upstreams.http.v3.HttpProtocolOptions"] != nil)
+	assert.Equal(t, c.GetName(), name)
+	assert.Equal(t, c.GetType(), v3Cluster.Cluster_EDS)
+	assert.Equal(t, c.EdsClusterConfig.ServiceName, name)
+	assert.Assert(t, c.EdsClusterConfig.EdsConfig.GetAds() != nil)
+	assert.Assert(t, c.LoadAssignment == nil)
+
+	// Without HTTP2
+	c = NewEDSCluster(name, connectTimeout, false)
+	assert.Assert(t, c.TypedExtensionProtocolOptions["envoy.extensions.upstreams.http.v3.HttpProtocolOptions"] == nil)
+}
+
+func TestSetUpstreamTLS(t *testing.T) {
+	getUpstreamTLSContext := func(c *v3Cluster.Cluster) *auth.UpstreamTlsContext {
+		assert.Equal(t, c.TransportSocket.Name, wellknown.TransportSocketTls)
+		tlsContext := &auth.UpstreamTlsContext{}
+		err := anypb.UnmarshalTo(c.TransportSocket.GetTypedConfig(), tlsContext, proto.UnmarshalOptions{})
+		assert.NilError(t, err)
+		return tlsContext
+	}
+	caSource := types.NamespacedName{Namespace: "kourier-system", Name: "upstream-ca"}
+	certSource := types.NamespacedName{Namespace: "kourier-system", Name: "upstream-client"}
+
+	// Without a client certificate.
+	c := NewEDSCluster("servicens/servicename", 5*time.Second, false)
+	err := SetUpstreamTLS(c, &UpstreamTLS{CASource: caSource, CABundle: []byte("ca")}, "servicename.servicens.svc.cluster.local", false)
+	assert.NilError(t, err)
+
+	tlsContext := getUpstreamTLSContext(c)
+	assert.Equal(t, tlsContext.Sni, "servicename.servicens.svc.cluster.local")
+	validationContext := tlsContext.CommonTlsContext.GetCombinedValidationContext()
+	assert.Equal(t, validationContext.ValidationContextSdsSecretConfig.Name, "kourier-system/upstream-ca/ca")
+	assert.Equal(t, len(validationContext.DefaultValidationContext.MatchSubjectAltNames), 0)
+	// The CA bundle is only served via SDS.
+	assert.Assert(t, validationContext.DefaultValidationContext.TrustedCa == nil)
+	assert.Equal(t, len(tlsContext.CommonTlsContext.TlsCertificates), 0)
+	assert.Equal(t, len(tlsContext.CommonTlsContext.TlsCertificateSdsSecretConfigs), 0)
+	assert.Equal(t, len(tlsContext.CommonTlsContext.AlpnProtocols), 0)
+
+	// With subject alternative names.
+	c = NewEDSCluster("servicens/servicename", 5*time.Second, false)
+	err = SetUpstreamTLS(c, &UpstreamTLS{CASource: caSource, CABundle: []byte("ca"), SubjectAltNames: []string{"knative"}}, "servicename.servicens.svc.cluster.local", false)
+	assert.NilError(t, err)
+
+	sans := getUpstreamTLSContext(c).CommonTlsContext.GetCombinedValidationContext().DefaultValidationContext.MatchSubjectAltNames
+	assert.Equal(t, len(sans), 1)
+	assert.Equal(t, sans[0].GetExact(), "knative")
+
+	// Matching the server name.
+	c = NewEDSCluster("servicens/servicename", 5*time.Second, false)
+	err = SetUpstreamTLS(c, &UpstreamTLS{CASource: caSource, CABundle: []byte("ca"), MatchSNI: true}, "servicename.servicens.svc.cluster.local", false)
+	assert.NilError(t, err)
+
+	sans = getUpstreamTLSContext(c).CommonTlsContext.GetCombinedValidationContext().DefaultValidationContext.MatchSubjectAltNames
+	assert.Equal(t, len(sans), 1)
+	assert.Equal(t, sans[0].GetExact(), "servicename.servicens.svc.cluster.local")
+
+	// Subject alternative names take precedence over the server name.
+	c = NewEDSCluster("servicens/servicename", 5*time.Second, false)
+	err = SetUpstreamTLS(c, &UpstreamTLS{CASource: caSource, CABundle: []byte("ca"), SubjectAltNames: []string{"knative"}, MatchSNI: true}, "servicename.servicens.svc.cluster.local", false)
+	assert.NilError(t, err)
+
+	sans = getUpstreamTLSContext(c).CommonTlsContext.GetCombinedValidationContext().DefaultValidationContext.MatchSubjectAltNames
+	assert.Equal(t, len(sans), 1)
+	assert.Equal(t, sans[0].GetExact(), "knative")
+
+	// With a client certificate and HTTP2.
+	tls := &UpstreamTLS{
+		CASource: caSource,
+		CABundle: []byte("ca"),
+		ClientCertificate: &Certificate{
+			CertSource:       certSource,
+			CertificateChain: []byte("cert"),
+			PrivateKey:       []byte("key"),
+		},
+	}
+	c = NewEDSCluster("servicens/servicename", 5*time.Second, true)
+	err = SetUpstreamTLS(c, tls, "servicename.servicens.svc.cluster.local", true)
+	assert.NilError(t, err)
+
+	tlsContext = getUpstreamTLSContext(c)
+	assert.Equal(t, len(tlsContext.CommonTlsContext.TlsCertificates), 0)
+	assert.Equal(t, len(tlsContext.CommonTlsContext.TlsCertificateSdsSecretConfigs), 1)
+	assert.Equal(t, tlsContext.CommonTlsContext.TlsCertificateSdsSecretConfigs[0].Name, "kourier-system/upstream-client")
+	assert.DeepEqual(t, tlsContext.CommonTlsContext.AlpnProtocols, []string{"h2"})
+
+	// The secrets referenced by the cluster.
+	secrets := tls.Secrets()
+	assert.Equal(t, len(secrets), 2)
+	assert.Equal(t, secrets[0].Name, "kourier-system/upstream-ca/ca")
+	assert.DeepEqual(t, secrets[0].GetValidationContext().TrustedCa.GetInlineBytes(), []byte("ca"))
+	assert.Equal(t, secrets[1].Name, "kourier-system/upstream-client")
+	assert.DeepEqual(t, secrets[1].GetTlsCertificate().CertificateChain.GetInlineBytes(), []byte("cert"))
+	assert.DeepEqual(t, secrets[1].GetTlsCertificate().PrivateKey.GetInlineBytes(), []byte("key"))
+}
@@ -17,22 +17,31 @@ limitations under the License.
 package envoy
 
 import (
+	"sort"
+
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
-// headersToAdd generates a list of HeaderValueOption from a map of headers.
+// headersToAdd generates a list of HeaderValueOption from a map of headers. The
+// list is sorted by header name to generate the same configuration every time.
 func headersToAdd(headers map[string]string) []*core.HeaderValueOption {
 	if len(headers) == 0 {
 		return nil
 	}
 
+	names := make([]string, 0, len(headers))
+	for headerName := range headers {
+		names = append(names, headerName)
+	}
+	sort.Strings(names)
+
 	res := make([]*core.HeaderValueOption, 0, len(headers))
-	for headerName, headerVal := range headers {
+	for _, headerName := range names {
 		res = append(res, &core.HeaderValueOption{
 			Header: &core.HeaderValue{
 				Key:   headerName,
-				Value: headerVal,
+				Value: headers[headerName],
 			},
 			// In Knative Serving, headers are set instead of appended.
 			// Ref: https://github.com/knative/serving/pull/6366
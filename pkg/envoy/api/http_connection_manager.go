@@ -17,29 +17,31 @@ limitations under the License.
 package envoy
 
 import (
-	"time"
-
 	accesslog_v3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
-	envoy_api_v3_core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	accesslog_file_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
 	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
-	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
 	"google.golang.org/protobuf/types/known/anypb"
-	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
-
-	"knative.dev/net-kourier/pkg/config"
 )
 
 // NewHTTPConnectionManager creates a new HttpConnectionManager that points to the given
-// RouteConfig for further configuration.
-func NewHTTPConnectionManager(routeConfigName string, enableAccessLog, enableProxyProtocol bool) *hcm.HttpConnectionManager {
-	filters := make([]*hcm.HttpFilter, 0, 1)
+// RouteConfig for further configuration. The external authorization filter is added
+// if it's not nil.
+func NewHTTPConnectionManager(routeConfigName string, enableAccessLog, enableProxyProtocol bool, extAuthzFilter *hcm.HttpFilter) *hcm.HttpConnectionManager {
+	filters := make([]*hcm.HttpFilter, 0, 3)
+
+	// The CORS filter answers preflight requests before they're authorized or reach
+	// the services, which might be scaled to zero. It only acts on virtual hosts
+	// with a CORS policy.
+	filters = append(filters, &hcm.HttpFilter{
+		Name: wellknown.CORS,
+	})
 
-	if config.ExternalAuthz.Enabled {
-		filters = append(filters, config.ExternalAuthz.HTTPFilter)
+	if extAuthzFilter != nil {
+		filters = append(filters, extAuthzFilter)
 	}
 
 	// Append the Router filter at the end.
@@ -53,13 +55,7 @@ func NewHTTPConnectionManager(routeConfigName string, enableAccessLog, enablePro
 		HttpFilters: filters,
 		RouteSpecifier: &hcm.HttpConnectionManager_Rds{
 			Rds: &hcm.Rds{
-				ConfigSource: &envoy_api_v3_core.ConfigSource{
-					ResourceApiVersion: resource.DefaultAPIVersion,
-					ConfigSourceSpecifier: &envoy_api_v3_core.ConfigSource_Ads{
-						Ads: &envoy_api_v3_core.AggregatedConfigSource{},
-					},
-					InitialFetchTimeout: durationpb.New(10 * time.Second),
-				},
+				ConfigSource:    adsConfigSource(),
 				RouteConfigName: routeConfigName,
 			},
 		},
@@ -87,6 +83,26 @@ func NewHTTPConnectionManager(routeConfigName string, enableAccessLog, enablePro
 	return mgr
 }
 
+// ForwardClientCertDetails makes the given manager pass the details of verified client
+// certificates on in the x-forwarded-client-cert header. The header is removed from
+// requests without a client certificate, so it can't be spoofed by clients.
+func ForwardClientCertDetails(manager *hcm.HttpConnectionManager) {
+	manager.ForwardClientCertDetails = hcm.HttpConnectionManager_SANITIZE_SET
+	manager.SetCurrentClientCertDetails = &hcm.HttpConnectionManager_SetCurrentClientCertDetails{
+		Subject: wrapperspb.Bool(true),
+		Uri:     true,
+		Dns:     true,
+	}
+}
+
+// OverwriteScheme makes the given manager overwrite the scheme of all requests with
+// the given one, e.g. if TLS is terminated in front of the gateways.
+func OverwriteScheme(manager *hcm.HttpConnectionManager, scheme string) {
+	manager.SchemeHeaderTransformation = &core.SchemeHeaderTransformation{
+		Transformation: &core.SchemeHeaderTransformation_SchemeToOverwrite{SchemeToOverwrite: scheme},
+	}
+}
+
 // NewRouteConfig create a new RouteConfiguration with the given name and hosts.
 func NewRouteConfig(name string, virtualHosts []*route.VirtualHost) *route.RouteConfiguration {
 	return &route.RouteConfiguration{
@@ -22,6 +22,8 @@ import (
 	envoy_config_filter_accesslog_v3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	fileaccesslog "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/anypb"
@@ -30,13 +32,13 @@ import (
 )
 
 func TestNewHTTPConnectionManagerWithoutAccessLogWithoutProxyProtocol(t *testing.T) {
-	connManager := NewHTTPConnectionManager("test", false /*enableAccessLog*/, false /*enableProxyProtocol*/)
+	connManager := NewHTTPConnectionManager("test", false /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
 	assert.Check(t, len(connManager.AccessLog) == 0)
 	assert.Check(t, connManager.UseRemoteAddress == nil)
 }
 
 func TestNewHTTPConnectionManagerWithAccessLogWithoutProxyProtocol(t *testing.T) {
-	connManager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/)
+	connManager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
 	assert.Check(t, connManager.UseRemoteAddress == nil)
 	accessLog := connManager.AccessLog[0]
 	accessLogPathAny := accessLog.ConfigType.(*envoy_config_filter_accesslog_v3.AccessLog_TypedConfig).TypedConfig
@@ -51,14 +53,14 @@ func TestNewHTTPConnectionManagerWithAccessLogWithoutProxyProtocol(t *testing.T)
 }
 
 func TestNewHTTPConnectionManagerWithoutAccessLogWithProxyProtocol(t *testing.T) {
-	connManager := NewHTTPConnectionManager("test", false /*enableAccessLog*/, true /*enableProxyProtocol*/)
+	connManager := NewHTTPConnectionManager("test", false /*enableAccessLog*/, true /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
 	assert.Check(t, len(connManager.AccessLog) == 0)
 	assert.Check(t, connManager.UseRemoteAddress != nil)
 	assert.Check(t, connManager.UseRemoteAddress.Value)
 }
 
 func TestNewHTTPConnectionManagerWithAccessLogWithProxyProtocol(t *testing.T) {
-	connManager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, true /*enableProxyProtocol*/)
+	connManager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, true /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
 	assert.Check(t, connManager.UseRemoteAddress != nil)
 	assert.Check(t, connManager.UseRemoteAddress.Value)
 	accessLog := connManager.AccessLog[0]
@@ -73,6 +75,42 @@ func TestNewHTTPConnectionManagerWithAccessLogWithProxyProtocol(t *testing.T) {
 	assert.Equal(t, "/dev/stdout", fileAccesLog.Path)
 }
 
+func TestNewHTTPConnectionManagerFilters(t *testing.T) {
+	connManager := NewHTTPConnectionManager("test", false /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
+	assert.DeepEqual(t, filterNames(connManager), []string{wellknown.CORS, wellknown.Router})
+
+	extAuthzFilter := &hcm.HttpFilter{Name: wellknown.HTTPExternalAuthorization}
+	connManager = NewHTTPConnectionManager("test", false /*enableAccessLog*/, false /*enableProxyProtocol*/, extAuthzFilter)
+	// Preflight requests are answered before they're authorized.
+	assert.DeepEqual(t, filterNames(connManager), []string{wellknown.CORS, wellknown.HTTPExternalAuthorization, wellknown.Router})
+}
+
+func filterNames(connManager *hcm.HttpConnectionManager) []string {
+	names := make([]string, 0, len(connManager.HttpFilters))
+	for _, filter := range connManager.HttpFilters {
+		names = append(names, filter.Name)
+	}
+	return names
+}
+
+func TestForwardClientCertDetails(t *testing.T) {
+	connManager := NewHTTPConnectionManager("test", false /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
+	ForwardClientCertDetails(connManager)
+
+	assert.Equal(t, hcm.HttpConnectionManager_SANITIZE_SET, connManager.ForwardClientCertDetails)
+	assert.Check(t, connManager.SetCurrentClientCertDetails.Subject.GetValue())
+	assert.Check(t, connManager.SetCurrentClientCertDetails.Uri)
+	assert.Check(t, connManager.SetCurrentClientCertDetails.Dns)
+}
+
+func TestOverwriteScheme(t *testing.T) {
+	connManager := NewHTTPConnectionManager("test", false /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
+	assert.Check(t, connManager.SchemeHeaderTransformation == nil)
+
+	OverwriteScheme(connManager, "https")
+	assert.Equal(t, connManager.SchemeHeaderTransformation.GetSchemeToOverwrite(), "https")
+}
+
 func TestNewRouteConfig(t *testing.T) {
 	vhost := NewVirtualHost(
 		"test",
@@ -42,3 +42,14 @@ func NewLBEndpoint(ip string, port uint32) *endpoint.LbEndpoint {
 		},
 	}
 }
+
+// NewClusterLoadAssignment creates a new ClusterLoadAssignment for the cluster with
+// the given name.
+func NewClusterLoadAssignment(clusterName string, endpoints []*endpoint.LbEndpoint) *endpoint.ClusterLoadAssignment {
+	return &endpoint.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints: []*endpoint.LocalityLbEndpoints{{
+			LbEndpoints: endpoints,
+		}},
+	}
+}
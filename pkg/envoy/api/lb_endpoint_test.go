@@ -21,6 +21,7 @@ import (
 
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	v3Endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"google.golang.org/protobuf/testing/protocmp"
 	"gotest.tools/v3/assert"
 )
 
@@ -35,3 +36,12 @@ func TestNewLBEndpoint(t *testing.T) {
 	assert.Equal(t, ip, socketAddress.Address)
 	assert.Equal(t, port, socketAddress.PortSpecifier.(*core.SocketAddress_PortValue).PortValue)
 }
+
+func TestNewClusterLoadAssignment(t *testing.T) {
+	endpoints := []*v3Endpoint.LbEndpoint{NewLBEndpoint("127.0.0.1", 8080)}
+
+	cla := NewClusterLoadAssignment("myTestCluster_12345", endpoints)
+
+	assert.Equal(t, "myTestCluster_12345", cla.ClusterName)
+	assert.DeepEqual(t, endpoints, cla.Endpoints[0].LbEndpoints, protocmp.Transform())
+}
@@ -24,18 +24,61 @@ import (
 	prx "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/proxy_protocol/v3"
 	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"k8s.io/apimachinery/pkg/types"
 )
 
 // SNIMatch represents an SNI match, including the hosts to match, the certificates and
 // keys to use and the source where we got the certs/keys from.
+//
+// The certificates and keys are not inlined into the listener. The filter chain
+// references them by the name of their source instead and they are served via SDS.
 type SNIMatch struct {
 	Hosts            []string
 	CertSource       types.NamespacedName
 	CertificateChain []byte
 	PrivateKey       []byte
+	// AdditionalCertificates are served for the same hosts, next to the certificate
+	// above. Envoy picks the first one the client supports, which allows to serve e.g.
+	// an ECDSA certificate to modern clients and an RSA certificate to others.
+	AdditionalCertificates []Certificate
+	// ClientValidation, if set, requires clients to present a certificate and
+	// specifies how it's verified.
+	ClientValidation *ClientValidation
+}
+
+// Certificate is a certificate chain along with its private key.
+type Certificate struct {
+	// CertSource is the secret holding the certificate.
+	CertSource       types.NamespacedName
+	CertificateChain []byte
+	PrivateKey       []byte
+}
+
+// SecretNames returns the names of the SDS secrets of all certificates of the match.
+func (m *SNIMatch) SecretNames() []string {
+	names := make([]string, 0, 1+len(m.AdditionalCertificates))
+	names = append(names, SecretName(m.CertSource))
+	for _, cert := range m.AdditionalCertificates {
+		names = append(names, SecretName(cert.CertSource))
+	}
+	return names
+}
+
+// ClientValidation specifies how client certificates are verified.
+//
+// Like the certificates, the CA bundle is served via SDS.
+type ClientValidation struct {
+	// CASource is the secret holding the CA bundle.
+	CASource types.NamespacedName
+	// CABundle holds the PEM encoded certificates of the CAs to trust.
+	CABundle []byte
+	// SubjectAltNames optionally restricts the client certificates accepted to the
+	// ones with any of these subject alternative names.
+	SubjectAltNames []string
 }
 
 // NewHTTPListener creates a new Listener at the given port, backed by the given manager.
@@ -83,18 +126,20 @@ func NewHTTPSListener(port uint32, filterChain []*listener.FilterChain, enablePr
 	}, nil
 }
 
-// CreateFilterChainFromCertificateAndPrivateKey creates a new filter chain from a certificate and a private key
-func CreateFilterChainFromCertificateAndPrivateKey(
+// CreateFilterChainFromSecret creates a new filter chain that references the certificate
+// and private key of the SDS secret with the given name. The TLS parameters are left
+// to the Envoy defaults if tlsParams is nil.
+func CreateFilterChainFromSecret(
 	manager *hcm.HttpConnectionManager,
-	certificateChain []byte,
-	privateKey []byte) (*listener.FilterChain, error) {
+	secretName string,
+	tlsParams *auth.TlsParameters) (*listener.FilterChain, error) {
 
 	filters, err := createFilters(manager)
 	if err != nil {
 		return nil, err
 	}
 
-	tlsContext := createTLSContext(certificateChain, privateKey)
+	tlsContext := createTLSContext([]string{secretName}, nil, tlsParams)
 	tlsAny, err := anypb.New(tlsContext)
 	if err != nil {
 		return nil, err
@@ -110,11 +155,12 @@ func CreateFilterChainFromCertificateAndPrivateKey(
 }
 
 // NewHTTPSListenerWithSNI creates a new Listener at the given port, backed by the given
-// manager and applies a FilterChain with the given sniMatches.
+// manager and applies a FilterChain with the given sniMatches. The TLS parameters are
+// left to the Envoy defaults if tlsParams is nil.
 //
 // Ref: https://www.envoyproxy.io/docs/envoy/latest/faq/configuration/sni.html
-func NewHTTPSListenerWithSNI(manager *hcm.HttpConnectionManager, port uint32, sniMatches []*SNIMatch, enableProxyProtocol bool) (*listener.Listener, error) {
-	filterChains, err := createFilterChainsForTLS(manager, sniMatches)
+func NewHTTPSListenerWithSNI(manager *hcm.HttpConnectionManager, port uint32, sniMatches []*SNIMatch, tlsParams *auth.TlsParameters, enableProxyProtocol bool) (*listener.Listener, error) {
+	filterChains, err := CreateFilterChainsWithSNI(manager, sniMatches, tlsParams)
 	if err != nil {
 		return nil, err
 	}
@@ -175,7 +221,10 @@ func createFilters(manager *hcm.HttpConnectionManager) ([]*listener.Filter, erro
 	}}, nil
 }
 
-func createFilterChainsForTLS(manager *hcm.HttpConnectionManager, sniMatches []*SNIMatch) ([]*listener.FilterChain, error) {
+// CreateFilterChainsWithSNI creates a filter chain for each of the given sniMatches,
+// backed by the given manager. The TLS parameters are left to the Envoy defaults if
+// tlsParams is nil.
+func CreateFilterChainsWithSNI(manager *hcm.HttpConnectionManager, sniMatches []*SNIMatch, tlsParams *auth.TlsParameters) ([]*listener.FilterChain, error) {
 	res := make([]*listener.FilterChain, 0, len(sniMatches))
 	for _, sniMatch := range sniMatches {
 		filters, err := createFilters(manager)
@@ -183,7 +232,7 @@ func createFilterChainsForTLS(manager *hcm.HttpConnectionManager, sniMatches []*
 			return nil, err
 		}
 
-		tlsContext := createTLSContext(sniMatch.CertificateChain, sniMatch.PrivateKey)
+		tlsContext := createTLSContext(sniMatch.SecretNames(), sniMatch.ClientValidation, tlsParams)
 		tlsAny, err := anypb.New(tlsContext)
 		if err != nil {
 			return nil, err
@@ -206,20 +255,41 @@ func createFilterChainsForTLS(manager *hcm.HttpConnectionManager, sniMatches []*
 	return res, nil
 }
 
-func createTLSContext(certificate []byte, privateKey []byte) *auth.DownstreamTlsContext {
-	return &auth.DownstreamTlsContext{
+func createTLSContext(secretNames []string, validation *ClientValidation, tlsParams *auth.TlsParameters) *auth.DownstreamTlsContext {
+	sdsSecretConfigs := make([]*auth.SdsSecretConfig, 0, len(secretNames))
+	for _, secretName := range secretNames {
+		sdsSecretConfigs = append(sdsSecretConfigs, newSdsSecretConfig(secretName))
+	}
+
+	tlsContext := &auth.DownstreamTlsContext{
 		CommonTlsContext: &auth.CommonTlsContext{
-			AlpnProtocols: []string{"h2", "http/1.1"},
-			TlsCertificates: []*auth.TlsCertificate{{
-				CertificateChain: &core.DataSource{
-					Specifier: &core.DataSource_InlineBytes{InlineBytes: certificate},
-				},
-				PrivateKey: &core.DataSource{
-					Specifier: &core.DataSource_InlineBytes{InlineBytes: privateKey},
-				},
-			}},
+			TlsParams:                      tlsParams,
+			AlpnProtocols:                  []string{"h2", "http/1.1"},
+			TlsCertificateSdsSecretConfigs: sdsSecretConfigs,
 		},
 	}
+
+	if validation != nil {
+		tlsContext.RequireClientCertificate = wrapperspb.Bool(true)
+
+		// The SAN matchers are combined with the CA bundle served via SDS.
+		sans := make([]*matcher.StringMatcher, 0, len(validation.SubjectAltNames))
+		for _, san := range validation.SubjectAltNames {
+			sans = append(sans, &matcher.StringMatcher{
+				MatchPattern: &matcher.StringMatcher_Exact{Exact: san},
+			})
+		}
+		tlsContext.CommonTlsContext.ValidationContextType = &auth.CommonTlsContext_CombinedValidationContext{
+			CombinedValidationContext: &auth.CommonTlsContext_CombinedCertificateValidationContext{
+				DefaultValidationContext: &auth.CertificateValidationContext{
+					MatchSubjectAltNames: sans,
+				},
+				ValidationContextSdsSecretConfig: newSdsSecretConfig(ValidationSecretName(validation.CASource)),
+			},
+		}
+	}
+
+	return tlsContext
 }
 
 // Ref: https://www.envoyproxy.io/docs/envoy/latest/configuration/listeners/listener_filters/proxy_protocol
@@ -30,12 +30,13 @@ import (
 	"google.golang.org/protobuf/types/known/anypb"
 	"gotest.tools/v3/assert"
 	is "gotest.tools/v3/assert/cmp"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 const urlPrefix = "type.googleapis.com/"
 
 func TestNewHTTPListener(t *testing.T) {
-	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/)
+	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
 
 	l, err := NewHTTPListener(manager, 8080, false)
 	assert.NilError(t, err)
@@ -50,7 +51,7 @@ func TestNewHTTPListener(t *testing.T) {
 }
 
 func TestNewHTTPListenerWithProxyProtocol(t *testing.T) {
-	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, true /*enableProxyProtocol*/)
+	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, true /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
 
 	l, err := NewHTTPListener(manager, 8080, true)
 	assert.NilError(t, err)
@@ -65,12 +66,11 @@ func TestNewHTTPListenerWithProxyProtocol(t *testing.T) {
 }
 
 func TestNewHTTPSListener(t *testing.T) {
-	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/)
+	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
 
-	certChain := []byte("some_certificate_chain")
-	privateKey := []byte("some_private_key")
+	secretName := "some_secret"
 
-	filterChain, err := CreateFilterChainFromCertificateAndPrivateKey(manager, certChain, privateKey)
+	filterChain, err := CreateFilterChainFromSecret(manager, secretName, nil /*tlsParams*/)
 	assert.NilError(t, err)
 
 	l, err := NewHTTPSListener(8081, []*envoy_api_v3.FilterChain{filterChain}, false)
@@ -81,23 +81,21 @@ func TestNewHTTPSListener(t *testing.T) {
 	assert.Equal(t, uint32(8081), l.Address.GetSocketAddress().GetPortValue())
 
 	// Check that TLS is configured
-	gotCertChain, gotPrivateKey, err := getTLSCreds(l.FilterChains[0])
+	gotSecretName, err := getTLSSecretName(l.FilterChains[0])
 	assert.NilError(t, err)
 
-	assert.DeepEqual(t, certChain, gotCertChain)
-	assert.DeepEqual(t, privateKey, gotPrivateKey)
+	assert.Equal(t, secretName, gotSecretName)
 
 	// check proxy protocol is not configured
 	assert.Check(t, len(l.ListenerFilters) == 0)
 }
 
 func TestNewHTTPSListenerWithProxyProtocol(t *testing.T) {
-	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, true /*enableProxyProtocol*/)
+	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, true /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
 
-	certChain := []byte("some_certificate_chain")
-	privateKey := []byte("some_private_key")
+	secretName := "some_secret"
 
-	filterChain, err := CreateFilterChainFromCertificateAndPrivateKey(manager, certChain, privateKey)
+	filterChain, err := CreateFilterChainFromSecret(manager, secretName, nil /*tlsParams*/)
 	assert.NilError(t, err)
 
 	l, err := NewHTTPSListener(8081, []*envoy_api_v3.FilterChain{filterChain}, true)
@@ -108,11 +106,10 @@ func TestNewHTTPSListenerWithProxyProtocol(t *testing.T) {
 	assert.Equal(t, uint32(8081), l.Address.GetSocketAddress().GetPortValue())
 
 	// Check that TLS is configured
-	gotCertChain, gotPrivateKey, err := getTLSCreds(l.FilterChains[0])
+	gotSecretName, err := getTLSSecretName(l.FilterChains[0])
 	assert.NilError(t, err)
 
-	assert.DeepEqual(t, certChain, gotCertChain)
-	assert.DeepEqual(t, privateKey, gotPrivateKey)
+	assert.Equal(t, secretName, gotSecretName)
 
 	// check proxy protocol is configured
 	assertListenerHasProxyProtocolConfigured(t, l.ListenerFilters[0])
@@ -121,16 +118,18 @@ func TestNewHTTPSListenerWithProxyProtocol(t *testing.T) {
 func TestNewHTTPSListenerWithSNI(t *testing.T) {
 	sniMatches := []*SNIMatch{{
 		Hosts:            []string{"some_host.com"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secret1"},
 		CertificateChain: []byte("cert1"),
 		PrivateKey:       []byte("key1"),
 	}, {
 		Hosts:            []string{"another_host.com"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secret2"},
 		CertificateChain: []byte("cert2"),
 		PrivateKey:       []byte("key2"),
 	}}
 
-	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/)
-	listener, err := NewHTTPSListenerWithSNI(manager, 8443, sniMatches, false)
+	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
+	listener, err := NewHTTPSListenerWithSNI(manager, 8443, sniMatches, nil /*tlsParams*/, false)
 	assert.NilError(t, err)
 
 	assert.Equal(t, core.SocketAddress_TCP, listener.Address.GetSocketAddress().Protocol)
@@ -149,16 +148,18 @@ func TestNewHTTPSListenerWithSNI(t *testing.T) {
 func TestNewHTTPSListenerWithSNIWithProxyProtocol(t *testing.T) {
 	sniMatches := []*SNIMatch{{
 		Hosts:            []string{"some_host.com"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secret1"},
 		CertificateChain: []byte("cert1"),
 		PrivateKey:       []byte("key1"),
 	}, {
 		Hosts:            []string{"another_host.com"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secret2"},
 		CertificateChain: []byte("cert2"),
 		PrivateKey:       []byte("key2"),
 	}}
 
-	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, true /*enableProxyProtocol*/)
-	listener, err := NewHTTPSListenerWithSNI(manager, 8443, sniMatches, true)
+	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, true /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
+	listener, err := NewHTTPSListenerWithSNI(manager, 8443, sniMatches, nil /*tlsParams*/, true)
 	assert.NilError(t, err)
 
 	assert.Equal(t, core.SocketAddress_TCP, listener.Address.GetSocketAddress().Protocol)
@@ -173,14 +174,103 @@ func TestNewHTTPSListenerWithSNIWithProxyProtocol(t *testing.T) {
 	assertListenerHasSNIMatchConfigured(t, listener, sniMatches[1])
 }
 
+func TestNewHTTPSListenerWithSNIWithClientValidation(t *testing.T) {
+	sniMatches := []*SNIMatch{{
+		Hosts:            []string{"some_host.com"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secret1"},
+		CertificateChain: []byte("cert1"),
+		PrivateKey:       []byte("key1"),
+		ClientValidation: &ClientValidation{
+			CASource:        types.NamespacedName{Namespace: "secretns", Name: "ca"},
+			CABundle:        []byte("ca"),
+			SubjectAltNames: []string{"client.example.com"},
+		},
+	}, {
+		Hosts:            []string{"another_host.com"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secret2"},
+		CertificateChain: []byte("cert2"),
+		PrivateKey:       []byte("key2"),
+	}}
+
+	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
+	listener, err := NewHTTPSListenerWithSNI(manager, 8443, sniMatches, nil /*tlsParams*/, false)
+	assert.NilError(t, err)
+
+	assertListenerHasSNIMatchConfigured(t, listener, sniMatches[0])
+	assertListenerHasSNIMatchConfigured(t, listener, sniMatches[1])
+
+	verified := getDownstreamTLSContext(t, getFilterChainByServerName(listener, sniMatches[0].Hosts))
+	assert.Assert(t, verified.RequireClientCertificate.GetValue())
+	combined := verified.CommonTlsContext.GetCombinedValidationContext()
+	assert.Equal(t, "secretns/ca/ca", combined.ValidationContextSdsSecretConfig.Name)
+	assert.Assert(t, combined.ValidationContextSdsSecretConfig.SdsConfig.GetAds() != nil)
+	assert.Equal(t, 1, len(combined.DefaultValidationContext.MatchSubjectAltNames))
+	assert.Equal(t, "client.example.com", combined.DefaultValidationContext.MatchSubjectAltNames[0].GetExact())
+
+	unverified := getDownstreamTLSContext(t, getFilterChainByServerName(listener, sniMatches[1].Hosts))
+	assert.Assert(t, !unverified.RequireClientCertificate.GetValue())
+	assert.Assert(t, unverified.CommonTlsContext.ValidationContextType == nil)
+}
+
+func TestNewHTTPSListenerWithSNIWithAdditionalCertificates(t *testing.T) {
+	sniMatches := []*SNIMatch{{
+		Hosts:            []string{"some_host.com"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "ecdsa"},
+		CertificateChain: []byte("cert1"),
+		PrivateKey:       []byte("key1"),
+		AdditionalCertificates: []Certificate{{
+			CertSource:       types.NamespacedName{Namespace: "secretns", Name: "rsa"},
+			CertificateChain: []byte("cert2"),
+			PrivateKey:       []byte("key2"),
+		}},
+	}}
+
+	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
+	listener, err := NewHTTPSListenerWithSNI(manager, 8443, sniMatches, nil /*tlsParams*/, false)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(listener.FilterChains))
+
+	// Both certificates are served by the same filter chain, in order.
+	tlsContext := getDownstreamTLSContext(t, listener.FilterChains[0])
+	sdsConfigs := tlsContext.CommonTlsContext.TlsCertificateSdsSecretConfigs
+	assert.Equal(t, 2, len(sdsConfigs))
+	assert.Equal(t, "secretns/ecdsa", sdsConfigs[0].Name)
+	assert.Equal(t, "secretns/rsa", sdsConfigs[1].Name)
+}
+
+func TestNewHTTPSListenerWithTLSParameters(t *testing.T) {
+	tlsParams := &auth.TlsParameters{
+		TlsMinimumProtocolVersion: auth.TlsParameters_TLSv1_2,
+		CipherSuites:              []string{"ECDHE-ECDSA-AES128-GCM-SHA256"},
+		EcdhCurves:                []string{"X25519"},
+	}
+	sniMatches := []*SNIMatch{{
+		Hosts:            []string{"some_host.com"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secret1"},
+		CertificateChain: []byte("cert1"),
+		PrivateKey:       []byte("key1"),
+	}}
+
+	manager := NewHTTPConnectionManager("test", true /*enableAccessLog*/, false /*enableProxyProtocol*/, nil /*extAuthzFilter*/)
+	listener, err := NewHTTPSListenerWithSNI(manager, 8443, sniMatches, tlsParams, false)
+	assert.NilError(t, err)
+
+	filterChain, err := CreateFilterChainFromSecret(manager, "some_secret", tlsParams)
+	assert.NilError(t, err)
+
+	for _, filterChain := range []*envoy_api_v3.FilterChain{listener.FilterChains[0], filterChain} {
+		tlsContext := getDownstreamTLSContext(t, filterChain)
+		assert.Assert(t, proto.Equal(tlsParams, tlsContext.CommonTlsContext.TlsParams))
+	}
+}
+
 func assertListenerHasSNIMatchConfigured(t *testing.T, listener *envoy_api_v3.Listener, match *SNIMatch) {
 	filterChainFirstSNIMatch := getFilterChainByServerName(listener, match.Hosts)
 	assert.Assert(t, filterChainFirstSNIMatch != nil)
 
-	certChain, privateKey, err := getTLSCreds(filterChainFirstSNIMatch)
+	secretName, err := getTLSSecretName(filterChainFirstSNIMatch)
 	assert.NilError(t, err)
-	assert.DeepEqual(t, match.CertificateChain, certChain)
-	assert.DeepEqual(t, match.PrivateKey, privateKey)
+	assert.Equal(t, SecretName(match.CertSource), secretName)
 }
 
 func assertListenerHasProxyProtocolConfigured(t *testing.T, listenerFilter *envoy_api_v3.ListenerFilter) {
@@ -201,21 +291,32 @@ func getFilterChainByServerName(listener *envoy_api_v3.Listener, serverNames []s
 	return nil
 }
 
+func getDownstreamTLSContext(t *testing.T, filterChain *envoy_api_v3.FilterChain) *auth.DownstreamTlsContext {
+	downstreamTLSContext := &auth.DownstreamTlsContext{}
+	err := anypb.UnmarshalTo(filterChain.GetTransportSocket().GetTypedConfig(), downstreamTLSContext, proto.UnmarshalOptions{})
+	assert.NilError(t, err)
+	return downstreamTLSContext
+}
+
 // Note: Returns an error when there are multiple certificates
-func getTLSCreds(filterChain *envoy_api_v3.FilterChain) (certChain []byte, privateKey []byte, err error) {
+func getTLSSecretName(filterChain *envoy_api_v3.FilterChain) (string, error) {
 	downstreamTLSContext := &auth.DownstreamTlsContext{}
-	err = anypb.UnmarshalTo(filterChain.GetTransportSocket().GetTypedConfig(), downstreamTLSContext, proto.UnmarshalOptions{})
+	err := anypb.UnmarshalTo(filterChain.GetTransportSocket().GetTypedConfig(), downstreamTLSContext, proto.UnmarshalOptions{})
 	if err != nil {
-		return nil, nil, err
+		return "", err
 	}
 
-	if len(downstreamTLSContext.CommonTlsContext.TlsCertificates) > 1 {
-		return nil, nil, fmt.Errorf("more than one certificate configured")
+	if len(downstreamTLSContext.CommonTlsContext.TlsCertificates) != 0 {
+		return "", fmt.Errorf("certificate inlined into the filter chain")
+	}
+	if len(downstreamTLSContext.CommonTlsContext.TlsCertificateSdsSecretConfigs) > 1 {
+		return "", fmt.Errorf("more than one certificate configured")
 	}
 
-	certs := downstreamTLSContext.CommonTlsContext.TlsCertificates[0]
-	certChain = certs.CertificateChain.GetInlineBytes()
-	privateKey = certs.PrivateKey.GetInlineBytes()
+	sdsConfig := downstreamTLSContext.CommonTlsContext.TlsCertificateSdsSecretConfigs[0]
+	if sdsConfig.SdsConfig.GetAds() == nil {
+		return "", fmt.Errorf("secret %q is not fetched via ADS", sdsConfig.Name)
+	}
 
-	return certChain, privateKey, nil
+	return sdsConfig.Name, nil
 }
@@ -17,21 +17,64 @@ limitations under the License.
 package envoy
 
 import (
+	"regexp"
+	"strings"
 	"time"
 
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+// PathMatchType specifies how a route matches the path of requests.
+type PathMatchType string
+
+const (
+	// PathMatchPlainPrefix matches the paths starting with the given one, e.g. /api
+	// matches /apiv2.
+	PathMatchPlainPrefix PathMatchType = ""
+	// PathMatchPrefix matches the given path and the paths below it, e.g. /api
+	// matches /api and /api/v2 but not /apiv2.
+	PathMatchPrefix PathMatchType = "prefix"
+	// PathMatchExact matches the given path only.
+	PathMatchExact PathMatchType = "exact"
+	// PathMatchRegex matches the paths entirely matching the given RE2 regular
+	// expression.
+	PathMatchRegex PathMatchType = "regex"
+)
+
+// PathMatch specifies the paths of the requests a route matches.
+type PathMatch struct {
+	Path string
+	Type PathMatchType
+}
+
+// RouteTimeouts holds the timeouts of a route.
+type RouteTimeouts struct {
+	// Request is the timeout of a request until its response is complete. Zero
+	// disables it.
+	Request time.Duration
+	// Idle is the time a request may be idle. The stream idle timeout of the
+	// connection manager applies if zero.
+	Idle time.Duration
+	// MaxStreamDuration is the maximum duration of a request, including streaming
+	// ones. Zero disables it.
+	MaxStreamDuration time.Duration
+	// GrpcTimeoutHeaderMax is the maximum timeout the grpc-timeout header is
+	// honored up to. The header is ignored if zero.
+	GrpcTimeoutHeaderMax time.Duration
+}
+
 // NewRoute creates a new Route.
 func NewRoute(name string,
 	headersMatch []*route.HeaderMatcher,
-	path string,
+	pathMatch PathMatch,
 	wrs []*route.WeightedCluster_ClusterWeight,
-	routeTimeout time.Duration,
+	timeouts RouteTimeouts,
 	headers map[string]string,
-	hostRewrite string) *route.Route {
+	hostRewrite string,
+	retryPolicy *route.RetryPolicy) *route.Route {
 
 	routeAction := &route.RouteAction{
 		ClusterSpecifier: &route.RouteAction_WeightedClusters{
@@ -39,11 +82,26 @@ func NewRoute(name string,
 				Clusters: wrs,
 			},
 		},
-		Timeout: durationpb.New(routeTimeout),
+		Timeout: durationpb.New(timeouts.Request),
 		UpgradeConfigs: []*route.RouteAction_UpgradeConfig{{
 			UpgradeType: "websocket",
 			Enabled:     wrapperspb.Bool(true),
 		}},
+		RetryPolicy: retryPolicy,
+	}
+
+	if timeouts.Idle > 0 {
+		routeAction.IdleTimeout = durationpb.New(timeouts.Idle)
+	}
+
+	if timeouts.MaxStreamDuration > 0 || timeouts.GrpcTimeoutHeaderMax > 0 {
+		routeAction.MaxStreamDuration = &route.RouteAction_MaxStreamDuration{}
+		if timeouts.MaxStreamDuration > 0 {
+			routeAction.MaxStreamDuration.MaxStreamDuration = durationpb.New(timeouts.MaxStreamDuration)
+		}
+		if timeouts.GrpcTimeoutHeaderMax > 0 {
+			routeAction.MaxStreamDuration.GrpcTimeoutHeaderMax = durationpb.New(timeouts.GrpcTimeoutHeaderMax)
+		}
 	}
 
 	if hostRewrite != "" {
@@ -53,13 +111,8 @@ func NewRoute(name string,
 	}
 
 	return &route.Route{
-		Name: name,
-		Match: &route.RouteMatch{
-			PathSpecifier: &route.RouteMatch_Prefix{
-				Prefix: path,
-			},
-			Headers: headersMatch,
-		},
+		Name:  name,
+		Match: newRouteMatch(headersMatch, pathMatch),
 		Action: &route.Route_Route{
 			Route: routeAction,
 		},
@@ -69,16 +122,11 @@ func NewRoute(name string,
 
 func NewRedirectRoute(name string,
 	headersMatch []*route.HeaderMatcher,
-	path string,
+	pathMatch PathMatch,
 ) *route.Route {
 	return &route.Route{
-		Name: name,
-		Match: &route.RouteMatch{
-			PathSpecifier: &route.RouteMatch_Prefix{
-				Prefix: path,
-			},
-			Headers: headersMatch,
-		},
+		Name:  name,
+		Match: newRouteMatch(headersMatch, pathMatch),
 		Action: &route.Route_Redirect{
 			Redirect: &route.RedirectAction{
 				SchemeRewriteSpecifier: &route.RedirectAction_HttpsRedirect{
@@ -88,3 +136,42 @@ func NewRedirectRoute(name string,
 		},
 	}
 }
+
+func newRouteMatch(headersMatch []*route.HeaderMatcher, pathMatch PathMatch) *route.RouteMatch {
+	routeMatch := &route.RouteMatch{
+		Headers: headersMatch,
+	}
+
+	switch pathMatch.Type {
+	case PathMatchExact:
+		routeMatch.PathSpecifier = &route.RouteMatch_Path{
+			Path: pathMatch.Path,
+		}
+	case PathMatchRegex:
+		routeMatch.PathSpecifier = &route.RouteMatch_SafeRegex{
+			SafeRegex: safeRegexMatcher(pathMatch.Path),
+		}
+	case PathMatchPrefix:
+		// Envoy's prefixes don't respect segment boundaries, so the path is matched
+		// by a regular expression instead, unless it matches all paths anyway.
+		if prefix := strings.TrimSuffix(pathMatch.Path, "/"); prefix != "" {
+			routeMatch.PathSpecifier = &route.RouteMatch_SafeRegex{
+				SafeRegex: safeRegexMatcher(regexp.QuoteMeta(prefix) + "(/.*)?"),
+			}
+			break
+		}
+		fallthrough
+	default:
+		routeMatch.PathSpecifier = &route.RouteMatch_Prefix{
+			Prefix: pathMatch.Path,
+		}
+	}
+	return routeMatch
+}
+
+func safeRegexMatcher(regex string) *matcher.RegexMatcher {
+	return &matcher.RegexMatcher{
+		EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+		Regex:      regex,
+	}
+}
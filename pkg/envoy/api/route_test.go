@@ -18,14 +18,16 @@ package envoy
 
 import (
 	"testing"
+	"time"
 
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"google.golang.org/protobuf/testing/protocmp"
 	"gotest.tools/v3/assert"
 )
 
 func TestNewRouteHeaderMatch(t *testing.T) {
 	name := "testRoute_12345"
-	path := "/my_route"
+	path := PathMatch{Path: "/my_route"}
 	headerMatch := []*route.HeaderMatcher{{
 		Name: "myHeader",
 		HeaderMatchSpecifier: &route.HeaderMatcher_ExactMatch{
@@ -33,7 +35,7 @@ func TestNewRouteHeaderMatch(t *testing.T) {
 		},
 	}}
 
-	r := NewRoute(name, headerMatch, path, nil, 0, nil, "")
+	r := NewRoute(name, headerMatch, path, nil, RouteTimeouts{}, nil, "", nil)
 	assert.Equal(t, r.Match.Headers[0].Name, "myHeader")
 	//nolint: staticcheck // TODO: GetExactMatch is deprecated.
 	assert.Equal(t, r.Match.Headers[0].GetExactMatch(), "strict")
@@ -41,8 +43,81 @@ func TestNewRouteHeaderMatch(t *testing.T) {
 
 func TestNewRouteHostRewrite(t *testing.T) {
 	name := "testRoute_12345"
-	path := "/my_route"
+	path := PathMatch{Path: "/my_route"}
 
-	r := NewRoute(name, nil, path, nil, 0, nil, "test.host")
+	r := NewRoute(name, nil, path, nil, RouteTimeouts{}, nil, "test.host", nil)
 	assert.Equal(t, r.Action.(*route.Route_Route).Route.GetHostRewriteLiteral(), "test.host")
 }
+
+func TestNewRouteRetryPolicy(t *testing.T) {
+	name := "testRoute_12345"
+	path := PathMatch{Path: "/my_route"}
+	retryPolicy := &route.RetryPolicy{RetryOn: "connect-failure"}
+
+	r := NewRoute(name, nil, path, nil, RouteTimeouts{}, nil, "", retryPolicy)
+	assert.Equal(t, r.Action.(*route.Route_Route).Route.GetRetryPolicy(), retryPolicy)
+
+	r = NewRoute(name, nil, path, nil, RouteTimeouts{}, nil, "", nil)
+	assert.Assert(t, r.Action.(*route.Route_Route).Route.GetRetryPolicy() == nil)
+}
+
+func TestNewRouteTimeouts(t *testing.T) {
+	name := "testRoute_12345"
+	path := PathMatch{Path: "/my_route"}
+
+	r := NewRoute(name, nil, path, nil, RouteTimeouts{}, nil, "", nil)
+	action := r.Action.(*route.Route_Route).Route
+	assert.Equal(t, action.GetTimeout().AsDuration(), time.Duration(0))
+	assert.Assert(t, action.GetIdleTimeout() == nil)
+	assert.Assert(t, action.GetMaxStreamDuration() == nil)
+
+	r = NewRoute(name, nil, path, nil, RouteTimeouts{
+		Request:              time.Minute,
+		Idle:                 30 * time.Second,
+		GrpcTimeoutHeaderMax: 5 * time.Minute,
+	}, nil, "", nil)
+	action = r.Action.(*route.Route_Route).Route
+	assert.Equal(t, action.GetTimeout().AsDuration(), time.Minute)
+	assert.Equal(t, action.GetIdleTimeout().AsDuration(), 30*time.Second)
+	assert.Assert(t, action.GetMaxStreamDuration().GetMaxStreamDuration() == nil)
+	assert.Equal(t, action.GetMaxStreamDuration().GetGrpcTimeoutHeaderMax().AsDuration(), 5*time.Minute)
+}
+
+func TestNewRoutePathMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		pathMatch PathMatch
+		want      *route.RouteMatch
+	}{{
+		name:      "plain prefix",
+		pathMatch: PathMatch{Path: "/api"},
+		want:      &route.RouteMatch{PathSpecifier: &route.RouteMatch_Prefix{Prefix: "/api"}},
+	}, {
+		name:      "exact",
+		pathMatch: PathMatch{Path: "/api", Type: PathMatchExact},
+		want:      &route.RouteMatch{PathSpecifier: &route.RouteMatch_Path{Path: "/api"}},
+	}, {
+		name:      "regex",
+		pathMatch: PathMatch{Path: "/v[0-9]+/.*", Type: PathMatchRegex},
+		want: &route.RouteMatch{PathSpecifier: &route.RouteMatch_SafeRegex{
+			SafeRegex: safeRegexMatcher("/v[0-9]+/.*"),
+		}},
+	}, {
+		name:      "prefix respecting segment boundaries",
+		pathMatch: PathMatch{Path: "/api.v1/", Type: PathMatchPrefix},
+		want: &route.RouteMatch{PathSpecifier: &route.RouteMatch_SafeRegex{
+			SafeRegex: safeRegexMatcher(`/api\.v1(/.*)?`),
+		}},
+	}, {
+		name:      "prefix matching all paths",
+		pathMatch: PathMatch{Path: "/", Type: PathMatchPrefix},
+		want:      &route.RouteMatch{PathSpecifier: &route.RouteMatch_Prefix{Prefix: "/"}},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRoute("testRoute_12345", nil, test.pathMatch, nil, RouteTimeouts{}, nil, "", nil)
+			assert.DeepEqual(t, r.Match, test.want, protocmp.Transform())
+		})
+	}
+}
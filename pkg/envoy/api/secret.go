@@ -0,0 +1,78 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package envoy
+
+import (
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// SecretName returns the name under which the certificate stored in the given
+// Kubernetes secret is served via SDS.
+func SecretName(ref types.NamespacedName) string {
+	return ref.String()
+}
+
+// ValidationSecretName returns the name under which the CA bundle stored in the
+// given Kubernetes secret is served via SDS. It's distinct from the name of any
+// certificate, even if stored in the same secret.
+func ValidationSecretName(ref types.NamespacedName) string {
+	return ref.String() + "/ca"
+}
+
+// NewSecret creates a new TLS certificate Secret with the given name, to be served
+// via SDS.
+func NewSecret(name string, certificateChain []byte, privateKey []byte) *auth.Secret {
+	return &auth.Secret{
+		Name: name,
+		Type: &auth.Secret_TlsCertificate{
+			TlsCertificate: &auth.TlsCertificate{
+				CertificateChain: &core.DataSource{
+					Specifier: &core.DataSource_InlineBytes{InlineBytes: certificateChain},
+				},
+				PrivateKey: &core.DataSource{
+					Specifier: &core.DataSource_InlineBytes{InlineBytes: privateKey},
+				},
+			},
+		},
+	}
+}
+
+// NewValidationSecret creates a new Secret with the given name holding the given PEM
+// encoded CA bundle to verify peer certificates against, to be served via SDS.
+func NewValidationSecret(name string, caBundle []byte) *auth.Secret {
+	return &auth.Secret{
+		Name: name,
+		Type: &auth.Secret_ValidationContext{
+			ValidationContext: &auth.CertificateValidationContext{
+				TrustedCa: &core.DataSource{
+					Specifier: &core.DataSource_InlineBytes{InlineBytes: caBundle},
+				},
+			},
+		},
+	}
+}
+
+// newSdsSecretConfig creates a reference to the secret with the given name, which is
+// fetched via ADS.
+func newSdsSecretConfig(name string) *auth.SdsSecretConfig {
+	return &auth.SdsSecretConfig{
+		Name:      name,
+		SdsConfig: adsConfigSource(),
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package envoy
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSecretName(t *testing.T) {
+	got := SecretName(types.NamespacedName{Namespace: "secretns", Name: "secretname"})
+	assert.Equal(t, "secretns/secretname", got)
+}
+
+func TestNewSecret(t *testing.T) {
+	certChain := []byte("some_certificate_chain")
+	privateKey := []byte("some_private_key")
+
+	secret := NewSecret("secretns/secretname", certChain, privateKey)
+
+	assert.Equal(t, "secretns/secretname", secret.Name)
+	assert.DeepEqual(t, certChain, secret.GetTlsCertificate().CertificateChain.GetInlineBytes())
+	assert.DeepEqual(t, privateKey, secret.GetTlsCertificate().PrivateKey.GetInlineBytes())
+}
+
+func TestValidationSecretName(t *testing.T) {
+	got := ValidationSecretName(types.NamespacedName{Namespace: "secretns", Name: "secretname"})
+	assert.Equal(t, "secretns/secretname/ca", got)
+}
+
+func TestNewValidationSecret(t *testing.T) {
+	caBundle := []byte("some_ca_bundle")
+
+	secret := NewValidationSecret("secretns/secretname/ca", caBundle)
+
+	assert.Equal(t, "secretns/secretname/ca", secret.Name)
+	assert.DeepEqual(t, caBundle, secret.GetValidationContext().TrustedCa.GetInlineBytes())
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+
+	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+)
+
+// maxTrackedSnapshots is the number of snapshots for which the versions of the
+// resources are remembered. Versions which haven't been part of any of the last
+// snapshots are forgotten and an ACK for them doesn't count.
+const maxTrackedSnapshots = 1000
+
+// StreamKey identifies an xDS stream. The state-of-the-world and the incremental
+// servers number their streams independently.
+type StreamKey struct {
+	ID    int64
+	Delta bool
+}
+
+// TypeStatus is the state of a resource type on a stream.
+type TypeStatus struct {
+	// Sent is the version of the last response sent.
+	Sent string
+	// Acked is the version of the last response ACKed.
+	Acked string
+	// Nacked is the version of the last response NACKed, if any.
+	Nacked string
+	// NackError is the error reported by the gateway with the last NACK.
+	NackError *rpcstatus.Status
+}
+
+// StreamStatus is the state of an xDS stream.
+type StreamStatus struct {
+	NodeID string
+	// Types holds the state per type URL, for all the types sent on the stream.
+	Types map[string]TypeStatus
+}
+
+type sentResponse struct {
+	nonce   string
+	version string
+}
+
+type stream struct {
+	nodeID string
+	// sent holds the last response sent per type URL.
+	sent   map[string]sentResponse
+	status map[string]TypeStatus
+}
+
+// SessionTracker records the versions sent to, ACKed and NACKed by every gateway
+// connected to the management server, per stream and resource type.
+//
+// Every snapshot pushed is given an increasing sequence number, which allows to
+// determine whether a gateway runs a configuration at least as recent as a given
+// snapshot.
+type SessionTracker struct {
+	mu  sync.Mutex
+	seq uint64
+	// versions maps the versions of every type URL to the last snapshot containing
+	// that version.
+	versions map[string]map[string]uint64
+	streams  map[StreamKey]*stream
+
+	// onAcked is called whenever a stream ACKed a new version, or a stream closed,
+	// both of which might cause more snapshots to be ACKed by all gateways.
+	onAcked func()
+}
+
+// NewSessionTracker creates a SessionTracker calling onAcked every time more
+// snapshots might have been ACKed by all gateways.
+func NewSessionTracker(onAcked func()) *SessionTracker {
+	return &SessionTracker{
+		versions: make(map[string]map[string]uint64),
+		streams:  make(map[StreamKey]*stream),
+		onAcked:  onAcked,
+	}
+}
+
+// SnapshotPushed records the versions of the given snapshot and returns its sequence
+// number. Must be called before the snapshot is set for the gateways.
+func (t *SessionTracker) SnapshotPushed(snapshot cache.Snapshot) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seq++
+	for i, resources := range snapshot.Resources {
+		typeURL, err := cache.GetResponseTypeURL(cachetypes.ResponseType(i))
+		if err != nil {
+			continue
+		}
+		versions := t.versions[typeURL]
+		if versions == nil {
+			versions = make(map[string]uint64)
+			t.versions[typeURL] = versions
+		}
+		versions[resources.Version] = t.seq
+
+		for version, seq := range versions {
+			if t.seq-seq >= maxTrackedSnapshots {
+				delete(versions, version)
+			}
+		}
+	}
+	return t.seq
+}
+
+// Acked returns true if every connected gateway ACKed the snapshot with the given
+// sequence number, or a later one.
+//
+// Notice that this is trivially true if no gateway is connected. With multiple
+// replicas of the controller, the gateways might all be connected to other replicas,
+// so waiting for a gateway to connect could block forever.
+func (t *SessionTracker) Acked(seq uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, s := range t.streams {
+		if t.ackedSeq(s) < seq {
+			return false
+		}
+	}
+	return true
+}
+
+// ackedSeq returns the sequence number of the latest snapshot the stream ACKed
+// for all types it received. Must be called while holding mu.
+func (t *SessionTracker) ackedSeq(s *stream) uint64 {
+	if len(s.sent) == 0 {
+		return 0
+	}
+
+	var acked uint64
+	first := true
+	for typeURL := range s.sent {
+		// A version unknown or never ACKed counts as 0.
+		seq := t.versions[typeURL][s.status[typeURL].Acked]
+		if first || seq < acked {
+			acked = seq
+			first = false
+		}
+	}
+	return acked
+}
+
+// NackedSnapshot returns the sequence number of the latest snapshot containing the
+// version of the given type last NACKed on the given stream.
+func (t *SessionTracker) NackedSnapshot(key StreamKey, typeURL string) (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.streams[key]
+	if s == nil || s.status[typeURL].Nacked == "" {
+		return 0, false
+	}
+	seq, ok := t.versions[typeURL][s.status[typeURL].Nacked]
+	return seq, ok
+}
+
+// Streams returns the state of all the connected streams.
+func (t *SessionTracker) Streams() map[StreamKey]StreamStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make(map[StreamKey]StreamStatus, len(t.streams))
+	for key, s := range t.streams {
+		types := make(map[string]TypeStatus, len(s.status))
+		for typeURL, status := range s.status {
+			types[typeURL] = status
+		}
+		res[key] = StreamStatus{
+			NodeID: s.nodeID,
+			Types:  types,
+		}
+	}
+	return res
+}
+
+// StreamOpened registers a new stream.
+func (t *SessionTracker) StreamOpened(key StreamKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.streams[key] = &stream{
+		sent:   make(map[string]sentResponse),
+		status: make(map[string]TypeStatus),
+	}
+}
+
+// StreamClosed forgets about a stream.
+func (t *SessionTracker) StreamClosed(key StreamKey) {
+	t.mu.Lock()
+	delete(t.streams, key)
+	t.mu.Unlock()
+
+	t.onAcked()
+}
+
+// ResponseSent records a response with the given nonce and version being sent on a
+// stream.
+func (t *SessionTracker) ResponseSent(key StreamKey, typeURL, nonce, version string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.streams[key]
+	if s == nil {
+		return
+	}
+	s.sent[typeURL] = sentResponse{nonce: nonce, version: version}
+
+	status := s.status[typeURL]
+	status.Sent = version
+	s.status[typeURL] = status
+}
+
+// RequestReceived records a request received on a stream. If the request refers to
+// the last response sent for its type, it's an ACK, or a NACK if errorDetail is set.
+func (t *SessionTracker) RequestReceived(key StreamKey, nodeID, typeURL, responseNonce string, errorDetail *rpcstatus.Status) {
+	acked := func() bool {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		s := t.streams[key]
+		if s == nil {
+			return false
+		}
+		// The node is only guaranteed to be set on the first request of a stream.
+		if nodeID != "" {
+			s.nodeID = nodeID
+		}
+
+		sent, ok := s.sent[typeURL]
+		if !ok || responseNonce == "" || sent.nonce != responseNonce {
+			// Initial or stale request.
+			return false
+		}
+
+		status := s.status[typeURL]
+		if errorDetail != nil {
+			status.Nacked = sent.version
+			status.NackError = errorDetail
+			s.status[typeURL] = status
+			return false
+		}
+		if status.Acked == sent.version {
+			return false
+		}
+		status.Acked = sent.version
+		s.status[typeURL] = status
+		return true
+	}()
+
+	if acked {
+		t.onAcked()
+	}
+}
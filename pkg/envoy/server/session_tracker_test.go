@@ -0,0 +1,133 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"gotest.tools/v3/assert"
+)
+
+func snapshotWithVersions(clusters, listeners string) cache.Snapshot {
+	var snapshot cache.Snapshot
+	snapshot.Resources[cachetypes.Cluster] = cache.Resources{Version: clusters}
+	snapshot.Resources[cachetypes.Listener] = cache.Resources{Version: listeners}
+	return snapshot
+}
+
+func TestSessionTrackerAcked(t *testing.T) {
+	var acks int
+	tracker := NewSessionTracker(func() { acks++ })
+	stream := StreamKey{ID: 1}
+
+	first := tracker.SnapshotPushed(snapshotWithVersions("c1", "l1"))
+
+	// Without gateways, there's nothing to wait for.
+	assert.Assert(t, tracker.Acked(first))
+
+	tracker.StreamOpened(stream)
+	assert.Assert(t, !tracker.Acked(first))
+
+	tracker.RequestReceived(stream, "node", resource.ClusterType, "", nil)
+	tracker.ResponseSent(stream, resource.ClusterType, "1", "c1")
+	tracker.RequestReceived(stream, "", resource.ListenerType, "", nil)
+	tracker.ResponseSent(stream, resource.ListenerType, "2", "l1")
+	assert.Assert(t, !tracker.Acked(first))
+
+	tracker.RequestReceived(stream, "", resource.ClusterType, "1", nil)
+	assert.Assert(t, !tracker.Acked(first), "listeners not ACKed yet")
+	tracker.RequestReceived(stream, "", resource.ListenerType, "2", nil)
+	assert.Assert(t, tracker.Acked(first))
+	assert.Equal(t, acks, 2)
+
+	// The clusters didn't change, so ACKing the listeners is enough.
+	second := tracker.SnapshotPushed(snapshotWithVersions("c1", "l2"))
+	assert.Assert(t, !tracker.Acked(second))
+	tracker.ResponseSent(stream, resource.ListenerType, "3", "l2")
+	tracker.RequestReceived(stream, "", resource.ListenerType, "3", nil)
+	assert.Assert(t, tracker.Acked(second))
+
+	assert.DeepEqual(t, tracker.Streams(), map[StreamKey]StreamStatus{
+		stream: {
+			NodeID: "node",
+			Types: map[string]TypeStatus{
+				resource.ClusterType:  {Sent: "c1", Acked: "c1"},
+				resource.ListenerType: {Sent: "l2", Acked: "l2"},
+			},
+		},
+	})
+}
+
+func TestSessionTrackerNacked(t *testing.T) {
+	tracker := NewSessionTracker(func() {})
+	stream := StreamKey{ID: 1, Delta: true}
+	tracker.StreamOpened(stream)
+
+	first := tracker.SnapshotPushed(snapshotWithVersions("c1", "l1"))
+	tracker.ResponseSent(stream, resource.ClusterType, "1", "c1")
+	tracker.RequestReceived(stream, "node", resource.ClusterType, "1", nil)
+	assert.Assert(t, tracker.Acked(first))
+
+	second := tracker.SnapshotPushed(snapshotWithVersions("c2", "l1"))
+	tracker.ResponseSent(stream, resource.ClusterType, "2", "c2")
+	nack := &rpcstatus.Status{Message: "invalid cluster"}
+	tracker.RequestReceived(stream, "", resource.ClusterType, "2", nack)
+	assert.Assert(t, !tracker.Acked(second))
+
+	seq, ok := tracker.NackedSnapshot(stream, resource.ClusterType)
+	assert.Assert(t, ok)
+	assert.Equal(t, seq, second)
+	_, ok = tracker.NackedSnapshot(stream, resource.ListenerType)
+	assert.Assert(t, !ok)
+
+	status := tracker.Streams()[stream].Types[resource.ClusterType]
+	assert.Equal(t, status.Acked, "c1")
+	assert.Equal(t, status.Nacked, "c2")
+	assert.Equal(t, status.NackError, nack)
+
+	// Stale nonces are ignored.
+	tracker.RequestReceived(stream, "", resource.ClusterType, "1", nil)
+	assert.Assert(t, !tracker.Acked(second))
+}
+
+func TestSessionTrackerMultipleStreams(t *testing.T) {
+	var acks int
+	tracker := NewSessionTracker(func() { acks++ })
+
+	// The state-of-the-world and delta streams are numbered independently.
+	sotw := StreamKey{ID: 1}
+	delta := StreamKey{ID: 1, Delta: true}
+	tracker.StreamOpened(sotw)
+	tracker.StreamOpened(delta)
+
+	seq := tracker.SnapshotPushed(snapshotWithVersions("c1", "l1"))
+	for _, stream := range []StreamKey{sotw, delta} {
+		tracker.ResponseSent(stream, resource.ClusterType, "1", "c1")
+	}
+
+	tracker.RequestReceived(sotw, "node", resource.ClusterType, "1", nil)
+	assert.Assert(t, !tracker.Acked(seq), "delta stream didn't ACK yet")
+
+	// Closing the lagging stream unblocks the snapshot.
+	tracker.StreamClosed(delta)
+	assert.Assert(t, tracker.Acked(seq))
+	assert.Equal(t, acks, 2)
+}
@@ -23,8 +23,10 @@ import (
 
 	cluster "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
 	listener "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	secret "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	xds "github.com/envoyproxy/go-control-plane/pkg/server/v3"
 	"google.golang.org/grpc"
@@ -65,6 +67,9 @@ func (healthServer) Check(context.Context, *health.HealthCheckRequest) (*health.
 }
 
 // RunManagementServer starts an xDS server at the given Port.
+//
+// Both the state-of-the-world and the incremental (delta) variants of the discovery
+// services are served, so gateways can use either of them.
 func (envoyXdsServer *XdsServer) RunManagementServer() error {
 	port := envoyXdsServer.managementPort
 	server := envoyXdsServer.server
@@ -79,8 +84,10 @@ func (envoyXdsServer *XdsServer) RunManagementServer() error {
 	discovery.RegisterAggregatedDiscoveryServiceServer(grpcServer, server)
 	health.RegisterHealthServer(grpcServer, healthServer{})
 	cluster.RegisterClusterDiscoveryServiceServer(grpcServer, server)
+	endpoint.RegisterEndpointDiscoveryServiceServer(grpcServer, server)
 	listener.RegisterListenerDiscoveryServiceServer(grpcServer, server)
 	route.RegisterRouteDiscoveryServiceServer(grpcServer, server)
+	secret.RegisterSecretDiscoveryServiceServer(grpcServer, server)
 
 	errCh := make(chan error)
 	go func() {
@@ -101,3 +108,20 @@ func (envoyXdsServer *XdsServer) RunManagementServer() error {
 func (envoyXdsServer *XdsServer) SetSnapshot(nodeID string, snapshot cache.Snapshot) error {
 	return envoyXdsServer.snapshotCache.SetSnapshot(context.Background(), nodeID, snapshot)
 }
+
+// HasSnapshot returns true if the snapshot currently set for the given node has the
+// same versions as the given snapshot, for every resource type.
+func (envoyXdsServer *XdsServer) HasSnapshot(nodeID string, snapshot cache.Snapshot) bool {
+	current, err := envoyXdsServer.snapshotCache.GetSnapshot(nodeID)
+	if err != nil {
+		// No snapshot set yet.
+		return false
+	}
+
+	for i := range current.Resources {
+		if current.Resources[i].Version != snapshot.Resources[i].Version {
+			return false
+		}
+	}
+	return true
+}
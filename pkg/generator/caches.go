@@ -17,61 +17,78 @@ limitations under the License.
 package generator
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"os"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	httpconnmanagerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
-	"github.com/google/uuid"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
-	kubeclient "k8s.io/client-go/kubernetes"
 	"knative.dev/net-kourier/pkg/config"
 	envoy "knative.dev/net-kourier/pkg/envoy/api"
 	rconfig "knative.dev/net-kourier/pkg/reconciler/ingress/config"
+	networking "knative.dev/networking/pkg"
+	"knative.dev/pkg/logging"
 )
 
 const (
-	envCertsSecretNamespace    = "CERTS_SECRET_NAMESPACE"
-	envCertsSecretName         = "CERTS_SECRET_NAME"
 	certFieldInSecret          = "tls.crt"
 	keyFieldInSecret           = "tls.key"
 	externalRouteConfigName    = "external_services"
 	externalTLSRouteConfigName = "external_tls_services"
 	internalRouteConfigName    = "internal_services"
+	probeRouteConfigName       = "probe_services"
 )
 
 // ErrDomainConflict is an error produces when two ingresses have conflicting domains.
 var ErrDomainConflict = errors.New("ingress has a conflicting domain with another ingress")
 
+// Snapshot is an Envoy snapshot along with the ingresses it was generated from.
+type Snapshot struct {
+	cache.Snapshot
+
+	// Ingresses maps the ingresses contained in the snapshot to the generation that
+	// was translated.
+	Ingresses map[types.NamespacedName]int64
+	// Certificates describes the certificates of all ingresses, including the ones
+	// left out, and the default certificate, if any.
+	Certificates []CertificateInfo
+}
+
 type Caches struct {
 	mu                  sync.Mutex
 	translatedIngresses map[types.NamespacedName]*translatedIngress
 	clusters            *ClustersCache
 	domainsInUse        sets.String
 	statusVirtualHost   *route.VirtualHost
-
-	kubeClient kubeclient.Interface
+	// excluded maps the ingresses left out of the snapshots to the generation that is
+	// excluded. Other generations of these ingresses are included.
+	excluded map[types.NamespacedName]int64
+	// defaultCertSecret is the secret holding the default certificate as last seen by
+	// the secret informer, if any.
+	defaultCertSecret *corev1.Secret
 }
 
-func NewCaches(ctx context.Context, kubernetesClient kubeclient.Interface, extAuthz bool) (*Caches, error) {
+func NewCaches(ctx context.Context) (*Caches, error) {
 	c := &Caches{
 		translatedIngresses: make(map[types.NamespacedName]*translatedIngress),
 		clusters:            newClustersCache(),
 		domainsInUse:        sets.NewString(),
 		statusVirtualHost:   statusVHost(),
-		kubeClient:          kubernetesClient,
-	}
-
-	if extAuthz {
-		c.clusters.set(config.ExternalAuthz.Cluster, "__extAuthZCluster", "_internal")
 	}
 	return c, nil
 }
@@ -108,12 +125,212 @@ func (caches *Caches) addTranslatedIngress(translatedIngress *translatedIngress)
 	caches.translatedIngresses[translatedIngress.name] = translatedIngress
 
 	for _, cluster := range translatedIngress.clusters {
-		caches.clusters.set(cluster, translatedIngress.name.Name, translatedIngress.name.Namespace)
+		caches.clusters.set(cluster, translatedIngress.loadAssignments[cluster.Name], translatedIngress.upstreamSecrets[cluster.Name], translatedIngress.name.Name, translatedIngress.name.Namespace)
+	}
+
+	return nil
+}
+
+// UpdateEndpoints rebuilds the load assignments served via EDS for the clusters
+// backed by the given endpoints. Returns true if any of them changed, in which case
+// a new snapshot has to be pushed. The ingresses don't have to be translated again.
+func (caches *Caches) UpdateEndpoints(endpoints *corev1.Endpoints) bool {
+	caches.mu.Lock()
+	defer caches.mu.Unlock()
+
+	// Clusters are named after the service backing them, see translateIngress.
+	clusterName := fmt.Sprintf("%s/%s", endpoints.Namespace, endpoints.Name)
+	return caches.clusters.updateLoadAssignments(clusterName, endpoints)
+}
+
+// SetDefaultCertSecret sets the secret holding the default certificate, nil if it
+// doesn't exist. Returns true if its certificate changed, in which case a new
+// snapshot has to be pushed.
+func (caches *Caches) SetDefaultCertSecret(secret *corev1.Secret) bool {
+	caches.mu.Lock()
+	defer caches.mu.Unlock()
+
+	before := caches.defaultCertSecret
+	caches.defaultCertSecret = secret
+
+	if before == nil || secret == nil {
+		return before != secret
+	}
+	return before.Namespace != secret.Namespace || before.Name != secret.Name ||
+		!bytes.Equal(before.Data[certFieldInSecret], secret.Data[certFieldInSecret]) ||
+		!bytes.Equal(before.Data[keyFieldInSecret], secret.Data[keyFieldInSecret])
+}
+
+// defaultCert returns the SDS secret for the default certificate configured, nil if
+// there's none or its secret hasn't been seen. Must be called while holding mu.
+func (caches *Caches) defaultCert(ctx context.Context, cfg *config.Kourier) *auth.Secret {
+	if !cfg.HasCertsSecret() {
+		return nil
+	}
+
+	secretRef := defaultCertSecretRef(cfg)
+	secret := caches.defaultCertSecret
+	if secret == nil || !IsDefaultCertSecret(secret, cfg) {
+		// Leave the default certificate out rather than failing the whole snapshot. It's
+		// added as soon as the secret informer sees the secret.
+		logging.FromContext(ctx).Warnf("Default certificate secret %s not found, not serving it", secretRef)
+		return nil
+	}
+	return envoy.NewSecret(envoy.SecretName(secretRef), secret.Data[certFieldInSecret], secret.Data[keyFieldInSecret])
+}
+
+// ValidationError returns the error of validating the resources generated for the
+// given ingress, if any. Invalid ingresses are left out of the snapshots.
+func (caches *Caches) ValidationError(key types.NamespacedName) error {
+	caches.mu.Lock()
+	defer caches.mu.Unlock()
+
+	if translated := caches.translatedIngresses[key]; translated != nil {
+		return translated.validate()
 	}
+	return nil
+}
+
+// TLSCertificateError returns the error of validating the certificates of the TLS
+// section of the given ingress, if any. Unlike invalid ingresses, the ingress is
+// still part of the snapshots, only the hosts without a valid certificate are left
+// out of the HTTPS listeners.
+func (caches *Caches) TLSCertificateError(key types.NamespacedName) error {
+	caches.mu.Lock()
+	defer caches.mu.Unlock()
+
+	if translated := caches.translatedIngresses[key]; translated != nil {
+		return translated.tlsCertificateErr
+	}
+	return nil
+}
 
+// Certificates describes the certificates of the TLS secrets referenced by the given
+// ingress.
+func (caches *Caches) Certificates(key types.NamespacedName) []CertificateInfo {
+	caches.mu.Lock()
+	defer caches.mu.Unlock()
+
+	if translated := caches.translatedIngresses[key]; translated != nil {
+		return translated.certificates
+	}
 	return nil
 }
 
+// SetExcludedIngresses sets the ingresses to leave out of the snapshots, mapped to
+// the generation to leave out. Replaces any previously excluded ingresses.
+func (caches *Caches) SetExcludedIngresses(excluded map[types.NamespacedName]int64) {
+	caches.mu.Lock()
+	defer caches.mu.Unlock()
+
+	caches.excluded = excluded
+}
+
+// isExcluded returns true if the given ingress is left out of the snapshots. Must be
+// called while holding mu.
+func (caches *Caches) isExcluded(key types.NamespacedName) bool {
+	generation, ok := caches.excluded[key]
+	if !ok {
+		return false
+	}
+	translated := caches.translatedIngresses[key]
+	return translated == nil || translated.generation == generation
+}
+
+// AttributeError returns the ingresses among the given ones the resources of which
+// are referenced by the given error message, i.e. their virtual hosts, domains,
+// clusters or secrets.
+func (caches *Caches) AttributeError(message string, candidates []types.NamespacedName) []types.NamespacedName {
+	caches.mu.Lock()
+	defer caches.mu.Unlock()
+
+	var res []types.NamespacedName
+	for _, key := range candidates {
+		translated := caches.translatedIngresses[key]
+		if translated == nil {
+			continue
+		}
+		if referencesIngress(message, translated) {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+// referencesIngress returns true if the given error message references any of the
+// resources of the given ingress. The names are only matched as a whole, so e.g.
+// foo.example.com doesn't match barfoo.example.com and ns/svc doesn't match ns/svc2.
+func referencesIngress(message string, translated *translatedIngress) bool {
+	// The names of the virtual hosts and routes are prefixed by the ingress name, see
+	// translateIngress.
+	if strings.Contains(message, fmt.Sprintf("(%s)", translated.name)) {
+		return true
+	}
+	for _, vhost := range translated.internalVirtualHosts {
+		for _, domain := range vhost.Domains {
+			if containsName(message, domain) {
+				return true
+			}
+		}
+	}
+	for _, cluster := range translated.clusters {
+		if containsName(message, cluster.Name) {
+			return true
+		}
+	}
+	for _, match := range translated.allSNIMatches() {
+		for _, name := range match.SecretNames() {
+			if containsName(message, name) {
+				return true
+			}
+		}
+		if match.ClientValidation != nil && containsName(message, envoy.ValidationSecretName(match.ClientValidation.CASource)) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsName returns true if the given message contains the given name, delimited
+// by characters that can't be part of the name, e.g. quotes or spaces. A period or
+// colon right after the name only continues it if followed by a name character, so
+// names ending a sentence are still found.
+func containsName(message, name string) bool {
+	if name == "" {
+		return false
+	}
+	for offset := 0; ; {
+		i := strings.Index(message[offset:], name)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(name)
+		if (start == 0 || !isNameChar(message[start-1])) && !continuesName(message[end:]) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+// continuesName returns true if the given rest of a message following a name makes
+// it part of a longer name.
+func continuesName(rest string) bool {
+	if rest == "" || !isNameChar(rest[0]) {
+		return false
+	}
+	if rest[0] == '.' || rest[0] == ':' {
+		return len(rest) > 1 && isNameChar(rest[1])
+	}
+	return true
+}
+
+// isNameChar returns true for the characters of domains, cluster names and secret
+// names.
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("-_./:*", c) >= 0
+}
+
 // SetOnEvicted allows to set a function that will be executed when any key on the cache expires.
 func (caches *Caches) SetOnEvicted(f func(types.NamespacedName, interface{})) {
 	caches.clusters.clusters.OnEvicted(func(key string, val interface{}) {
@@ -125,7 +342,7 @@ func (caches *Caches) SetOnEvicted(f func(types.NamespacedName, interface{})) {
 	})
 }
 
-func (caches *Caches) ToEnvoySnapshot(ctx context.Context) (cache.Snapshot, error) {
+func (caches *Caches) ToEnvoySnapshot(ctx context.Context) (Snapshot, error) {
 	caches.mu.Lock()
 	defer caches.mu.Unlock()
 
@@ -133,8 +350,35 @@ func (caches *Caches) ToEnvoySnapshot(ctx context.Context) (cache.Snapshot, erro
 	externalVHosts := make([]*route.VirtualHost, 0, len(caches.translatedIngresses))
 	externalTLSVHosts := make([]*route.VirtualHost, 0, len(caches.translatedIngresses))
 	snis := sniMatches{}
+	localSNIs := sniMatches{}
+	ingresses := make(map[types.NamespacedName]int64, len(caches.translatedIngresses))
+	// The ingresses left out, because their resources failed validation. The
+	// reconciler reports the error through ValidationError.
+	invalid := make(map[types.NamespacedName]struct{})
+	var certificates []CertificateInfo
+
+	// Iterate the ingresses in a stable order, so the virtual hosts, and hence the
+	// resource versions, don't change if the ingresses didn't.
+	keys := make([]types.NamespacedName, 0, len(caches.translatedIngresses))
+	for key := range caches.translatedIngresses {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	for _, key := range keys {
+		translatedIngress := caches.translatedIngresses[key]
+		certificates = append(certificates, translatedIngress.certificates...)
 
-	for _, translatedIngress := range caches.translatedIngresses {
+		if caches.isExcluded(key) {
+			continue
+		}
+		if err := translatedIngress.validate(); err != nil {
+			invalid[key] = struct{}{}
+			continue
+		}
+		ingresses[key] = translatedIngress.generation
 		localVHosts = append(localVHosts, translatedIngress.internalVirtualHosts...)
 		externalVHosts = append(externalVHosts, translatedIngress.externalVirtualHosts...)
 		externalTLSVHosts = append(externalTLSVHosts, translatedIngress.externalTLSVirtualHosts...)
@@ -142,30 +386,115 @@ func (caches *Caches) ToEnvoySnapshot(ctx context.Context) (cache.Snapshot, erro
 		for _, match := range translatedIngress.sniMatches {
 			snis.consume(match)
 		}
+		for _, match := range translatedIngress.localSNIMatches {
+			localSNIs.consume(match)
+		}
 	}
 	// Append the statusHost too.
 	localVHosts = append(localVHosts, caches.statusVirtualHost)
 
-	listeners, routes, err := generateListenersAndRouteConfigs(
+	cfg := rconfig.FromContextOrDefaults(ctx)
+	listeners, routes, secrets, err := generateListenersAndRouteConfigs(
 		ctx,
 		externalVHosts,
 		externalTLSVHosts,
 		localVHosts,
 		snis.list(),
-		caches.kubeClient,
+		localSNIs.list(),
+		caches.defaultCert(ctx, cfg.Kourier),
 	)
 	if err != nil {
-		return cache.Snapshot{}, err
+		return Snapshot{}, err
+	}
+	if info, ok := defaultCertificateInfo(secrets, cfg.Kourier); ok {
+		certificates = append(certificates, info)
 	}
 
-	return cache.NewSnapshot(
-		uuid.NewString(),
+	// The clusters of the ingresses left out are left out too.
+	excluded := func(key types.NamespacedName) bool {
+		_, isInvalid := invalid[key]
+		return isInvalid || caches.isExcluded(key)
+	}
+
+	clusters := caches.clusters.list(excluded)
+	if cfg.Kourier.ExternalAuthz.Enabled() {
+		clusters = append(clusters, cfg.Kourier.ExternalAuthz.Cluster())
+	}
+
+	// The secrets of the clusters are served along with the ones of the listeners.
+	// A secret referenced by both holds the same Kubernetes secret, the one of the
+	// listeners is kept.
+	secretNames := sets.NewString()
+	for _, secret := range secrets {
+		secretNames.Insert(cache.GetResourceName(secret))
+	}
+	for _, secret := range caches.clusters.listSecrets(excluded) {
+		if !secretNames.Has(cache.GetResourceName(secret)) {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	snapshot, err := cache.NewSnapshot(
+		"",
 		map[resource.Type][]cachetypes.Resource{
-			resource.ClusterType:  caches.clusters.list(),
+			resource.ClusterType:  clusters,
+			resource.EndpointType: caches.clusters.listLoadAssignments(excluded),
 			resource.RouteType:    routes,
 			resource.ListenerType: listeners,
+			resource.SecretType:   secrets,
 		},
 	)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	// The resources of the ingresses are valid at this point, so anything failing
+	// here would be rejected by all gateways, whatever ingresses are left out.
+	if err := validateSnapshot(&snapshot); err != nil {
+		return Snapshot{}, err
+	}
+
+	// Compute the per-resource versions upfront. The delta xDS server uses them to send
+	// only the resources that changed and would otherwise compute them again for every
+	// gateway requesting them.
+	if err := snapshot.ConstructVersionMap(); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to compute resource versions: %w", err)
+	}
+
+	// The version of each type is derived from the versions of its resources, so a
+	// snapshot with the same contents always has the same versions.
+	for _, typ := range []resource.Type{
+		resource.ClusterType,
+		resource.EndpointType,
+		resource.RouteType,
+		resource.ListenerType,
+		resource.SecretType,
+	} {
+		snapshot.Resources[cache.GetResponseType(typ)].Version = typeVersion(snapshot.GetVersionMap(typ))
+	}
+
+	return Snapshot{
+		Snapshot:     snapshot,
+		Ingresses:    ingresses,
+		Certificates: certificates,
+	}, nil
+}
+
+// typeVersion hashes the given versions of all resources of a type into a single
+// version.
+func typeVersion(resourceVersions map[string]string) string {
+	names := make([]string, 0, len(resourceVersions))
+	for name := range resourceVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hasher := sha256.New()
+	for _, name := range names {
+		// Names and versions can't contain a newline, so this is unambiguous.
+		fmt.Fprintf(hasher, "%s\n%s\n", name, resourceVersions[name])
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
 }
 
 // DeleteIngressInfo removes an ingress from the caches.
@@ -206,7 +535,8 @@ func generateListenersAndRouteConfigs(
 	externalTLSVirtualHosts []*route.VirtualHost,
 	clusterLocalVirtualHosts []*route.VirtualHost,
 	sniMatches []*envoy.SNIMatch,
-	kubeclient kubeclient.Interface) ([]cachetypes.Resource, []cachetypes.Resource, error) {
+	localSNIMatches []*envoy.SNIMatch,
+	defaultCert *auth.Secret) ([]cachetypes.Resource, []cachetypes.Resource, []cachetypes.Resource, error) {
 
 	// This has to be "OrDefaults" because this path is called before the informers are
 	// running when booting the controller up and prefilling the config before making it
@@ -215,60 +545,159 @@ func generateListenersAndRouteConfigs(
 
 	// First, we save the RouteConfigs with the proper name and all the virtualhosts etc. into the cache.
 	externalRouteConfig := envoy.NewRouteConfig(externalRouteConfigName, externalVirtualHosts)
-	externalTLSRouteConfig := envoy.NewRouteConfig(externalTLSRouteConfigName, externalTLSVirtualHosts)
+	// Plain HTTP requests to external hosts are not served at all if the http-protocol
+	// of config-network disables them. The probe listeners keep routing all external
+	// hosts, so the ingresses still become ready.
+	var probeRouteConfig *route.RouteConfiguration
+	if cfg.NetworkOrDefaults().HTTPProtocol == networking.HTTPDisabled {
+		probeRouteConfig = envoy.NewRouteConfig(probeRouteConfigName, externalVirtualHosts)
+		externalRouteConfig = envoy.NewRouteConfig(externalRouteConfigName, nil)
+	}
+	// The hosts verifying clients get route configs of their own, see
+	// clientValidationRouteConfigs.
+	sharedTLSVirtualHosts, clientValidationRoutes := clientValidationRouteConfigs(externalTLSVirtualHosts, sniMatches)
+	externalTLSRouteConfig := envoy.NewRouteConfig(externalTLSRouteConfigName, sharedTLSVirtualHosts)
 	internalRouteConfig := envoy.NewRouteConfig(internalRouteConfigName, clusterLocalVirtualHosts)
 
+	var extAuthzFilter *httpconnmanagerv3.HttpFilter
+	if cfg.Kourier.ExternalAuthz.Enabled() {
+		extAuthzFilter = cfg.Kourier.ExternalAuthz.HTTPFilter()
+	}
+
 	// Now we setup connection managers, that reference the routeconfigs via RDS.
-	externalManager := envoy.NewHTTPConnectionManager(externalRouteConfig.Name, cfg.Kourier.EnableServiceAccessLogging, cfg.Kourier.EnableProxyProtocol)
-	externalTLSManager := envoy.NewHTTPConnectionManager(externalTLSRouteConfig.Name, cfg.Kourier.EnableServiceAccessLogging, cfg.Kourier.EnableProxyProtocol)
-	internalManager := envoy.NewHTTPConnectionManager(internalRouteConfig.Name, cfg.Kourier.EnableServiceAccessLogging, cfg.Kourier.EnableProxyProtocol)
+	externalManager := envoy.NewHTTPConnectionManager(externalRouteConfig.Name, cfg.Kourier.EnableServiceAccessLogging, cfg.Kourier.EnableProxyProtocol, extAuthzFilter)
+	// The external URLs use https although Knative doesn't provision certificates if TLS
+	// is terminated in front of the gateways, so the services see that scheme.
+	if networkCfg := cfg.NetworkOrDefaults(); !networkCfg.AutoTLS && networkCfg.DefaultExternalScheme == "https" {
+		envoy.OverwriteScheme(externalManager, networkCfg.DefaultExternalScheme)
+	}
+	externalTLSManager := envoy.NewHTTPConnectionManager(externalTLSRouteConfig.Name, cfg.Kourier.EnableServiceAccessLogging, cfg.Kourier.EnableProxyProtocol, extAuthzFilter)
+	// Pass the details of verified client certificates on to the services.
+	envoy.ForwardClientCertDetails(externalTLSManager)
+	internalManager := envoy.NewHTTPConnectionManager(internalRouteConfig.Name, cfg.Kourier.EnableServiceAccessLogging, cfg.Kourier.EnableProxyProtocol, extAuthzFilter)
+	probeManager := externalManager
+	if probeRouteConfig != nil {
+		probeManager = proto.Clone(externalManager).(*httpconnmanagerv3.HttpConnectionManager)
+		probeManager.GetRds().RouteConfigName = probeRouteConfig.Name
+	}
 	externalHTTPEnvoyListener, err := envoy.NewHTTPListener(externalManager, config.HTTPPortExternal, cfg.Kourier.EnableProxyProtocol)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	internalEnvoyListener, err := envoy.NewHTTPListener(internalManager, config.HTTPPortInternal, false)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	listeners := []cachetypes.Resource{externalHTTPEnvoyListener, internalEnvoyListener}
 	routes := []cachetypes.Resource{externalRouteConfig, internalRouteConfig}
+	if probeRouteConfig != nil {
+		routes = append(routes, probeRouteConfig)
+	}
+
+	// The certificates are not part of the listeners. They are served via SDS, so
+	// rotating a certificate does not require Envoy to drain the listeners.
+	secrets := make([]cachetypes.Resource, 0, len(sniMatches)+len(localSNIMatches)+1)
+	// The same secret can be part of multiple matches, but must be served once.
+	secretNames := sets.NewString()
+	for _, matches := range [][]*envoy.SNIMatch{sniMatches, localSNIMatches} {
+		for _, match := range matches {
+			certs := append([]envoy.Certificate{{
+				CertSource:       match.CertSource,
+				CertificateChain: match.CertificateChain,
+				PrivateKey:       match.PrivateKey,
+			}}, match.AdditionalCertificates...)
+			for _, cert := range certs {
+				if name := envoy.SecretName(cert.CertSource); !secretNames.Has(name) {
+					secretNames.Insert(name)
+					secrets = append(secrets, envoy.NewSecret(name, cert.CertificateChain, cert.PrivateKey))
+				}
+			}
+
+			if match.ClientValidation != nil {
+				if name := envoy.ValidationSecretName(match.ClientValidation.CASource); !secretNames.Has(name) {
+					secretNames.Insert(name)
+					secrets = append(secrets, envoy.NewValidationSecret(name, match.ClientValidation.CABundle))
+				}
+			}
+		}
+	}
 
 	// create probe listeners
-	probHTTPListener, err := envoy.NewHTTPListener(externalManager, config.HTTPPortProb, false)
+	probHTTPListener, err := envoy.NewHTTPListener(probeManager, config.HTTPPortProb, false)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	listeners = append(listeners, probHTTPListener)
 
+	// The TLS parameters apply to the probe listeners too, so the prober connects like
+	// any other client.
+	tlsParams := cfg.Kourier.TLS.Parameters()
+
+	// The cluster-local HTTPS listener serves the same routes as the plain HTTP one, but
+	// only for the hosts with a certificate.
+	if len(localSNIMatches) > 0 {
+		internalHTTPSEnvoyListener, err := envoy.NewHTTPSListenerWithSNI(
+			internalManager, config.HTTPSPortInternal,
+			localSNIMatches, tlsParams, false,
+		)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		listeners = append(listeners, internalHTTPSEnvoyListener)
+	}
+
 	// Configure TLS Listener. If there's at least one ingress that contains the
 	// TLS field, that takes precedence. If there is not, TLS will be configured
 	// using a single cert for all the services if the creds are given via ENV.
 	if len(sniMatches) > 0 {
+		sharedMatches := make([]*envoy.SNIMatch, 0, len(sniMatches))
+		for i, match := range sniMatches {
+			if clientValidationRoutes[i] == nil {
+				sharedMatches = append(sharedMatches, match)
+			}
+		}
 		externalHTTPSEnvoyListener, err := envoy.NewHTTPSListenerWithSNI(
 			externalTLSManager, config.HTTPSPortExternal,
-			sniMatches, cfg.Kourier.EnableProxyProtocol,
+			sharedMatches, tlsParams, cfg.Kourier.EnableProxyProtocol,
 		)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
-		// create https prob listener with SNI
+		for i, routeConfig := range clientValidationRoutes {
+			if routeConfig == nil {
+				continue
+			}
+			manager := envoy.NewHTTPConnectionManager(routeConfig.Name, cfg.Kourier.EnableServiceAccessLogging, cfg.Kourier.EnableProxyProtocol, extAuthzFilter)
+			envoy.ForwardClientCertDetails(manager)
+			filterChains, err := envoy.CreateFilterChainsWithSNI(manager, sniMatches[i:i+1], tlsParams)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			externalHTTPSEnvoyListener.FilterChains = append(externalHTTPSEnvoyListener.FilterChains, filterChains...)
+			routes = append(routes, routeConfig)
+		}
+
+		// create https prob listener with SNI. The prober has no client certificate, so
+		// clients are never verified there.
 		probHTTPSListener, err := envoy.NewHTTPSListenerWithSNI(
-			externalManager, config.HTTPSPortProb,
-			sniMatches, false,
+			probeManager, config.HTTPSPortProb,
+			withoutClientValidation(sniMatches), tlsParams, false,
 		)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
 		// if a certificate is configured, add a new filter chain to TLS listener
-		if useHTTPSListenerWithOneCert() {
-			externalHTTPSEnvoyListenerWithOneCertFilterChain, err := newExternalEnvoyListenerWithOneCertFilterChain(
-				ctx, externalTLSManager, kubeclient,
+		if defaultCert != nil {
+			secrets = append(secrets, defaultCert)
+
+			externalHTTPSEnvoyListenerWithOneCertFilterChain, err := envoy.CreateFilterChainFromSecret(
+				externalTLSManager, defaultCert.Name, tlsParams,
 			)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 
 			externalHTTPSEnvoyListener.FilterChains = append(externalHTTPSEnvoyListener.FilterChains,
@@ -278,58 +707,141 @@ func generateListenersAndRouteConfigs(
 		}
 
 		listeners = append(listeners, externalHTTPSEnvoyListener, probHTTPSListener)
-		routes = append(routes, externalTLSRouteConfig)
-	} else if useHTTPSListenerWithOneCert() {
+		// The shared route config is unused if all hosts verify clients.
+		if len(sharedMatches) > 0 || defaultCert != nil {
+			routes = append(routes, externalTLSRouteConfig)
+		}
+	} else if defaultCert != nil {
+		secrets = append(secrets, defaultCert)
+
 		externalHTTPSEnvoyListener, err := newExternalEnvoyListenerWithOneCert(
-			ctx, externalTLSManager, kubeclient,
+			externalTLSManager, defaultCert.Name, tlsParams,
 			cfg.Kourier.EnableProxyProtocol,
 		)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
 		// create https prob listener
 		probHTTPSListener, err := envoy.NewHTTPSListener(config.HTTPSPortProb, externalHTTPSEnvoyListener.FilterChains, false)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
 		listeners = append(listeners, externalHTTPSEnvoyListener, probHTTPSListener)
 		routes = append(routes, externalTLSRouteConfig)
 	}
 
-	return listeners, routes, nil
+	return listeners, routes, secrets, nil
+}
+
+// clientValidationRouteConfigs returns a route config for each of the given matches
+// verifying clients, nil for the others, along with the given virtual hosts without
+// the hosts of these matches.
+//
+// Envoy picks the filter chain by the SNI of the client, but routes the requests by
+// their Host header. If all filter chains shared a route config, a client could connect
+// with the SNI of a host that doesn't verify clients, or one served the default
+// certificate, and then send its requests to a host that does. The route config of a
+// match verifying clients thus only holds the virtual hosts of its own hosts, which are
+// left out of the route config shared by the other filter chains.
+func clientValidationRouteConfigs(vhosts []*route.VirtualHost, sniMatches []*envoy.SNIMatch) ([]*route.VirtualHost, []*route.RouteConfiguration) {
+	routeConfigs := make([]*route.RouteConfiguration, len(sniMatches))
+	verifiedHosts := sets.NewString()
+	for i, match := range sniMatches {
+		if match.ClientValidation == nil {
+			continue
+		}
+		verifiedHosts.Insert(match.Hosts...)
+
+		// The key of the match is unique among the matches, so this name is too.
+		hash := sha256.Sum256([]byte(sniMatchKey(match)))
+		name := externalTLSRouteConfigName + "_" + hex.EncodeToString(hash[:8])
+		routeConfigs[i] = envoy.NewRouteConfig(name, vhostsForHosts(vhosts, sets.NewString(match.Hosts...), true))
+	}
+	if verifiedHosts.Len() == 0 {
+		return vhosts, routeConfigs
+	}
+	return vhostsForHosts(vhosts, verifiedHosts, false), routeConfigs
+}
+
+// vhostsForHosts returns the given virtual hosts restricted to the domains of the
+// given hosts, or to the domains of all other hosts if include is false. Virtual hosts
+// without any domains left are dropped. The given virtual hosts are not modified.
+func vhostsForHosts(vhosts []*route.VirtualHost, hosts sets.String, include bool) []*route.VirtualHost {
+	res := make([]*route.VirtualHost, 0, len(vhosts))
+	for _, vhost := range vhosts {
+		domains := make([]string, 0, len(vhost.Domains))
+		for _, domain := range vhost.Domains {
+			// See domainsForRule for the domains of a host.
+			if hosts.Has(strings.TrimSuffix(domain, ":*")) == include {
+				domains = append(domains, domain)
+			}
+		}
+		switch len(domains) {
+		case 0:
+			continue
+		case len(vhost.Domains):
+			res = append(res, vhost)
+		default:
+			copied := proto.Clone(vhost).(*route.VirtualHost)
+			copied.Domains = domains
+			res = append(res, copied)
+		}
+	}
+	return res
 }
 
-// Returns true if we need to modify the HTTPS listener with just one cert
-// instead of one per ingress
-func useHTTPSListenerWithOneCert() bool {
-	return os.Getenv(envCertsSecretNamespace) != "" &&
-		os.Getenv(envCertsSecretName) != ""
+// withoutClientValidation returns copies of the given matches that don't verify
+// clients.
+func withoutClientValidation(sniMatches []*envoy.SNIMatch) []*envoy.SNIMatch {
+	res := make([]*envoy.SNIMatch, 0, len(sniMatches))
+	for _, match := range sniMatches {
+		copied := *match
+		copied.ClientValidation = nil
+		res = append(res, &copied)
+	}
+	return res
 }
 
-func sslCreds(ctx context.Context, kubeClient kubeclient.Interface, secretNamespace string, secretName string) (certificateChain []byte, privateKey []byte, err error) {
-	secret, err := kubeClient.CoreV1().Secrets(secretNamespace).Get(ctx, secretName, metav1.GetOptions{})
-	if err != nil {
-		return nil, nil, err
+// defaultCertSecretRef returns the secret holding the default certificate.
+func defaultCertSecretRef(cfg *config.Kourier) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: cfg.CertsSecretNamespace,
+		Name:      cfg.CertsSecretName,
 	}
+}
 
-	return secret.Data[certFieldInSecret], secret.Data[keyFieldInSecret], nil
+// IsDefaultCertSecret returns true if the given secret holds the default certificate
+// of the given configuration.
+func IsDefaultCertSecret(secret *corev1.Secret, cfg *config.Kourier) bool {
+	return cfg.HasCertsSecret() && defaultCertSecretRef(cfg) == types.NamespacedName{
+		Namespace: secret.Namespace,
+		Name:      secret.Name,
+	}
 }
 
-func newExternalEnvoyListenerWithOneCertFilterChain(ctx context.Context, manager *httpconnmanagerv3.HttpConnectionManager, kubeClient kubeclient.Interface) (*v3.FilterChain, error) {
-	certificateChain, privateKey, err := sslCreds(
-		ctx, kubeClient, os.Getenv(envCertsSecretNamespace), os.Getenv(envCertsSecretName),
-	)
-	if err != nil {
-		return nil, err
+// defaultCertificateInfo describes the default certificate, if it's among the given
+// secrets.
+func defaultCertificateInfo(secrets []cachetypes.Resource, cfg *config.Kourier) (CertificateInfo, bool) {
+	if !cfg.HasCertsSecret() {
+		return CertificateInfo{}, false
 	}
+	secretRef := defaultCertSecretRef(cfg)
 
-	return envoy.CreateFilterChainFromCertificateAndPrivateKey(manager, certificateChain, privateKey)
+	for _, resource := range secrets {
+		secret := resource.(*auth.Secret)
+		if secret.Name != envoy.SecretName(secretRef) {
+			continue
+		}
+		info, err := newCertificateInfo(secretRef, secret.GetTlsCertificate().GetCertificateChain().GetInlineBytes(), nil)
+		return info, err == nil
+	}
+	return CertificateInfo{}, false
 }
 
-func newExternalEnvoyListenerWithOneCert(ctx context.Context, manager *httpconnmanagerv3.HttpConnectionManager, kubeClient kubeclient.Interface, enableProxyProtocol bool) (*v3.Listener, error) {
-	filterChain, err := newExternalEnvoyListenerWithOneCertFilterChain(ctx, manager, kubeClient)
+func newExternalEnvoyListenerWithOneCert(manager *httpconnmanagerv3.HttpConnectionManager, secretName string, tlsParams *auth.TlsParameters, enableProxyProtocol bool) (*v3.Listener, error) {
+	filterChain, err := envoy.CreateFilterChainFromSecret(manager, secretName, tlsParams)
 	if err != nil {
 		return nil, err
 	}
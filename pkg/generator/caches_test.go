@@ -20,24 +20,33 @@ import (
 	"context"
 	"sort"
 	"testing"
+	"time"
 
 	v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/anypb"
 	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/kubernetes/fake"
 	"knative.dev/net-kourier/pkg/config"
 	envoy "knative.dev/net-kourier/pkg/envoy/api"
+	rconfig "knative.dev/net-kourier/pkg/reconciler/ingress/config"
+	networking "knative.dev/networking/pkg"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
 )
 
 func TestDeleteIngressInfo(t *testing.T) {
-	kubeClient := fake.Clientset{}
 	ctx := context.Background()
 
-	caches, err := NewCaches(ctx, &kubeClient, false)
+	caches, err := NewCaches(ctx)
 	assert.NilError(t, err)
 
 	// Add info for an ingress
@@ -72,6 +81,10 @@ func TestDeleteIngressInfo(t *testing.T) {
 	snapshot, err := caches.ToEnvoySnapshot(ctx)
 	assert.NilError(t, err)
 
+	assert.DeepEqual(t, snapshot.Ingresses, map[types.NamespacedName]int64{
+		{Namespace: secondIngressNamespace, Name: secondIngressName}: 0,
+	})
+
 	routeConfigsR := snapshot.GetResources(resource.RouteType)
 	routeConfigs := make([]*route.RouteConfiguration, len(routeConfigsR))
 	for _, r := range routeConfigsR {
@@ -97,13 +110,143 @@ func TestDeleteIngressInfo(t *testing.T) {
 	assert.DeepEqual(t, expectedNames, vHostsNames)
 }
 
+func TestExcludedIngresses(t *testing.T) {
+	ctx := context.Background()
+
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+
+	first := types.NamespacedName{Namespace: "ns", Name: "ingress_1"}
+	second := types.NamespacedName{Namespace: "ns", Name: "ingress_2"}
+	createTestDataForIngress(caches, first.Name, first.Namespace,
+		"cluster_for_ingress_1", "internal_host_for_ingress_1", "external_host_for_ingress_1", "external_tls_host_for_ingress_1")
+	createTestDataForIngress(caches, second.Name, second.Namespace,
+		"cluster_for_ingress_2", "internal_host_for_ingress_2", "external_host_for_ingress_2", "external_tls_host_for_ingress_2")
+
+	caches.SetExcludedIngresses(map[types.NamespacedName]int64{first: 0})
+	snapshot, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, snapshot.Ingresses, map[types.NamespacedName]int64{second: 0})
+	assert.DeepEqual(t, getClusterNames(snapshot), []string{"cluster_for_ingress_2"})
+
+	// Other generations of the ingress are not excluded.
+	caches.SetExcludedIngresses(map[types.NamespacedName]int64{first: 1})
+	snapshot, err = caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, snapshot.Ingresses, map[types.NamespacedName]int64{first: 0, second: 0})
+	assert.DeepEqual(t, getClusterNames(snapshot), []string{"cluster_for_ingress_1", "cluster_for_ingress_2"})
+}
+
+func TestInvalidIngress(t *testing.T) {
+	ctx := context.Background()
+
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+
+	valid := types.NamespacedName{Namespace: "ns", Name: "valid"}
+	invalid := types.NamespacedName{Namespace: "ns", Name: "invalid"}
+	createTestDataForIngress(caches, valid.Name, valid.Namespace,
+		"cluster_for_valid", "internal_host_for_valid", "external_host_for_valid", "external_tls_host_for_valid")
+	caches.addTranslatedIngress(&translatedIngress{
+		name:     invalid,
+		clusters: []*v3.Cluster{{Name: "cluster_for_invalid"}},
+		// A virtual host must have at least one domain.
+		externalVirtualHosts: []*route.VirtualHost{{Name: "external_host_for_invalid"}},
+	})
+
+	assert.NilError(t, caches.ValidationError(valid))
+	assert.ErrorContains(t, caches.ValidationError(invalid), `invalid virtual host "external_host_for_invalid"`)
+
+	snapshot, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, snapshot.Ingresses, map[types.NamespacedName]int64{valid: 0})
+	assert.DeepEqual(t, getClusterNames(snapshot), []string{"cluster_for_valid"})
+}
+
+func TestAttributeError(t *testing.T) {
+	ctx := context.Background()
+
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+
+	first := types.NamespacedName{Namespace: "ns", Name: "ingress_1"}
+	second := types.NamespacedName{Namespace: "ns", Name: "ingress_2"}
+	createTestDataForIngress(caches, first.Name, first.Namespace,
+		"cluster_for_ingress_1", "internal_host_for_ingress_1", "external_host_for_ingress_1", "external_tls_host_for_ingress_1")
+	createTestDataForIngress(caches, second.Name, second.Namespace,
+		"cluster_for_ingress_2", "internal_host_for_ingress_2", "external_host_for_ingress_2", "external_tls_host_for_ingress_2")
+	third := types.NamespacedName{Namespace: "ns", Name: "ingress_3"}
+	createTestDataForIngress(caches, third.Name, third.Namespace,
+		"ns/svc", "foo.example.com", "external_host_for_ingress_3", "external_tls_host_for_ingress_3")
+	candidates := []types.NamespacedName{first, second, third}
+
+	tests := []struct {
+		name    string
+		message string
+		want    []types.NamespacedName
+	}{{
+		name:    "cluster",
+		message: "cluster: unknown cluster 'cluster_for_ingress_2'",
+		want:    []types.NamespacedName{second},
+	}, {
+		name:    "domain",
+		message: "Only unique values for domains are permitted. Duplicate entry of domain internal_host_for_ingress_1",
+		want:    []types.NamespacedName{first},
+	}, {
+		name:    "route name",
+		message: "invalid regex in route (ns/ingress_1).Rules[0].Paths[/]",
+		want:    []types.NamespacedName{first},
+	}, {
+		name:    "shared secret",
+		message: "invalid secret secretns/secretname",
+		want:    candidates,
+	}, {
+		name:    "quoted cluster",
+		message: "cluster: unknown cluster 'ns/svc'",
+		want:    []types.NamespacedName{third},
+	}, {
+		name:    "cluster with longer name",
+		message: "cluster: unknown cluster 'ns/svc2'",
+	}, {
+		name:    "cluster as prefix of a secret",
+		message: "unknown secret ns/svc/ca",
+	}, {
+		name:    "domain ending a sentence",
+		message: "Duplicate entry of domain foo.example.com.",
+		want:    []types.NamespacedName{third},
+	}, {
+		name:    "domain with longer name",
+		message: "Duplicate entry of domain barfoo.example.com",
+	}, {
+		name:    "subdomain",
+		message: "Duplicate entry of domain foo.example.com.org",
+	}, {
+		name:    "unknown",
+		message: "something else",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.DeepEqual(t, caches.AttributeError(test.message, candidates), test.want)
+		})
+	}
+}
+
+func getClusterNames(snapshot Snapshot) []string {
+	var names []string
+	for name := range snapshot.GetResources(resource.ClusterType) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func TestDeleteIngressInfoWhenDoesNotExist(t *testing.T) {
 	// If the ingress does not exist, nothing should be deleted from the caches
 	// instance.
-	kubeClient := fake.Clientset{}
 	ctx := context.Background()
 
-	caches, err := NewCaches(ctx, &kubeClient, false)
+	caches, err := NewCaches(ctx)
 	assert.NilError(t, err)
 
 	// Add info for an ingress
@@ -152,15 +295,22 @@ func TestDeleteIngressInfoWhenDoesNotExist(t *testing.T) {
 	assert.DeepEqual(t, listenersBeforeDelete, listenersAfterDelete, protocmp.Transform())
 }
 
-func TestTLSListenerWithEnvCertsSecret(t *testing.T) {
-	t.Setenv(envCertsSecretNamespace, "certns")
-	t.Setenv(envCertsSecretName, "secretname")
+func TestTLSListenerWithConfigCertsSecret(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CertsSecretNamespace = "certns"
+	cfg.CertsSecretName = "secretname"
 
-	kubeClient := fake.Clientset{}
-	ctx := context.Background()
+	ctx := rconfig.ToContext(context.Background(), &rconfig.Config{Kourier: cfg})
 
-	caches, err := NewCaches(ctx, &kubeClient, false)
+	caches, err := NewCaches(ctx)
 	assert.NilError(t, err)
+	caches.SetDefaultCertSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certns", Name: "secretname"},
+		Data: map[string][]byte{
+			certFieldInSecret: cert,
+			keyFieldInSecret:  privateKey,
+		},
+	})
 
 	fooSNIMatch := &envoy.SNIMatch{
 		Hosts:            []string{"foo.example.com"},
@@ -243,7 +393,333 @@ func TestTLSListenerWithEnvCertsSecret(t *testing.T) {
 		assert.Check(t, filterChainsByServerName["foo.example.com"] != nil)
 		assert.Check(t, filterChainsByServerName["bar.example.com"] != nil)
 		assert.Check(t, filterChainsByServerName[""] != nil) // filter chain without server name, "default" one
+
+		// The certificates are served via SDS rather than being inlined.
+		secrets := snapshot.GetResources(resource.SecretType)
+		assert.Check(t, len(secrets) == 3)
+		assert.Check(t, secrets["secretns/secretname1"] != nil)
+		assert.Check(t, secrets["secretns/secretname2"] != nil)
+		assert.Check(t, secrets["certns/secretname"] != nil)
+	})
+}
+
+func TestTLSListenerWithClientValidation(t *testing.T) {
+	ctx := context.Background()
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+
+	validation := &envoy.ClientValidation{
+		CASource: types.NamespacedName{Namespace: "testspace", Name: "partner-ca"},
+		CABundle: []byte("ca"),
+	}
+	err = caches.addTranslatedIngress(&translatedIngress{
+		name: types.NamespacedName{Namespace: "testspace", Name: "testname"},
+		sniMatches: []*envoy.SNIMatch{{
+			Hosts:            []string{"foo.example.com"},
+			CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secretname1"},
+			CertificateChain: []byte("cert1"),
+			PrivateKey:       []byte("privateKey1"),
+			ClientValidation: validation,
+		}, {
+			Hosts:            []string{"bar.example.com"},
+			CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secretname2"},
+			CertificateChain: []byte("cert2"),
+			PrivateKey:       []byte("privateKey2"),
+			ClientValidation: validation,
+		}},
 	})
+	assert.NilError(t, err)
+
+	snapshot, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+
+	// The CA bundle is served via SDS once.
+	secrets := snapshot.GetResources(resource.SecretType)
+	assert.Check(t, len(secrets) == 3)
+	assert.Check(t, secrets["testspace/partner-ca/ca"] != nil)
+
+	requiresClientCertificate := func(port uint32) bool {
+		tlsListener := snapshot.GetResources(resource.ListenerType)[envoy.CreateListenerName(port)].(*listener.Listener)
+		tlsContext := &auth.DownstreamTlsContext{}
+		err := anypb.UnmarshalTo(tlsListener.FilterChains[0].GetTransportSocket().GetTypedConfig(), tlsContext, proto.UnmarshalOptions{})
+		assert.NilError(t, err)
+		return tlsContext.RequireClientCertificate.GetValue()
+	}
+	assert.Check(t, requiresClientCertificate(config.HTTPSPortExternal))
+	// The prober has no client certificate.
+	assert.Check(t, !requiresClientCertificate(config.HTTPSPortProb))
+}
+
+func TestTLSListenerClusterLocal(t *testing.T) {
+	ctx := context.Background()
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+
+	snapshot, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+
+	// No cluster-local HTTPS listener without certificates.
+	localListenerName := envoy.CreateListenerName(config.HTTPSPortInternal)
+	assert.Check(t, snapshot.GetResources(resource.ListenerType)[localListenerName] == nil)
+
+	err = caches.addTranslatedIngress(&translatedIngress{
+		name: types.NamespacedName{Namespace: "testspace", Name: "testname"},
+		localSNIMatches: []*envoy.SNIMatch{{
+			Hosts:            []string{"foo.testspace.svc.cluster.local"},
+			CertSource:       types.NamespacedName{Namespace: "certns", Name: "cluster-local"},
+			CertificateChain: []byte("cert"),
+			PrivateKey:       []byte("privateKey"),
+		}},
+	})
+	assert.NilError(t, err)
+
+	snapshot, err = caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+
+	tlsListener := snapshot.GetResources(resource.ListenerType)[localListenerName].(*listener.Listener)
+	assert.Equal(t, len(tlsListener.FilterChains), 1)
+	assert.DeepEqual(t, tlsListener.FilterChains[0].FilterChainMatch.ServerNames, []string{"foo.testspace.svc.cluster.local"})
+	assert.Check(t, snapshot.GetResources(resource.SecretType)["certns/cluster-local"] != nil)
+
+	// The cluster-local hosts are not served by the external listeners.
+	assert.Check(t, snapshot.GetResources(resource.ListenerType)[envoy.CreateListenerName(config.HTTPSPortExternal)] == nil)
+}
+
+func TestTLSListenerWithTLSParameters(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CertsSecretNamespace = "certns"
+	cfg.CertsSecretName = "secretname"
+	cfg.TLS = config.TLSConfig{MinVersion: "1.2", CipherSuites: []string{"ECDHE-ECDSA-AES128-GCM-SHA256"}}
+	ctx := rconfig.ToContext(context.Background(), &rconfig.Config{Kourier: cfg})
+
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+	caches.SetDefaultCertSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certns", Name: "secretname"},
+		Data: map[string][]byte{
+			certFieldInSecret: cert,
+			keyFieldInSecret:  privateKey,
+		},
+	})
+	err = caches.addTranslatedIngress(&translatedIngress{
+		sniMatches: []*envoy.SNIMatch{{
+			Hosts:            []string{"foo.example.com"},
+			CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secretname1"},
+			CertificateChain: []byte("cert1"),
+			PrivateKey:       []byte("privateKey1"),
+		}},
+	})
+	assert.NilError(t, err)
+
+	snapshot, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+
+	// Both the SNI and the default certificate's filter chains of the external and the
+	// probe listener use the parameters.
+	for _, port := range []uint32{config.HTTPSPortExternal, config.HTTPSPortProb} {
+		tlsListener := snapshot.GetResources(resource.ListenerType)[envoy.CreateListenerName(port)].(*listener.Listener)
+		assert.Equal(t, len(tlsListener.FilterChains), 2)
+		for _, filterChain := range tlsListener.FilterChains {
+			tlsContext := &auth.DownstreamTlsContext{}
+			err := anypb.UnmarshalTo(filterChain.GetTransportSocket().GetTypedConfig(), tlsContext, proto.UnmarshalOptions{})
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tlsContext.CommonTlsContext.TlsParams, cfg.TLS.Parameters(), protocmp.Transform())
+		}
+	}
+}
+
+func TestExternalSchemeOverwrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		network *config.Network
+		want    string
+	}{{
+		name:    "http",
+		network: &config.Network{DefaultExternalScheme: "http"},
+	}, {
+		name:    "https",
+		network: &config.Network{DefaultExternalScheme: "https"},
+		want:    "https",
+	}, {
+		name:    "https with auto TLS",
+		network: &config.Network{DefaultExternalScheme: "https", AutoTLS: true},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := rconfig.ToContext(context.Background(), &rconfig.Config{Kourier: config.DefaultConfig(), Network: test.network})
+			caches, err := NewCaches(ctx)
+			assert.NilError(t, err)
+
+			snapshot, err := caches.ToEnvoySnapshot(ctx)
+			assert.NilError(t, err)
+
+			httpListener := snapshot.GetResources(resource.ListenerType)[envoy.CreateListenerName(config.HTTPPortExternal)].(*listener.Listener)
+			manager := &hcm.HttpConnectionManager{}
+			err = anypb.UnmarshalTo(httpListener.FilterChains[0].Filters[0].GetTypedConfig(), manager, proto.UnmarshalOptions{})
+			assert.NilError(t, err)
+			assert.Equal(t, manager.SchemeHeaderTransformation.GetSchemeToOverwrite(), test.want)
+		})
+	}
+}
+
+func TestHTTPDisabled(t *testing.T) {
+	ctx := rconfig.ToContext(context.Background(), &rconfig.Config{
+		Kourier: config.DefaultConfig(),
+		Network: &config.Network{HTTPProtocol: networking.HTTPDisabled},
+	})
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+
+	vhost := envoy.NewVirtualHost("(testspace/testname).Rules[0]", domainsForRule(v1alpha1.IngressRule{Hosts: []string{"foo.example.com"}}), nil)
+	err = caches.addTranslatedIngress(&translatedIngress{
+		name:                 types.NamespacedName{Namespace: "testspace", Name: "testname"},
+		externalVirtualHosts: []*route.VirtualHost{vhost},
+	})
+	assert.NilError(t, err)
+
+	snapshot, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+
+	routedDomains := func(port uint32) []string {
+		httpListener := snapshot.GetResources(resource.ListenerType)[envoy.CreateListenerName(port)].(*listener.Listener)
+		manager := &hcm.HttpConnectionManager{}
+		err := anypb.UnmarshalTo(httpListener.FilterChains[0].Filters[0].GetTypedConfig(), manager, proto.UnmarshalOptions{})
+		assert.NilError(t, err)
+		routeConfig := snapshot.GetResources(resource.RouteType)[manager.GetRds().GetRouteConfigName()].(*route.RouteConfiguration)
+		var domains []string
+		for _, vhost := range routeConfig.VirtualHosts {
+			domains = append(domains, vhost.Domains...)
+		}
+		return domains
+	}
+
+	// The public listener doesn't serve plain HTTP, but the prober still reaches the
+	// host.
+	assert.Equal(t, len(routedDomains(config.HTTPPortExternal)), 0)
+	assert.DeepEqual(t, routedDomains(config.HTTPPortProb), []string{"foo.example.com", "foo.example.com:*"})
+}
+
+func TestTLSListenerWithClientValidationHostMismatch(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CertsSecretNamespace = "certns"
+	cfg.CertsSecretName = "secretname"
+	ctx := rconfig.ToContext(context.Background(), &rconfig.Config{Kourier: cfg})
+
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+	caches.SetDefaultCertSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certns", Name: "secretname"},
+		Data: map[string][]byte{
+			certFieldInSecret: cert,
+			keyFieldInSecret:  privateKey,
+		},
+	})
+
+	vhost := func(name string, hosts ...string) *route.VirtualHost {
+		return envoy.NewVirtualHost(name, domainsForRule(v1alpha1.IngressRule{Hosts: hosts}), nil)
+	}
+	err = caches.addTranslatedIngress(&translatedIngress{
+		name: types.NamespacedName{Namespace: "testspace", Name: "secure"},
+		sniMatches: []*envoy.SNIMatch{{
+			Hosts:            []string{"secure.example.com"},
+			CertSource:       types.NamespacedName{Namespace: "testspace", Name: "secure"},
+			CertificateChain: []byte("cert1"),
+			PrivateKey:       []byte("privateKey1"),
+			ClientValidation: &envoy.ClientValidation{
+				CASource: types.NamespacedName{Namespace: "testspace", Name: "partner-ca"},
+				CABundle: []byte("ca"),
+			},
+		}},
+		externalTLSVirtualHosts: []*route.VirtualHost{vhost("(testspace/secure).Rules[0]", "secure.example.com")},
+	})
+	assert.NilError(t, err)
+	err = caches.addTranslatedIngress(&translatedIngress{
+		name: types.NamespacedName{Namespace: "testspace", Name: "public"},
+		sniMatches: []*envoy.SNIMatch{{
+			Hosts:            []string{"public.example.com"},
+			CertSource:       types.NamespacedName{Namespace: "testspace", Name: "public"},
+			CertificateChain: []byte("cert2"),
+			PrivateKey:       []byte("privateKey2"),
+		}},
+		externalTLSVirtualHosts: []*route.VirtualHost{vhost("(testspace/public).Rules[0]", "public.example.com")},
+	})
+	assert.NilError(t, err)
+
+	snapshot, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+
+	// routedDomains returns the domains requests on the given filter chain are routed
+	// to, whatever the SNI of the client.
+	routedDomains := func(filterChain *listener.FilterChain) []string {
+		manager := &hcm.HttpConnectionManager{}
+		err := anypb.UnmarshalTo(filterChain.Filters[0].GetTypedConfig(), manager, proto.UnmarshalOptions{})
+		assert.NilError(t, err)
+		routeConfig := snapshot.GetResources(resource.RouteType)[manager.GetRds().GetRouteConfigName()].(*route.RouteConfiguration)
+		var domains []string
+		for _, vhost := range routeConfig.VirtualHosts {
+			domains = append(domains, vhost.Domains...)
+		}
+		return domains
+	}
+
+	tlsListener := snapshot.GetResources(resource.ListenerType)[envoy.CreateListenerName(config.HTTPSPortExternal)].(*listener.Listener)
+	assert.Assert(t, len(tlsListener.FilterChains) == 3)
+	for _, filterChain := range tlsListener.FilterChains {
+		serverName := "default certificate"
+		if filterChain.FilterChainMatch != nil {
+			serverName = filterChain.FilterChainMatch.ServerNames[0]
+		}
+		want := []string{"public.example.com", "public.example.com:*"}
+		if serverName == "secure.example.com" {
+			want = []string{"secure.example.com", "secure.example.com:*"}
+		}
+		assert.DeepEqual(t, routedDomains(filterChain), want)
+	}
+}
+
+func TestDefaultCertSecret(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CertsSecretNamespace = "certns"
+	cfg.CertsSecretName = "secretname"
+	ctx := rconfig.ToContext(context.Background(), &rconfig.Config{Kourier: cfg})
+
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+
+	// The snapshot doesn't fail if the secret hasn't been seen, the default
+	// certificate is left out instead.
+	snapshot, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, snapshot.GetResources(resource.ListenerType)[envoy.CreateListenerName(config.HTTPSPortExternal)] == nil)
+	assert.Check(t, len(snapshot.GetResources(resource.SecretType)) == 0)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certns", Name: "secretname"},
+		Data: map[string][]byte{
+			certFieldInSecret: cert,
+			keyFieldInSecret:  privateKey,
+		},
+	}
+	assert.Check(t, caches.SetDefaultCertSecret(secret))
+	assert.Check(t, !caches.SetDefaultCertSecret(secret.DeepCopy()), "unchanged secret")
+
+	snapshot, err = caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, snapshot.GetResources(resource.ListenerType)[envoy.CreateListenerName(config.HTTPSPortExternal)] != nil)
+	assert.Check(t, snapshot.GetResources(resource.SecretType)["certns/secretname"] != nil)
+
+	// A secret that's not the configured one is not served.
+	other := secret.DeepCopy()
+	other.Name = "other"
+	assert.Check(t, caches.SetDefaultCertSecret(other))
+
+	snapshot, err = caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, len(snapshot.GetResources(resource.SecretType)) == 0)
+
+	assert.Check(t, caches.SetDefaultCertSecret(nil))
+	assert.Check(t, !caches.SetDefaultCertSecret(nil))
 }
 
 // Creates an ingress translation and listeners from the given names an
@@ -277,10 +753,9 @@ func createTestDataForIngress(
 }
 
 func TestValidateIngress(t *testing.T) {
-	kubeClient := fake.Clientset{}
 	ctx := context.Background()
 
-	caches, err := NewCaches(ctx, &kubeClient, false)
+	caches, err := NewCaches(ctx)
 	assert.NilError(t, err)
 
 	createTestDataForIngress(
@@ -325,3 +800,187 @@ func getVHostsNames(routeConfigs []*route.RouteConfiguration) []string {
 
 	return res
 }
+
+func TestUpdateEndpoints(t *testing.T) {
+	ctx := context.Background()
+
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+
+	clusterName := "servicens/servicename"
+	caches.addTranslatedIngress(&translatedIngress{
+		name: types.NamespacedName{
+			Namespace: "ingress_namespace",
+			Name:      "ingress_name",
+		},
+		clusters: []*v3.Cluster{envoy.NewEDSCluster(clusterName, 5*time.Second, false)},
+		loadAssignments: map[string]*loadAssignment{
+			clusterName: newLoadAssignment(clusterName, &corev1.Endpoints{}, 8080),
+		},
+	})
+
+	// Endpoints that don't back any cluster don't require a new snapshot.
+	assert.Assert(t, !caches.UpdateEndpoints(eps("otherns", "othername")))
+	assert.Assert(t, caches.UpdateEndpoints(eps("servicens", "servicename")))
+
+	snapshot, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+
+	assert.DeepEqual(t,
+		snapshot.GetResources(resource.EndpointType)[clusterName],
+		envoy.NewClusterLoadAssignment(clusterName, lbEndpoints),
+		protocmp.Transform())
+}
+
+func TestUpstreamTLSSecrets(t *testing.T) {
+	ctx := context.Background()
+
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+
+	newUpstreamTLS := func(caBundle string) *envoy.UpstreamTLS {
+		return &envoy.UpstreamTLS{
+			CASource: types.NamespacedName{Namespace: "kourier-system", Name: "upstream-ca"},
+			CABundle: []byte(caBundle),
+			ClientCertificate: &envoy.Certificate{
+				CertSource:       types.NamespacedName{Namespace: "kourier-system", Name: "upstream-client"},
+				CertificateChain: []byte("cert"),
+				PrivateKey:       []byte("key"),
+			},
+		}
+	}
+	addIngress := func(name string, tls *envoy.UpstreamTLS) {
+		clusterName := "servicens/servicename"
+		cluster := envoy.NewEDSCluster(clusterName, 5*time.Second, false)
+		err := envoy.SetUpstreamTLS(cluster, tls, "servicename.servicens.svc.cluster.local", false)
+		assert.NilError(t, err)
+		err = caches.addTranslatedIngress(&translatedIngress{
+			name:     types.NamespacedName{Namespace: "testspace", Name: name},
+			clusters: []*v3.Cluster{cluster},
+			loadAssignments: map[string]*loadAssignment{
+				clusterName: newLoadAssignment(clusterName, &corev1.Endpoints{}, 8080),
+			},
+			upstreamSecrets: map[string][]*auth.Secret{clusterName: tls.Secrets()},
+		})
+		assert.NilError(t, err)
+	}
+	assertSecrets := func(caBundle string) {
+		t.Helper()
+		snapshot, err := caches.ToEnvoySnapshot(ctx)
+		assert.NilError(t, err)
+
+		secrets := snapshot.GetResources(resource.SecretType)
+		assert.Equal(t, len(secrets), 2)
+		assert.DeepEqual(t, secrets["kourier-system/upstream-ca/ca"].(*auth.Secret).GetValidationContext().TrustedCa.GetInlineBytes(), []byte(caBundle))
+		assert.DeepEqual(t, secrets["kourier-system/upstream-client"].(*auth.Secret).GetTlsCertificate().PrivateKey.GetInlineBytes(), []byte("key"))
+	}
+
+	addIngress("first", newUpstreamTLS("ca"))
+	assertSecrets("ca")
+
+	// The secrets are served as long as the cluster referencing them.
+	caches.DeleteIngressInfo(ctx, "first", "testspace")
+	assertSecrets("ca")
+
+	// The secrets of the clusters in use take precedence over the ones of the
+	// expiring clusters.
+	addIngress("second", newUpstreamTLS("rotated-ca"))
+	assertSecrets("rotated-ca")
+}
+
+func TestSnapshotResourceVersions(t *testing.T) {
+	ctx := context.Background()
+
+	caches, err := NewCaches(ctx)
+	assert.NilError(t, err)
+
+	createTestDataForIngress(
+		caches,
+		"ingress_1",
+		"ingress_1_namespace",
+		"cluster_for_ingress_1",
+		"internal_host_for_ingress_1",
+		"external_host_for_ingress_1",
+		"external_tls_host_for_ingress_1",
+	)
+
+	first, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+
+	// Every resource gets a version to be used by the delta xDS server.
+	for _, typ := range []resource.Type{resource.ClusterType, resource.RouteType, resource.ListenerType} {
+		versions := first.GetVersionMap(typ)
+		assert.Equal(t, len(versions), len(first.GetResources(typ)))
+		for name := range first.GetResources(typ) {
+			assert.Assert(t, versions[name] != "", "no version for %s %q", typ, name)
+		}
+	}
+
+	// Resources that did not change keep their version.
+	second, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, first.VersionMap, second.VersionMap)
+	assert.DeepEqual(t, snapshotVersions(first.Snapshot), snapshotVersions(second.Snapshot))
+
+	// Changing the routes only changes the version of the routes.
+	createTestDataForIngress(
+		caches,
+		"ingress_2",
+		"ingress_2_namespace",
+		"cluster_for_ingress_1",
+		"internal_host_for_ingress_2",
+		"external_host_for_ingress_2",
+		"external_tls_host_for_ingress_2",
+	)
+
+	third, err := caches.ToEnvoySnapshot(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, first.GetVersion(resource.ClusterType), third.GetVersion(resource.ClusterType))
+	assert.Equal(t, first.GetVersion(resource.ListenerType), third.GetVersion(resource.ListenerType))
+	assert.Assert(t, first.GetVersion(resource.RouteType) != third.GetVersion(resource.RouteType))
+}
+
+func TestSnapshotVersionsIndependentOfInsertionOrder(t *testing.T) {
+	ctx := context.Background()
+
+	ingresses := [][]string{
+		{"ingress_1", "ingress_1_namespace", "cluster_for_ingress_1", "internal_host_for_ingress_1",
+			"external_host_for_ingress_1", "external_tls_host_for_ingress_1"},
+		{"ingress_2", "ingress_2_namespace", "cluster_for_ingress_2", "internal_host_for_ingress_2",
+			"external_host_for_ingress_2", "external_tls_host_for_ingress_2"},
+		{"ingress_3", "ingress_3_namespace", "cluster_for_ingress_3", "internal_host_for_ingress_3",
+			"external_host_for_ingress_3", "external_tls_host_for_ingress_3"},
+	}
+
+	var versions []map[resource.Type]string
+	for _, order := range [][]int{{0, 1, 2}, {2, 0, 1}, {1, 2, 0}} {
+		caches, err := NewCaches(ctx)
+		assert.NilError(t, err)
+
+		for _, i := range order {
+			ing := ingresses[i]
+			createTestDataForIngress(caches, ing[0], ing[1], ing[2], ing[3], ing[4], ing[5])
+		}
+
+		snapshot, err := caches.ToEnvoySnapshot(ctx)
+		assert.NilError(t, err)
+		versions = append(versions, snapshotVersions(snapshot.Snapshot))
+	}
+
+	assert.DeepEqual(t, versions[0], versions[1])
+	assert.DeepEqual(t, versions[0], versions[2])
+}
+
+func snapshotVersions(snapshot cache.Snapshot) map[resource.Type]string {
+	versions := make(map[resource.Type]string)
+	for _, typ := range []resource.Type{
+		resource.ClusterType,
+		resource.EndpointType,
+		resource.RouteType,
+		resource.ListenerType,
+		resource.SecretType,
+	} {
+		versions[typ] = snapshot.GetVersion(typ)
+	}
+	return versions
+}
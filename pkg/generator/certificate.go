@@ -0,0 +1,158 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
+)
+
+// ErrCertificateInvalid is returned for ingresses referencing a TLS secret that
+// doesn't hold a valid certificate and key for their hosts.
+var ErrCertificateInvalid = errors.New("invalid certificate")
+
+// CertificateInfo describes the certificate of a TLS secret.
+type CertificateInfo struct {
+	Secret types.NamespacedName
+	// NotBefore is the time the certificate becomes valid at.
+	NotBefore time.Time
+	// NotAfter is the time the certificate expires at.
+	NotAfter time.Time
+	// Hosts are the hosts the certificate is used for, if known.
+	Hosts []string
+	// UncoveredHosts are the hosts among Hosts the certificate is not valid for.
+	UncoveredHosts []string
+}
+
+// newCertificateInfo describes the given PEM encoded certificate chain, used for the
+// given hosts.
+func newCertificateInfo(secret types.NamespacedName, certificateChain []byte, hosts []string) (CertificateInfo, error) {
+	leaf, err := parseLeafCertificate(certificateChain)
+	if err != nil {
+		return CertificateInfo{}, err
+	}
+
+	info := CertificateInfo{
+		Secret:    secret,
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		Hosts:     hosts,
+	}
+	for _, host := range hosts {
+		if err := leaf.VerifyHostname(host); err != nil {
+			info.UncoveredHosts = append(info.UncoveredHosts, host)
+		}
+	}
+	return info, nil
+}
+
+// parseLeafCertificate parses the first certificate of the given PEM encoded chain.
+func parseLeafCertificate(certificateChain []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certificateChain)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("failed to find a certificate in PEM data")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// validateCertificate verifies that the given PEM encoded certificate chain and
+// private key form a key pair, that the certificate is valid at the given time and
+// that it covers all the given hosts.
+func validateCertificate(certificateChain, privateKey []byte, hosts []string, now time.Time) error {
+	pair, err := tls.X509KeyPair(certificateChain, privateKey)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("certificate is not valid before %s", leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	for _, host := range hosts {
+		if err := leaf.VerifyHostname(host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCABundle verifies that the given PEM data holds at least one certificate
+// and nothing but certificates.
+func validateCABundle(caBundle []byte) error {
+	var count int
+	for rest := caBundle; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("unexpected PEM block of type %q", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return err
+		}
+		count++
+	}
+
+	if count == 0 {
+		return errors.New("failed to find a certificate in PEM data")
+	}
+	return nil
+}
+
+// validateKeyTypes verifies that the certificates of the given match, where they
+// could be parsed, use distinct key types. Envoy picks the certificate to serve by
+// its type, so there can only be one of each.
+func validateKeyTypes(match *envoy.SNIMatch) error {
+	if len(match.AdditionalCertificates) == 0 {
+		return nil
+	}
+
+	certs := append([]envoy.Certificate{{
+		CertSource:       match.CertSource,
+		CertificateChain: match.CertificateChain,
+	}}, match.AdditionalCertificates...)
+	sources := make(map[x509.PublicKeyAlgorithm]types.NamespacedName, len(certs))
+	for _, cert := range certs {
+		leaf, err := parseLeafCertificate(cert.CertificateChain)
+		if err != nil {
+			continue
+		}
+		if other, ok := sources[leaf.PublicKeyAlgorithm]; ok {
+			return fmt.Errorf("secrets %s and %s both hold %s certificates for hosts %s",
+				other, cert.CertSource, leaf.PublicKeyAlgorithm, strings.Join(match.Hosts, ", "))
+		}
+		sources[leaf.PublicKeyAlgorithm] = cert.CertSource
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"k8s.io/apimachinery/pkg/types"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
+)
+
+// generateCertificate returns a PEM encoded self-signed ECDSA certificate for the
+// given hosts, valid in the given period, and its private key.
+func generateCertificate(hosts []string, notBefore, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return selfSign(hosts, notBefore, notAfter, key), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// generateRSACertificate is like generateCertificate, but returns an RSA certificate.
+func generateRSACertificate(hosts []string, notBefore, notAfter time.Time) ([]byte, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	return selfSign(hosts, notBefore, notAfter, key), pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// selfSign returns a PEM encoded certificate for the given hosts, valid in the given
+// period, signed by the given key.
+func selfSign(hosts []string, notBefore, notAfter time.Time, key crypto.Signer) []byte {
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"Knative"}},
+		DNSNames:     hosts,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestValidateCertificate(t *testing.T) {
+	now := time.Now()
+	validCert, validKey := generateCertificate([]string{"foo.example.com", "*.bar.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	_, otherKey := generateCertificate([]string{"foo.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	expiredCert, expiredKey := generateCertificate([]string{"foo.example.com"}, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	futureCert, futureKey := generateCertificate([]string{"foo.example.com"}, now.Add(time.Hour), now.Add(2*time.Hour))
+
+	tests := []struct {
+		name    string
+		cert    []byte
+		key     []byte
+		hosts   []string
+		wantErr string
+	}{{
+		name:  "valid",
+		cert:  validCert,
+		key:   validKey,
+		hosts: []string{"foo.example.com", "baz.bar.example.com", "*.bar.example.com"},
+	}, {
+		name:    "empty",
+		hosts:   []string{"foo.example.com"},
+		wantErr: "failed to find any PEM data",
+	}, {
+		name:    "mismatched key",
+		cert:    validCert,
+		key:     otherKey,
+		hosts:   []string{"foo.example.com"},
+		wantErr: "private key does not match public key",
+	}, {
+		name:    "expired",
+		cert:    expiredCert,
+		key:     expiredKey,
+		hosts:   []string{"foo.example.com"},
+		wantErr: "certificate expired",
+	}, {
+		name:    "not yet valid",
+		cert:    futureCert,
+		key:     futureKey,
+		hosts:   []string{"foo.example.com"},
+		wantErr: "certificate is not valid before",
+	}, {
+		name:    "host not covered",
+		cert:    validCert,
+		key:     validKey,
+		hosts:   []string{"foo.example.com", "baz.example.com"},
+		wantErr: "not baz.example.com",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateCertificate(test.cert, test.key, test.hosts, now)
+			if test.wantErr == "" {
+				assert.NilError(t, err)
+			} else {
+				assert.ErrorContains(t, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCABundle(t *testing.T) {
+	now := time.Now()
+	caCert, caKey := generateCertificate([]string{"ca.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	otherCert, _ := generateCertificate([]string{"other.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		bundle  []byte
+		wantErr string
+	}{{
+		name:   "single certificate",
+		bundle: caCert,
+	}, {
+		name:   "multiple certificates",
+		bundle: append(append([]byte{}, caCert...), otherCert...),
+	}, {
+		name:    "empty",
+		wantErr: "failed to find a certificate",
+	}, {
+		name:    "private key",
+		bundle:  append(append([]byte{}, caCert...), caKey...),
+		wantErr: "unexpected PEM block",
+	}, {
+		name:    "garbage",
+		bundle:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")}),
+		wantErr: "malformed certificate",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateCABundle(test.bundle)
+			if test.wantErr == "" {
+				assert.NilError(t, err)
+			} else {
+				assert.ErrorContains(t, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateKeyTypes(t *testing.T) {
+	now := time.Now()
+	ecdsaCert, ecdsaKey := generateCertificate([]string{"foo.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	otherECDSACert, otherECDSAKey := generateCertificate([]string{"foo.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	rsaCert, rsaKey := generateRSACertificate([]string{"foo.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	match := func(chain, key []byte, additional ...envoy.Certificate) *envoy.SNIMatch {
+		return &envoy.SNIMatch{
+			Hosts:                  []string{"foo.example.com"},
+			CertSource:             types.NamespacedName{Namespace: "secretns", Name: "first"},
+			CertificateChain:       chain,
+			PrivateKey:             key,
+			AdditionalCertificates: additional,
+		}
+	}
+
+	assert.NilError(t, validateKeyTypes(match(ecdsaCert, ecdsaKey)))
+	assert.NilError(t, validateKeyTypes(match(ecdsaCert, ecdsaKey, envoy.Certificate{
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "second"},
+		CertificateChain: rsaCert,
+		PrivateKey:       rsaKey,
+	})))
+	assert.ErrorContains(t, validateKeyTypes(match(ecdsaCert, ecdsaKey, envoy.Certificate{
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "second"},
+		CertificateChain: otherECDSACert,
+		PrivateKey:       otherECDSAKey,
+	})), "secrets secretns/first and secretns/second both hold ECDSA certificates")
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/net-kourier/pkg/config"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+)
+
+// caFieldInSecret is the key of the CA bundle in the secrets client certificates are
+// verified against.
+const caFieldInSecret = "ca.crt"
+
+// ErrInvalidAnnotation is returned for ingresses with a Kourier annotation that
+// can't be applied.
+var ErrInvalidAnnotation = errors.New("invalid annotation")
+
+// clientValidation returns how the certificates of clients of the external hosts of
+// the given ingress are verified, nil if the ingress doesn't require them.
+//
+// Errors the ingress is rejected for wrap ErrInvalidAnnotation or
+// ErrCertificateInvalid. The secret holding the CA bundle is tracked even if it
+// doesn't exist (yet), so the ingress is translated again once it does.
+func (translator *IngressTranslator) clientValidation(ingress *v1alpha1.Ingress, cfg *config.Kourier) (*envoy.ClientValidation, error) {
+	var caSecret types.NamespacedName
+	if name, ok := ingress.Annotations[config.ClientCASecretAnnotationKey]; ok {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("%w %s: %q is not the name of a secret in the ingress' namespace",
+				ErrInvalidAnnotation, config.ClientCASecretAnnotationKey, name)
+		}
+		caSecret = types.NamespacedName{Namespace: ingress.Namespace, Name: name}
+	} else if value, ok := ingress.Annotations[config.RequireClientCertificateAnnotationKey]; ok {
+		required, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %v", ErrInvalidAnnotation, config.RequireClientCertificateAnnotationKey, err)
+		}
+		if !required {
+			return nil, nil
+		}
+		if cfg.ClientCASecret == nil {
+			return nil, fmt.Errorf("%w %s: no client-ca-secret is configured in %s",
+				ErrInvalidAnnotation, config.RequireClientCertificateAnnotationKey, config.ConfigName)
+		}
+		caSecret = *cfg.ClientCASecret
+	} else {
+		return nil, nil
+	}
+
+	var sans []string
+	for _, san := range strings.Split(ingress.Annotations[config.ClientCertSANsAnnotationKey], ",") {
+		if san = strings.TrimSpace(san); san != "" {
+			sans = append(sans, san)
+		}
+	}
+
+	if err := trackSecret(translator.tracker, caSecret.Namespace, caSecret.Name, ingress); err != nil {
+		return nil, err
+	}
+	secret, err := translator.secretGetter(caSecret.Namespace, caSecret.Name)
+	if apierrors.IsNotFound(err) {
+		// Rather reject the ingress than serving it without verifying the clients.
+		return nil, fmt.Errorf("%w: CA bundle secret %s not found", ErrCertificateInvalid, caSecret)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch secret: %w", err)
+	}
+	if err := validateCABundle(secret.Data[caFieldInSecret]); err != nil {
+		return nil, fmt.Errorf("%w in CA bundle secret %s: %v", ErrCertificateInvalid, caSecret, err)
+	}
+
+	return &envoy.ClientValidation{
+		CASource:        caSecret,
+		CABundle:        secret.Data[caFieldInSecret],
+		SubjectAltNames: sans,
+	}, nil
+}
+
+// uncoveredExternalHosts returns the hosts of the external rules of the given
+// ingress that none of the given SNI matches covers, i.e. that would be served the
+// default certificate.
+func uncoveredExternalHosts(ingress *v1alpha1.Ingress, matches []*envoy.SNIMatch) []string {
+	covered := sets.NewString()
+	for _, match := range matches {
+		covered.Insert(match.Hosts...)
+	}
+
+	uncovered := sets.NewString()
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Visibility != v1alpha1.IngressVisibilityExternalIP {
+			continue
+		}
+		for _, host := range rule.Hosts {
+			if !covered.Has(host) {
+				uncovered.Insert(host)
+			}
+		}
+	}
+	return uncovered.List()
+}
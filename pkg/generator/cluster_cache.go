@@ -33,16 +33,24 @@ limitations under the License.
 // The best solution I have found is to include clusters in new configs even if
 // they are no longer referenced by the routes of the new config. This cache
 // keeps those old cluster for a small period of time.
+//
+// The load assignments of EDS clusters are kept next to the clusters, so they
+// share the same lifecycle and a cluster is never served without its endpoints.
+// The same goes for the secrets of the clusters originating TLS.
 
 package generator
 
 import (
+	"sort"
 	"strings"
 	"time"
 
 	v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	gocache "github.com/patrickmn/go-cache"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 const (
@@ -55,6 +63,14 @@ type ClustersCache struct {
 	clusters          *gocache.Cache
 }
 
+type cachedCluster struct {
+	cluster *v3.Cluster
+	// loadAssignment is nil for clusters that do not use EDS.
+	loadAssignment *loadAssignment
+	// secrets are the secrets referenced by the cluster, if it originates TLS.
+	secrets []*auth.Secret
+}
+
 func newClustersCache() *ClustersCache {
 	return newClustersCacheWithExpAndCleanupIntervals(defaultExpiration, defaultCleanupInterval)
 }
@@ -64,9 +80,9 @@ func newClustersCacheWithExpAndCleanupIntervals(expiration time.Duration, cleanu
 	return &ClustersCache{clusters: goCache, clusterExpiration: expiration}
 }
 
-func (cc *ClustersCache) set(cluster *v3.Cluster, ingressName string, ingressNamespace string) {
+func (cc *ClustersCache) set(cluster *v3.Cluster, loadAssignment *loadAssignment, secrets []*auth.Secret, ingressName string, ingressNamespace string) {
 	key := key(cluster.Name, ingressName, ingressNamespace)
-	cc.clusters.Set(key, cluster, gocache.NoExpiration)
+	cc.clusters.Set(key, &cachedCluster{cluster: cluster, loadAssignment: loadAssignment, secrets: secrets}, gocache.NoExpiration)
 }
 
 func (cc *ClustersCache) setExpiration(clusterName string, ingressName string, ingressNamespace string) {
@@ -76,15 +92,101 @@ func (cc *ClustersCache) setExpiration(clusterName string, ingressName string, i
 	}
 }
 
-func (cc *ClustersCache) list() []cachetypes.Resource {
+// updateLoadAssignments rebuilds the load assignments of all the EDS clusters with
+// the given name from the given endpoints. Returns true if any cluster was updated.
+func (cc *ClustersCache) updateLoadAssignments(clusterName string, endpoints *corev1.Endpoints) bool {
+	var updated bool
+	for _, item := range cc.clusters.Items() {
+		cached := item.Object.(*cachedCluster)
+		if cached.cluster.Name != clusterName || cached.loadAssignment == nil {
+			continue
+		}
+
+		cached.loadAssignment = newLoadAssignment(clusterName, endpoints, cached.loadAssignment.targetPort)
+		updated = true
+	}
+	return updated
+}
+
+// list returns all clusters, except the ones only set by the ingresses for which
+// exclude returns true. exclude may be nil.
+func (cc *ClustersCache) list(exclude func(types.NamespacedName) bool) []cachetypes.Resource {
+	res := make([]cachetypes.Resource, 0, cc.clusters.ItemCount())
+	for key, cluster := range cc.clusters.Items() {
+		if isExcluded(key, exclude) {
+			continue
+		}
+		res = append(res, cluster.Object.(*cachedCluster).cluster)
+	}
+
+	return res
+}
+
+// listLoadAssignments returns the load assignments of the clusters returned by list.
+func (cc *ClustersCache) listLoadAssignments(exclude func(types.NamespacedName) bool) []cachetypes.Resource {
 	res := make([]cachetypes.Resource, 0, cc.clusters.ItemCount())
-	for _, cluster := range cc.clusters.Items() {
-		res = append(res, cluster.Object.(cachetypes.Resource))
+	for key, cluster := range cc.clusters.Items() {
+		if isExcluded(key, exclude) {
+			continue
+		}
+		if loadAssignment := cluster.Object.(*cachedCluster).loadAssignment; loadAssignment != nil {
+			res = append(res, loadAssignment.assignment)
+		}
 	}
 
 	return res
 }
 
+// listSecrets returns the secrets referenced by the clusters returned by list, once
+// per name. The secrets of the clusters still in use take precedence over the ones
+// of the expiring clusters, which may be outdated.
+func (cc *ClustersCache) listSecrets(exclude func(types.NamespacedName) bool) []cachetypes.Resource {
+	items := cc.clusters.Items()
+	// Sorted, so the same secret is picked whatever the order of the items.
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	secrets := make(map[string]*auth.Secret)
+	expiring := make(map[string]bool)
+	for _, key := range keys {
+		if isExcluded(key, exclude) {
+			continue
+		}
+		item := items[key]
+		isExpiring := item.Expiration != 0
+		for _, secret := range item.Object.(*cachedCluster).secrets {
+			if _, ok := secrets[secret.Name]; ok && (isExpiring || !expiring[secret.Name]) {
+				continue
+			}
+			secrets[secret.Name] = secret
+			expiring[secret.Name] = isExpiring
+		}
+	}
+
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]cachetypes.Resource, 0, len(names))
+	for _, name := range names {
+		res = append(res, secrets[name])
+	}
+	return res
+}
+
+func isExcluded(key string, exclude func(types.NamespacedName) bool) bool {
+	if exclude == nil {
+		return false
+	}
+	_, name, namespace := explodeKey(key)
+	return exclude(types.NamespacedName{Namespace: namespace, Name: name})
+}
+
 // Using only the cluster name is not enough to ensure uniqueness, that's why we
 // use also the ingress info.
 func key(clusterName, ingressName, ingressNamespace string) string {
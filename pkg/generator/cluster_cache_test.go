@@ -22,9 +22,12 @@ import (
 	"time"
 
 	envoy_api_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"google.golang.org/protobuf/testing/protocmp"
 	"gotest.tools/v3/assert"
 	is "gotest.tools/v3/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
 )
 
 var testCluster1 = envoy_api_v3.Cluster{
@@ -37,9 +40,9 @@ var testCluster2 = envoy_api_v3.Cluster{
 
 func TestSetCluster(t *testing.T) {
 	cache := newClustersCache()
-	cache.set(&testCluster1, "some_ingress_name", "some_ingress_namespace")
+	cache.set(&testCluster1, nil, nil, "some_ingress_name", "some_ingress_namespace")
 
-	list := cache.list()
+	list := cache.list(nil)
 
 	assert.Assert(t, is.Len(list, 1))
 	assert.Equal(t, testCluster1.Name, list[0].(*envoy_api_v3.Cluster).Name)
@@ -47,10 +50,10 @@ func TestSetCluster(t *testing.T) {
 
 func TestSetSeveralClusters(t *testing.T) {
 	cache := newClustersCache()
-	cache.set(&testCluster1, "some_ingress_name", "some_ingress_namespace")
-	cache.set(&testCluster2, "some_ingress_name", "some_ingress_namespace")
+	cache.set(&testCluster1, nil, nil, "some_ingress_name", "some_ingress_namespace")
+	cache.set(&testCluster2, nil, nil, "some_ingress_name", "some_ingress_namespace")
 
-	list := cache.list()
+	list := cache.list(nil)
 	names := make([]string, 0, len(list))
 	for _, cluster := range list {
 		names = append(names, cluster.(*envoy_api_v3.Cluster).Name)
@@ -67,24 +70,44 @@ func TestSetSeveralClusters(t *testing.T) {
 func TestClustersExpire(t *testing.T) {
 	interval := 10 * time.Millisecond
 	cache := newClustersCacheWithExpAndCleanupIntervals(interval, interval)
-	cache.set(&testCluster1, "some_ingress_name", "some_ingress_namespace")
-	assert.Assert(t, is.Len(cache.list(), 1))
+	cache.set(&testCluster1, nil, nil, "some_ingress_name", "some_ingress_namespace")
+	assert.Assert(t, is.Len(cache.list(nil), 1))
 
 	// Wait for twice the interval and assert that the cluster is still there.
 	time.Sleep(2 * interval)
-	assert.Assert(t, is.Len(cache.list(), 1))
+	assert.Assert(t, is.Len(cache.list(nil), 1))
 
 	// Mark the cluster to be expired.
 	cache.setExpiration(testCluster1.Name, "some_ingress_name", "some_ingress_namespace")
 
 	// The cluster should eventually disappear.
 	wait.PollImmediate(interval, 5*time.Second, func() (bool, error) {
-		return len(cache.list()) == 0, nil
+		return len(cache.list(nil)) == 0, nil
 	})
-	assert.Assert(t, is.Len(cache.list(), 0))
+	assert.Assert(t, is.Len(cache.list(nil), 0))
 }
 
 func TestListWhenThereAreNoClusters(t *testing.T) {
 	cache := newClustersCache()
-	assert.Assert(t, is.Len(cache.list(), 0))
+	assert.Assert(t, is.Len(cache.list(nil), 0))
+}
+
+func TestUpdateLoadAssignments(t *testing.T) {
+	cache := newClustersCache()
+	cache.set(&testCluster1, nil, nil, "some_ingress_name", "some_ingress_namespace")
+
+	edsCluster := &envoy_api_v3.Cluster{Name: "servicens/servicename"}
+	cache.set(edsCluster, newLoadAssignment(edsCluster.Name, &corev1.Endpoints{}, 8080), nil, "some_ingress_name", "some_ingress_namespace")
+	cache.set(edsCluster, newLoadAssignment(edsCluster.Name, &corev1.Endpoints{}, 8080), nil, "other_ingress_name", "some_ingress_namespace")
+
+	// The cluster without load assignment is not listed.
+	assert.Assert(t, is.Len(cache.listLoadAssignments(nil), 2))
+
+	// No EDS cluster with that name.
+	assert.Assert(t, !cache.updateLoadAssignments(testCluster1.Name, eps("servicens", "servicename")))
+
+	assert.Assert(t, cache.updateLoadAssignments(edsCluster.Name, eps("servicens", "servicename")))
+	for _, res := range cache.listLoadAssignments(nil) {
+		assert.DeepEqual(t, res, envoy.NewClusterLoadAssignment(edsCluster.Name, lbEndpoints), protocmp.Transform())
+	}
 }
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+	"knative.dev/pkg/logging"
+)
+
+// clusterLocalSNIMatches returns the SNI matches of the cluster-local HTTPS listener
+// for the given ingress: the given matches of its TLS section restricted to the
+// hosts of its cluster-local rules, and a match serving the certificate of the given
+// secret, if any, for the remaining hosts of its cluster-local rules.
+func (translator *IngressTranslator) clusterLocalSNIMatches(ctx context.Context, ingress *v1alpha1.Ingress, tlsMatches []*envoy.SNIMatch, secretRef *types.NamespacedName) ([]*envoy.SNIMatch, []CertificateInfo, error) {
+	hosts := clusterLocalHosts(ingress)
+	if hosts.Len() == 0 {
+		return nil, nil, nil
+	}
+
+	var matches []*envoy.SNIMatch
+	for _, match := range tlsMatches {
+		var covered []string
+		for _, host := range match.Hosts {
+			if hosts.Has(host) {
+				covered = append(covered, host)
+				hosts.Delete(host)
+			}
+		}
+		if len(covered) == 0 {
+			continue
+		}
+
+		// Clients are not verified on the cluster-local listener.
+		copied := *match
+		copied.Hosts = covered
+		copied.ClientValidation = nil
+		matches = append(matches, &copied)
+	}
+
+	if secretRef == nil || hosts.Len() == 0 {
+		return matches, nil, nil
+	}
+
+	// Track the secret even if it doesn't exist (yet), so the ingress is translated
+	// again once it does.
+	if err := trackSecret(translator.tracker, secretRef.Namespace, secretRef.Name, ingress); err != nil {
+		return nil, nil, err
+	}
+	secret, err := translator.secretGetter(secretRef.Namespace, secretRef.Name)
+	if apierrors.IsNotFound(err) {
+		logging.FromContext(ctx).Warnf("Cluster-local certificate secret %s not found", secretRef)
+		return matches, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch secret: %w", err)
+	}
+
+	// The hosts are still served via plain HTTP, so rather leave them out than
+	// rejecting the ingress for an invalid certificate it didn't ask for.
+	certificateChain, privateKey := secret.Data[certFieldInSecret], secret.Data[keyFieldInSecret]
+	if err := validateCertificate(certificateChain, privateKey, nil, time.Now()); err != nil {
+		logging.FromContext(ctx).Warnw(fmt.Sprintf("Ignoring invalid cluster-local certificate in secret %s", secretRef), "error", err)
+		return matches, nil, nil
+	}
+
+	var certificates []CertificateInfo
+	if info, err := newCertificateInfo(*secretRef, certificateChain, hosts.List()); err == nil {
+		certificates = append(certificates, info)
+	}
+	matches = append(matches, &envoy.SNIMatch{
+		Hosts:            hosts.List(),
+		CertSource:       *secretRef,
+		CertificateChain: certificateChain,
+		PrivateKey:       privateKey,
+	})
+	return matches, certificates, nil
+}
+
+// clusterLocalHosts returns the hosts of the cluster-local rules of the given ingress.
+func clusterLocalHosts(ingress *v1alpha1.Ingress) sets.String {
+	hosts := sets.NewString()
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Visibility == v1alpha1.IngressVisibilityClusterLocal {
+			hosts.Insert(rule.Hosts...)
+		}
+	}
+	return hosts
+}
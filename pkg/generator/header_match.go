@@ -0,0 +1,176 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/net-kourier/pkg/config"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+)
+
+// headerNameRegexp matches the valid HTTP header names, i.e. tokens as per RFC 7230.
+var headerNameRegexp = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+
+// headerMatchers holds the header matchers of the header-matches annotation by the
+// path of the routes they apply to. The ones applying to all routes are keyed by "".
+type headerMatchers map[string][]*route.HeaderMatcher
+
+// forPath returns the header matchers applying to the routes of the given path.
+func (m headerMatchers) forPath(path string) []*route.HeaderMatcher {
+	matchers := make([]*route.HeaderMatcher, 0, len(m[""])+len(m[path]))
+	matchers = append(matchers, m[""]...)
+	return append(matchers, m[path]...)
+}
+
+// ambiguousPaths returns the paths of the given ingress that more than one path of
+// the same rule routes, e.g. the ones of the tags of a Knative service, which differ
+// only by their headers. Matchers for such a path would apply to all its routes,
+// leaving the requests without the matched headers unrouted.
+func ambiguousPaths(ingress *v1alpha1.Ingress) sets.String {
+	ambiguous := sets.NewString()
+	for _, rule := range ingress.Spec.Rules {
+		paths := sets.NewString()
+		for _, httpPath := range rule.HTTP.Paths {
+			path := routePath(httpPath)
+			if paths.Has(path) {
+				ambiguous.Insert(path)
+			}
+			paths.Insert(path)
+		}
+	}
+	return ambiguous
+}
+
+// annotatedHeaderMatchers returns the header matchers of the header-matches
+// annotation of the given ingress.
+func annotatedHeaderMatchers(ingress *v1alpha1.Ingress) (headerMatchers, error) {
+	annotation, ok := ingress.Annotations[config.HeaderMatchesAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	matchers, err := parseHeaderMatchers(annotation, ingressPaths(ingress), ambiguousPaths(ingress))
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidAnnotation, config.HeaderMatchesAnnotationKey, err)
+	}
+	return matchers, nil
+}
+
+// parseHeaderMatchers parses the value of the header-matches annotation. Every line
+// holds a matcher as
+//
+//	[path] header [!]type [value]
+//
+// where type is exact, prefix, suffix, contains, regex or present, and ! inverts
+// the match. Matchers without a path apply to all paths, the others to the paths
+// of the ingress equal to theirs, which must be among the given ones and not
+// among the ambiguous ones.
+func parseHeaderMatchers(annotation string, paths, ambiguous sets.String) (headerMatchers, error) {
+	matchers := make(headerMatchers)
+	for i, line := range strings.Split(annotation, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var path string
+		if strings.HasPrefix(line, "/") {
+			path, line = cutField(line)
+			if !paths.Has(path) {
+				return nil, fmt.Errorf("line %d: the ingress has no path %s", i+1, path)
+			}
+			if ambiguous.Has(path) {
+				return nil, fmt.Errorf("line %d: the ingress has several routes for path %s", i+1, path)
+			}
+		}
+		header, line := cutField(line)
+		matchType, value := cutField(line)
+
+		headerMatcher, err := newHeaderMatcher(header, matchType, value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		matchers[path] = append(matchers[path], headerMatcher)
+	}
+	return matchers, nil
+}
+
+// newHeaderMatcher returns the matcher of the given header, type and value.
+func newHeaderMatcher(header, matchType, value string) (*route.HeaderMatcher, error) {
+	if !headerNameRegexp.MatchString(header) {
+		return nil, fmt.Errorf("invalid header name %q", header)
+	}
+
+	headerMatcher := &route.HeaderMatcher{
+		Name:        header,
+		InvertMatch: strings.HasPrefix(matchType, "!"),
+	}
+	matchType = strings.TrimPrefix(matchType, "!")
+
+	if matchType == "present" {
+		if value != "" {
+			return nil, errors.New("present matches take no value")
+		}
+		headerMatcher.HeaderMatchSpecifier = &route.HeaderMatcher_PresentMatch{PresentMatch: true}
+		return headerMatcher, nil
+	}
+	if value == "" {
+		return nil, fmt.Errorf("%s matches require a value", matchType)
+	}
+
+	switch matchType {
+	case "exact":
+		headerMatcher.HeaderMatchSpecifier = &route.HeaderMatcher_ExactMatch{ExactMatch: value}
+	case "prefix":
+		headerMatcher.HeaderMatchSpecifier = &route.HeaderMatcher_PrefixMatch{PrefixMatch: value}
+	case "suffix":
+		headerMatcher.HeaderMatchSpecifier = &route.HeaderMatcher_SuffixMatch{SuffixMatch: value}
+	case "contains":
+		headerMatcher.HeaderMatchSpecifier = &route.HeaderMatcher_ContainsMatch{ContainsMatch: value}
+	case "regex":
+		// Go's regular expressions share the RE2 syntax of Envoy's safe regexes.
+		if _, err := regexp.Compile(value); err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		headerMatcher.HeaderMatchSpecifier = &route.HeaderMatcher_SafeRegexMatch{
+			SafeRegexMatch: &matcher.RegexMatcher{
+				EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+				Regex:      value,
+			},
+		}
+	default:
+		return nil, fmt.Errorf("unsupported match type %q", matchType)
+	}
+	return headerMatcher, nil
+}
+
+// cutField returns the first whitespace separated field of s and the rest of s,
+// both trimmed.
+func cutField(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
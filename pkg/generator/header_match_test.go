@@ -0,0 +1,138 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"testing"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/testing/protocmp"
+	"gotest.tools/v3/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestParseHeaderMatchers(t *testing.T) {
+	paths := sets.NewString("/", "/api", "/tagged")
+	ambiguous := sets.NewString("/tagged")
+
+	tests := []struct {
+		name       string
+		annotation string
+		want       headerMatchers
+		wantErr    bool
+	}{{
+		name: "empty",
+		want: headerMatchers{},
+	}, {
+		name: "all match types",
+		annotation: `
+			x-exact exact foo
+			x-prefix prefix beta-
+			x-suffix suffix -beta
+			x-contains contains beta
+			x-regex regex ^v[0-9]+ (beta|rc)$
+			x-present present
+		`,
+		want: headerMatchers{"": {{
+			Name:                 "x-exact",
+			HeaderMatchSpecifier: &route.HeaderMatcher_ExactMatch{ExactMatch: "foo"},
+		}, {
+			Name:                 "x-prefix",
+			HeaderMatchSpecifier: &route.HeaderMatcher_PrefixMatch{PrefixMatch: "beta-"},
+		}, {
+			Name:                 "x-suffix",
+			HeaderMatchSpecifier: &route.HeaderMatcher_SuffixMatch{SuffixMatch: "-beta"},
+		}, {
+			Name:                 "x-contains",
+			HeaderMatchSpecifier: &route.HeaderMatcher_ContainsMatch{ContainsMatch: "beta"},
+		}, {
+			Name: "x-regex",
+			HeaderMatchSpecifier: &route.HeaderMatcher_SafeRegexMatch{
+				SafeRegexMatch: &matcher.RegexMatcher{
+					EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+					Regex:      "^v[0-9]+ (beta|rc)$",
+				},
+			},
+		}, {
+			Name:                 "x-present",
+			HeaderMatchSpecifier: &route.HeaderMatcher_PresentMatch{PresentMatch: true},
+		}}},
+	}, {
+		name:       "inverted match on a path",
+		annotation: "/api x-env !exact prod",
+		want: headerMatchers{"/api": {{
+			Name:                 "x-env",
+			HeaderMatchSpecifier: &route.HeaderMatcher_ExactMatch{ExactMatch: "prod"},
+			InvertMatch:          true,
+		}}},
+	}, {
+		name:       "unknown path",
+		annotation: "/apiv2 x-env exact prod",
+		wantErr:    true,
+	}, {
+		name:       "ambiguous path",
+		annotation: "/tagged x-env exact prod",
+		wantErr:    true,
+	}, {
+		name:       "invalid header name",
+		annotation: "x:env exact prod",
+		wantErr:    true,
+	}, {
+		name:       "unsupported match type",
+		annotation: "x-env like prod",
+		wantErr:    true,
+	}, {
+		name:       "missing value",
+		annotation: "x-env prefix",
+		wantErr:    true,
+	}, {
+		name:       "present match with value",
+		annotation: "x-env present prod",
+		wantErr:    true,
+	}, {
+		name:       "invalid regular expression",
+		annotation: "x-env regex (prod",
+		wantErr:    true,
+	}, {
+		name:       "regular expression unsupported by RE2",
+		annotation: "x-env regex ^(?!prod)",
+		wantErr:    true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseHeaderMatchers(test.annotation, paths, ambiguous)
+			if test.wantErr {
+				assert.Assert(t, err != nil, "got matchers %v", got)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got, test.want, protocmp.Transform())
+		})
+	}
+}
+
+func TestHeaderMatchersForPath(t *testing.T) {
+	all := &route.HeaderMatcher{Name: "all"}
+	api := &route.HeaderMatcher{Name: "api"}
+	matchers := headerMatchers{"": {all}, "/api": {api}}
+
+	assert.DeepEqual(t, matchers.forPath("/api"), []*route.HeaderMatcher{all, api}, protocmp.Transform())
+	assert.DeepEqual(t, matchers.forPath("/"), []*route.HeaderMatcher{all}, protocmp.Transform())
+	assert.Equal(t, len(headerMatchers(nil).forPath("/")), 0)
+}
@@ -18,30 +18,71 @@ package generator
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"sort"
+	"strings"
 	"time"
 
 	v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	envoy "knative.dev/net-kourier/pkg/envoy/api"
+	rconfig "knative.dev/net-kourier/pkg/reconciler/ingress/config"
 	"knative.dev/networking/pkg/apis/networking/v1alpha1"
 	"knative.dev/pkg/kmeta"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/network"
 	"knative.dev/pkg/tracker"
 )
 
 type translatedIngress struct {
-	name                    types.NamespacedName
+	name types.NamespacedName
+	// generation is the generation of the ingress that was translated.
+	generation              int64
 	sniMatches              []*envoy.SNIMatch
 	clusters                []*v3.Cluster
+	loadAssignments         map[string]*loadAssignment
 	externalVirtualHosts    []*route.VirtualHost
 	externalTLSVirtualHosts []*route.VirtualHost
 	internalVirtualHosts    []*route.VirtualHost
+	// localSNIMatches are the SNI matches of the cluster-local HTTPS listener.
+	localSNIMatches []*envoy.SNIMatch
+	// upstreamSecrets are the secrets referenced by the clusters originating TLS, by
+	// cluster name.
+	upstreamSecrets map[string][]*auth.Secret
+	// certificates describes the certificates of the TLS secrets, where they could be
+	// parsed.
+	certificates []CertificateInfo
+	// certificateErr is set if the CA bundle client certificates are verified
+	// against or the certificates to originate TLS to the services aren't valid.
+	certificateErr error
+	// tlsCertificateErr is set if any of the TLS secrets doesn't hold a valid
+	// certificate for the hosts it's used for. Unlike for the other errors, the
+	// ingress is still served, only the hosts without a valid certificate are left
+	// out of the HTTPS listeners.
+	tlsCertificateErr error
+	// annotationErr is set if any of the Kourier annotations of the ingress can't be
+	// applied.
+	annotationErr error
+
+	// validated is set once the resources above were validated, with the result in
+	// validationErr.
+	validated     bool
+	validationErr error
+}
+
+// allSNIMatches returns the SNI matches of both the external and the cluster-local
+// HTTPS listener.
+func (translated *translatedIngress) allSNIMatches() []*envoy.SNIMatch {
+	matches := make([]*envoy.SNIMatch, 0, len(translated.sniMatches)+len(translated.localSNIMatches))
+	matches = append(matches, translated.sniMatches...)
+	return append(matches, translated.localSNIMatches...)
 }
 
 type IngressTranslator struct {
@@ -66,8 +107,65 @@ func NewIngressTranslator(
 
 func (translator *IngressTranslator) translateIngress(ctx context.Context, ingress *v1alpha1.Ingress, extAuthzEnabled bool) (*translatedIngress, error) {
 	logger := logging.FromContext(ctx)
+	cfg := rconfig.FromContextOrDefaults(ctx)
+	networkCfg := cfg.NetworkOrDefaults()
 
 	sniMatches := make([]*envoy.SNIMatch, 0, len(ingress.Spec.TLS))
+	var certificates []CertificateInfo
+	var certificateErr, tlsCertificateErr, annotationErr error
+	// invalidTLSHosts are the hosts of the TLS section without a valid certificate.
+	invalidTLSHosts := sets.NewString()
+
+	clientValidation, err := translator.clientValidation(ingress, cfg.Kourier)
+	if errors.Is(err, ErrInvalidAnnotation) {
+		annotationErr = err
+	} else if errors.Is(err, ErrCertificateInvalid) {
+		certificateErr = err
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The timeouts, the retry policy and the CORS policy of config-kourier can be
+	// overridden per ingress.
+	var timeouts envoy.RouteTimeouts
+	if timeout, err := cfg.Kourier.Timeout.WithAnnotations(ingress.Annotations); err != nil {
+		if annotationErr == nil {
+			annotationErr = fmt.Errorf("%w: %v", ErrInvalidAnnotation, err)
+		}
+	} else {
+		timeouts = envoy.RouteTimeouts{
+			Request:              timeout.Request,
+			Idle:                 timeout.Idle,
+			MaxStreamDuration:    timeout.MaxStreamDuration,
+			GrpcTimeoutHeaderMax: timeout.GrpcTimeoutHeaderMax,
+		}
+	}
+	var retryPolicy *route.RetryPolicy
+	if retry, err := cfg.Kourier.Retry.WithAnnotations(ingress.Annotations); err != nil {
+		if annotationErr == nil {
+			annotationErr = fmt.Errorf("%w: %v", ErrInvalidAnnotation, err)
+		}
+	} else {
+		retryPolicy = retry.Policy()
+	}
+	var corsPolicy *route.CorsPolicy
+	if cors, err := cfg.Kourier.CORS.WithAnnotations(ingress.Annotations); err != nil {
+		if annotationErr == nil {
+			annotationErr = fmt.Errorf("%w: %v", ErrInvalidAnnotation, err)
+		}
+	} else {
+		corsPolicy = cors.Policy()
+	}
+
+	annotatedMatchers, err := annotatedHeaderMatchers(ingress)
+	if err != nil && annotationErr == nil {
+		annotationErr = err
+	}
+	pathTypes, err := annotatedPathMatchTypes(ingress)
+	if err != nil && annotationErr == nil {
+		annotationErr = err
+	}
+
 	for _, ingressTLS := range ingress.Spec.TLS {
 		if err := trackSecret(translator.tracker, ingressTLS.SecretNamespace, ingressTLS.SecretName, ingress); err != nil {
 			return nil, err
@@ -82,6 +180,18 @@ func (translator *IngressTranslator) translateIngress(ctx context.Context, ingre
 			Namespace: ingressTLS.SecretNamespace,
 			Name:      ingressTLS.SecretName,
 		}
+		if info, err := newCertificateInfo(secretRef, secret.Data[certFieldInSecret], ingressTLS.Hosts); err == nil {
+			certificates = append(certificates, info)
+		}
+		// A single invalid certificate would cause the gateways to reject the whole
+		// HTTPS listener, so it's left out instead.
+		if err := validateCertificate(secret.Data[certFieldInSecret], secret.Data[keyFieldInSecret], ingressTLS.Hosts, time.Now()); err != nil {
+			if tlsCertificateErr == nil {
+				tlsCertificateErr = fmt.Errorf("%w in secret %s: %v", ErrCertificateInvalid, secretRef, err)
+			}
+			invalidTLSHosts.Insert(ingressTLS.Hosts...)
+			continue
+		}
 		sniMatches = append(sniMatches, &envoy.SNIMatch{
 			Hosts:            ingressTLS.Hosts,
 			CertSource:       secretRef,
@@ -89,24 +199,80 @@ func (translator *IngressTranslator) translateIngress(ctx context.Context, ingre
 			PrivateKey:       secret.Data[keyFieldInSecret]})
 	}
 
+	// Hosts can be part of multiple TLS entries, e.g. to serve both an RSA and an ECDSA
+	// certificate.
+	validMatches := make([]*envoy.SNIMatch, 0, len(sniMatches))
+	for _, match := range mergeSNIMatches(sniMatches) {
+		if err := validateKeyTypes(match); err != nil {
+			if tlsCertificateErr == nil {
+				tlsCertificateErr = fmt.Errorf("%w: %v", ErrCertificateInvalid, err)
+			}
+			invalidTLSHosts.Insert(match.Hosts...)
+			continue
+		}
+		validMatches = append(validMatches, match)
+	}
+	sniMatches = validMatches
+	// Hosts with another, valid certificate are still served that one.
+	for _, match := range sniMatches {
+		invalidTLSHosts.Delete(match.Hosts...)
+	}
+
+	localSNIMatches, localCertificates, err := translator.clusterLocalSNIMatches(ctx, ingress, sniMatches, cfg.Kourier.ClusterLocalCertsSecret)
+	if err != nil {
+		return nil, err
+	}
+	certificates = append(certificates, localCertificates...)
+
+	wildcardMatches, wildcardCertificates, err := translator.wildcardSNIMatches(ctx, ingress, cfg.Kourier.WildcardCertsSecrets)
+	if err != nil {
+		return nil, err
+	}
+	sniMatches = append(sniMatches, wildcardMatches...)
+	certificates = append(certificates, wildcardCertificates...)
+
+	if clientValidation != nil {
+		// The default certificate's filter chain doesn't verify clients, so all external
+		// hosts need a certificate of their own. Hosts with an invalid one aren't served
+		// via HTTPS at all.
+		uncovered := sets.NewString(uncoveredExternalHosts(ingress, sniMatches)...).Difference(invalidTLSHosts)
+		if uncovered.Len() != 0 && annotationErr == nil {
+			annotationErr = fmt.Errorf("%w: client certificates can't be required for hosts without a certificate of their own: %s",
+				ErrInvalidAnnotation, strings.Join(uncovered.List(), ", "))
+		}
+		for _, match := range sniMatches {
+			match.ClientValidation = clientValidation
+		}
+	}
+
+	tlsHosts := sets.NewString()
+	for _, ingressTLS := range ingress.Spec.TLS {
+		tlsHosts.Insert(ingressTLS.Hosts...)
+	}
+	tlsHosts.Delete(invalidTLSHosts.List()...)
+
 	internalHosts := make([]*route.VirtualHost, 0, len(ingress.Spec.Rules))
 	externalHosts := make([]*route.VirtualHost, 0, len(ingress.Spec.Rules))
 	externalTLSHosts := make([]*route.VirtualHost, 0, len(ingress.Spec.Rules))
 	clusters := make([]*v3.Cluster, 0, len(ingress.Spec.Rules))
+	loadAssignments := make(map[string]*loadAssignment, len(ingress.Spec.Rules))
+	var upstreamSecrets map[string][]*auth.Secret
+	// The upstream certificates are only fetched once any cluster originates TLS, and
+	// only once per ingress, even if they're invalid.
+	var upstreamTLS *envoy.UpstreamTLS
+	var upstreamTLSFetched bool
 
 	for i, rule := range ingress.Spec.Rules {
 		ruleName := fmt.Sprintf("(%s/%s).Rules[%d]", ingress.Namespace, ingress.Name, i)
 
 		routes := make([]*route.Route, 0, len(rule.HTTP.Paths))
 		tlsRoutes := make([]*route.Route, 0, len(rule.HTTP.Paths))
-		for _, httpPath := range rule.HTTP.Paths {
-			// Default the path to "/" if none is passed.
-			path := httpPath.Path
-			if path == "" {
-				path = "/"
-			}
+		for _, httpPath := range sortPaths(rule.HTTP.Paths, pathTypes, annotatedMatchers) {
+			path := routePath(httpPath)
+			pathMatch := envoy.PathMatch{Path: path, Type: pathTypes.forPath(path)}
 
 			pathName := fmt.Sprintf("%s.Paths[%s]", ruleName, path)
+			headersMatch := append(matchHeadersFromHTTPPath(httpPath), annotatedMatchers.forPath(path)...)
 
 			wrs := make([]*route.WeightedCluster_ClusterWeight, 0, len(httpPath.Splits))
 			for _, split := range httpPath.Splits {
@@ -132,26 +298,27 @@ func (translator *IngressTranslator) translateIngress(ctx context.Context, ingre
 				var (
 					externalPort int32
 					targetPort   int32
+					portName     string
 					http2        bool
 				)
 				for _, port := range service.Spec.Ports {
 					if port.Port == split.ServicePort.IntVal || port.Name == split.ServicePort.StrVal {
 						externalPort = port.Port
 						targetPort = port.TargetPort.IntVal
+						portName = port.Name
 						http2 = port.Name == "http2" || port.Name == "h2c"
 					}
 				}
 
-				var (
-					publicLbEndpoints []*endpoint.LbEndpoint
-					typ               v3.Cluster_DiscoveryType
-				)
+				connectTimeout := 5 * time.Second
+				var cluster *v3.Cluster
+				var sni string
 				if service.Spec.Type == corev1.ServiceTypeExternalName {
 					// If the service is of type ExternalName, we add a single endpoint.
-					typ = v3.Cluster_LOGICAL_DNS
-					publicLbEndpoints = []*endpoint.LbEndpoint{
+					cluster = envoy.NewCluster(splitName, connectTimeout, []*endpoint.LbEndpoint{
 						envoy.NewLBEndpoint(service.Spec.ExternalName, uint32(externalPort)),
-					}
+					}, http2, v3.Cluster_LOGICAL_DNS)
+					sni = service.Spec.ExternalName
 				} else {
 					// For all other types, fetch the endpoints object.
 					endpoints, err := translator.endpointsGetter(split.ServiceNamespace, split.ServiceName)
@@ -163,12 +330,38 @@ func (translator *IngressTranslator) translateIngress(ctx context.Context, ingre
 						return nil, fmt.Errorf("failed to fetch endpoints '%s/%s': %w", split.ServiceNamespace, split.ServiceName, err)
 					}
 
-					typ = v3.Cluster_STATIC
-					publicLbEndpoints = lbEndpointsForKubeEndpoints(endpoints, targetPort)
+					// The endpoints are served via EDS, so changes to them don't require
+					// the cluster to change.
+					cluster = envoy.NewEDSCluster(splitName, connectTimeout, http2)
+					loadAssignments[splitName] = newLoadAssignment(splitName, endpoints, targetPort)
+					sni = network.GetServiceHostname(split.ServiceName, split.ServiceNamespace)
 				}
 
-				connectTimeout := 5 * time.Second
-				cluster := envoy.NewCluster(splitName, connectTimeout, publicLbEndpoints, http2, typ)
+				// Internal encryption only covers the Knative services, not the ExternalName
+				// services of e.g. domain mappings.
+				internalEncryption := networkCfg.InternalEncryption && service.Spec.Type != corev1.ServiceTypeExternalName
+				if cfg.Kourier.EnableUpstreamTLS || internalEncryption || portName == upstreamTLSPortName {
+					if !upstreamTLSFetched {
+						upstreamTLSFetched = true
+						upstreamTLS, err = translator.upstreamTLS(ingress, cfg.Kourier, networkCfg)
+						if errors.Is(err, ErrCertificateInvalid) {
+							if certificateErr == nil {
+								certificateErr = err
+							}
+						} else if err != nil {
+							return nil, err
+						}
+					}
+					if upstreamTLS != nil {
+						if err := envoy.SetUpstreamTLS(cluster, upstreamTLS, sni, http2); err != nil {
+							return nil, err
+						}
+						if upstreamSecrets == nil {
+							upstreamSecrets = make(map[string][]*auth.Secret)
+						}
+						upstreamSecrets[splitName] = upstreamTLS.Secrets()
+					}
+				}
 				clusters = append(clusters, cluster)
 
 				weightedCluster := envoy.NewWeightedCluster(splitName, uint32(split.Percent), split.AppendHeaders)
@@ -176,18 +369,21 @@ func (translator *IngressTranslator) translateIngress(ctx context.Context, ingre
 			}
 
 			if len(wrs) != 0 {
-				// Do not create redirect route when KOURIER_HTTPOPTION_DISABLED is set. This option is useful when front end proxy handles the redirection.
-				// e.g. Kourier on OpenShift handles HTTPOption by OpenShift Route so KOURIER_HTTPOPTION_DISABLED should be set.
-				if _, ok := os.LookupEnv("KOURIER_HTTPOPTION_DISABLED"); !ok && ingress.Spec.HTTPOption == v1alpha1.HTTPOptionRedirected && rule.Visibility == v1alpha1.IngressVisibilityExternalIP {
+				// Do not create redirect route when disable-http-option is set. This option is useful when front end proxy handles the redirection.
+				// e.g. Kourier on OpenShift handles HTTPOption by OpenShift Route so disable-http-option should be set.
+				// Plain HTTP requests are always redirected if client certificates are required though, as they'd bypass the verification.
+				// The http-protocol of config-network is not applied here, Knative Serving already sets the HTTPOption of the ingresses according to it.
+				redirect := clientValidation != nil || (!cfg.Kourier.DisableHTTPOption && ingress.Spec.HTTPOption == v1alpha1.HTTPOptionRedirected)
+				if redirect && rule.Visibility == v1alpha1.IngressVisibilityExternalIP {
 					routes = append(routes, envoy.NewRedirectRoute(
-						pathName, matchHeadersFromHTTPPath(httpPath), path))
+						pathName, headersMatch, pathMatch))
 				} else {
 					routes = append(routes, envoy.NewRoute(
-						pathName, matchHeadersFromHTTPPath(httpPath), path, wrs, 0, httpPath.AppendHeaders, httpPath.RewriteHost))
+						pathName, headersMatch, pathMatch, wrs, timeouts, httpPath.AppendHeaders, httpPath.RewriteHost, retryPolicy))
 				}
-				if len(ingress.Spec.TLS) != 0 || useHTTPSListenerWithOneCert() {
+				if len(sniMatches) != 0 || cfg.Kourier.HasCertsSecret() {
 					tlsRoutes = append(tlsRoutes, envoy.NewRoute(
-						pathName, matchHeadersFromHTTPPath(httpPath), path, wrs, 0, httpPath.AppendHeaders, httpPath.RewriteHost))
+						pathName, headersMatch, pathMatch, wrs, timeouts, httpPath.AppendHeaders, httpPath.RewriteHost, retryPolicy))
 				}
 			}
 		}
@@ -197,6 +393,19 @@ func (translator *IngressTranslator) translateIngress(ctx context.Context, ingre
 			return nil, nil
 		}
 
+		// Hosts without a valid certificate are left out of the HTTPS listener, rather
+		// than being served the default certificate.
+		tlsRule := rule
+		tlsRule.Hosts = make([]string, 0, len(rule.Hosts))
+		for _, host := range rule.Hosts {
+			if !invalidTLSHosts.Has(host) {
+				tlsRule.Hosts = append(tlsRule.Hosts, host)
+			}
+		}
+		if len(tlsRule.Hosts) == 0 {
+			tlsRoutes = nil
+		}
+
 		var virtualHost, virtualTLSHost *route.VirtualHost
 		if extAuthzEnabled {
 			contextExtensions := kmeta.UnionMaps(map[string]string{
@@ -205,14 +414,25 @@ func (translator *IngressTranslator) translateIngress(ctx context.Context, ingre
 			}, ingress.GetLabels())
 			virtualHost = envoy.NewVirtualHostWithExtAuthz(ruleName, contextExtensions, domainsForRule(rule), routes)
 			if len(tlsRoutes) != 0 {
-				virtualTLSHost = envoy.NewVirtualHostWithExtAuthz(ruleName, contextExtensions, domainsForRule(rule), tlsRoutes)
+				virtualTLSHost = envoy.NewVirtualHostWithExtAuthz(ruleName, contextExtensions, domainsForRule(tlsRule), tlsRoutes)
 			}
 		} else {
 			virtualHost = envoy.NewVirtualHost(ruleName, domainsForRule(rule), routes)
 			if len(tlsRoutes) != 0 {
-				virtualTLSHost = envoy.NewVirtualHost(ruleName, domainsForRule(rule), tlsRoutes)
+				virtualTLSHost = envoy.NewVirtualHost(ruleName, domainsForRule(tlsRule), tlsRoutes)
 			}
 		}
+		virtualHost.Cors = corsPolicy
+		if virtualTLSHost != nil {
+			virtualTLSHost.Cors = corsPolicy
+		}
+
+		// The cluster-local listeners share their routes, so plain HTTP requests are
+		// redirected by requiring TLS rather than by redirect routes. Only hosts with
+		// a certificate of their own are redirected, as they're probed via HTTPS.
+		if networkCfg.ClusterLocalDomainTLS && rule.Visibility == v1alpha1.IngressVisibilityClusterLocal && tlsHosts.HasAll(rule.Hosts...) {
+			virtualHost.RequireTls = route.VirtualHost_ALL
+		}
 
 		internalHosts = append(internalHosts, virtualHost)
 		if rule.Visibility == v1alpha1.IngressVisibilityExternalIP {
@@ -228,11 +448,19 @@ func (translator *IngressTranslator) translateIngress(ctx context.Context, ingre
 			Namespace: ingress.Namespace,
 			Name:      ingress.Name,
 		},
+		generation:              ingress.Generation,
 		sniMatches:              sniMatches,
+		localSNIMatches:         localSNIMatches,
 		clusters:                clusters,
+		loadAssignments:         loadAssignments,
+		upstreamSecrets:         upstreamSecrets,
 		externalVirtualHosts:    externalHosts,
 		externalTLSVirtualHosts: externalTLSHosts,
 		internalVirtualHosts:    internalHosts,
+		certificates:            certificates,
+		certificateErr:          certificateErr,
+		tlsCertificateErr:       tlsCertificateErr,
+		annotationErr:           annotationErr,
 	}, nil
 }
 
@@ -266,6 +494,21 @@ func trackService(t tracker.Interface, svcNs, svcName string, ingress *v1alpha1.
 	return nil
 }
 
+// loadAssignment is the ClusterLoadAssignment served via EDS for a cluster. It keeps
+// the port the endpoints are targeted at, so it can be rebuilt when the Endpoints
+// change without translating the ingress again.
+type loadAssignment struct {
+	assignment *endpoint.ClusterLoadAssignment
+	targetPort int32
+}
+
+func newLoadAssignment(clusterName string, kubeEndpoints *corev1.Endpoints, targetPort int32) *loadAssignment {
+	return &loadAssignment{
+		assignment: envoy.NewClusterLoadAssignment(clusterName, lbEndpointsForKubeEndpoints(kubeEndpoints, targetPort)),
+		targetPort: targetPort,
+	}
+}
+
 func lbEndpointsForKubeEndpoints(kubeEndpoints *corev1.Endpoints, targetPort int32) []*endpoint.LbEndpoint {
 	var readyAddressCount int
 	for _, subset := range kubeEndpoints.Subsets {
@@ -297,9 +540,20 @@ func matchHeadersFromHTTPPath(httpPath v1alpha1.HTTPIngressPath) []*route.Header
 			matchHeader.HeaderMatchSpecifier = &route.HeaderMatcher_ExactMatch{
 				ExactMatch: matchType.Exact,
 			}
+		} else {
+			// Envoy matches the presence of the header without a specifier, but rather
+			// say so explicitly.
+			matchHeader.HeaderMatchSpecifier = &route.HeaderMatcher_PresentMatch{
+				PresentMatch: true,
+			}
 		}
 		matchHeaders = append(matchHeaders, matchHeader)
 	}
+
+	// Headers is a map, sort the matchers to generate the same route every time.
+	sort.Slice(matchHeaders, func(i, j int) bool {
+		return matchHeaders[i].Name < matchHeaders[j].Name
+	})
 	return matchHeaders
 }
 
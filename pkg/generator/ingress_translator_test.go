@@ -17,14 +17,20 @@ limitations under the License.
 package generator
 
 import (
+	"errors"
 	"testing"
 	"time"
 
 	v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"gotest.tools/v3/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -32,9 +38,14 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes/fake"
+	"knative.dev/net-kourier/pkg/config"
 	envoy "knative.dev/net-kourier/pkg/envoy/api"
+	rconfig "knative.dev/net-kourier/pkg/reconciler/ingress/config"
+	networking "knative.dev/networking/pkg"
 	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+	"knative.dev/pkg/network"
 	pkgtest "knative.dev/pkg/reconciler/testing"
+	"knative.dev/pkg/system"
 )
 
 func TestIngressTranslator(t *testing.T) {
@@ -63,13 +74,14 @@ func TestIngressTranslator(t *testing.T) {
 								ExactMatch: "foo",
 							},
 						}},
-						"/test",
+						envoy.PathMatch{Path: "/test"},
 						[]*route.WeightedCluster_ClusterWeight{
 							envoy.NewWeightedCluster("servicens/servicename", 100, map[string]string{"baz": "gna"}),
 						},
-						0,
+						envoy.RouteTimeouts{},
 						map[string]string{"foo": "bar"},
-						"rewritten.example.com"),
+						"rewritten.example.com",
+						nil),
 					},
 				),
 			}
@@ -81,14 +93,9 @@ func TestIngressTranslator(t *testing.T) {
 				},
 				sniMatches: []*envoy.SNIMatch{},
 				clusters: []*v3.Cluster{
-					envoy.NewCluster(
-						"servicens/servicename",
-						5*time.Second,
-						lbEndpoints,
-						false,
-						v3.Cluster_STATIC,
-					),
+					envoy.NewEDSCluster("servicens/servicename", 5*time.Second, false),
 				},
+				loadAssignments:         testLoadAssignments("servicens/servicename"),
 				externalVirtualHosts:    vHosts,
 				externalTLSVirtualHosts: []*route.VirtualHost{},
 				internalVirtualHosts:    vHosts,
@@ -121,13 +128,14 @@ func TestIngressTranslator(t *testing.T) {
 								ExactMatch: "foo",
 							},
 						}},
-						"/test",
+						envoy.PathMatch{Path: "/test"},
 						[]*route.WeightedCluster_ClusterWeight{
 							envoy.NewWeightedCluster("servicens/servicename", 100, map[string]string{"baz": "gna"}),
 						},
-						0,
+						envoy.RouteTimeouts{},
 						map[string]string{"foo": "bar"},
-						"rewritten.example.com"),
+						"rewritten.example.com",
+						nil),
 					},
 				),
 			}
@@ -146,15 +154,16 @@ func TestIngressTranslator(t *testing.T) {
 					CertificateChain: cert,
 					PrivateKey:       privateKey,
 				}},
+				certificates: []CertificateInfo{{
+					Secret:    types.NamespacedName{Namespace: "secretns", Name: "secretname"},
+					NotBefore: certNotBefore,
+					NotAfter:  certNotAfter,
+					Hosts:     []string{"foo.example.com"},
+				}},
 				clusters: []*v3.Cluster{
-					envoy.NewCluster(
-						"servicens/servicename",
-						5*time.Second,
-						lbEndpoints,
-						false,
-						v3.Cluster_STATIC,
-					),
+					envoy.NewEDSCluster("servicens/servicename", 5*time.Second, false),
 				},
+				loadAssignments:         testLoadAssignments("servicens/servicename"),
 				externalVirtualHosts:    vHosts,
 				externalTLSVirtualHosts: vHosts,
 				internalVirtualHosts:    vHosts,
@@ -188,13 +197,14 @@ func TestIngressTranslator(t *testing.T) {
 								ExactMatch: "foo",
 							},
 						}},
-						"/test",
+						envoy.PathMatch{Path: "/test"},
 						[]*route.WeightedCluster_ClusterWeight{
 							envoy.NewWeightedCluster("servicens/servicename", 100, map[string]string{"baz": "gna"}),
 						},
-						0,
+						envoy.RouteTimeouts{},
 						map[string]string{"foo": "bar"},
-						"rewritten.example.com"),
+						"rewritten.example.com",
+						nil),
 					},
 				),
 			}
@@ -210,7 +220,7 @@ func TestIngressTranslator(t *testing.T) {
 								ExactMatch: "foo",
 							},
 						}},
-						"/test"),
+						envoy.PathMatch{Path: "/test"}),
 					},
 				),
 			}
@@ -229,15 +239,16 @@ func TestIngressTranslator(t *testing.T) {
 					CertificateChain: cert,
 					PrivateKey:       privateKey,
 				}},
+				certificates: []CertificateInfo{{
+					Secret:    types.NamespacedName{Namespace: "secretns", Name: "secretname"},
+					NotBefore: certNotBefore,
+					NotAfter:  certNotAfter,
+					Hosts:     []string{"foo.example.com"},
+				}},
 				clusters: []*v3.Cluster{
-					envoy.NewCluster(
-						"servicens/servicename",
-						5*time.Second,
-						lbEndpoints,
-						false,
-						v3.Cluster_STATIC,
-					),
+					envoy.NewEDSCluster("servicens/servicename", 5*time.Second, false),
 				},
+				loadAssignments:         testLoadAssignments("servicens/servicename"),
 				externalVirtualHosts:    vHostsRedirect,
 				externalTLSVirtualHosts: vHosts,
 				internalVirtualHosts:    vHostsRedirect,
@@ -273,13 +284,14 @@ func TestIngressTranslator(t *testing.T) {
 								ExactMatch: "foo",
 							},
 						}},
-						"/test",
+						envoy.PathMatch{Path: "/test"},
 						[]*route.WeightedCluster_ClusterWeight{
 							envoy.NewWeightedCluster("servicens/servicename", 100, map[string]string{"baz": "gna"}),
 						},
-						0,
+						envoy.RouteTimeouts{},
 						map[string]string{"foo": "bar"},
-						"rewritten.example.com"),
+						"rewritten.example.com",
+						nil),
 					},
 				),
 			}
@@ -298,18 +310,28 @@ func TestIngressTranslator(t *testing.T) {
 					CertificateChain: cert,
 					PrivateKey:       privateKey,
 				}},
+				certificates: []CertificateInfo{{
+					Secret:    types.NamespacedName{Namespace: "secretns", Name: "secretname"},
+					NotBefore: certNotBefore,
+					NotAfter:  certNotAfter,
+					Hosts:     []string{"foo.example.com"},
+				}},
 				clusters: []*v3.Cluster{
-					envoy.NewCluster(
-						"servicens/servicename",
-						5*time.Second,
-						lbEndpoints,
-						false,
-						v3.Cluster_STATIC,
-					),
+					envoy.NewEDSCluster("servicens/servicename", 5*time.Second, false),
 				},
+				loadAssignments:         testLoadAssignments("servicens/servicename"),
 				externalVirtualHosts:    []*route.VirtualHost{},
 				externalTLSVirtualHosts: []*route.VirtualHost{},
 				internalVirtualHosts:    vHosts,
+				localSNIMatches: []*envoy.SNIMatch{{
+					Hosts: []string{"foo.example.com"},
+					CertSource: types.NamespacedName{
+						Namespace: "secretns",
+						Name:      "secretname",
+					},
+					CertificateChain: cert,
+					PrivateKey:       privateKey,
+				}},
 			}
 		}(),
 	}, {
@@ -356,15 +378,16 @@ func TestIngressTranslator(t *testing.T) {
 								ExactMatch: "foo",
 							},
 						}},
-						"/test",
+						envoy.PathMatch{Path: "/test"},
 						[]*route.WeightedCluster_ClusterWeight{
 							envoy.NewWeightedCluster("servicens/servicename", 33, map[string]string{"baz": "gna"}),
 							envoy.NewWeightedCluster("servicens2/servicename2", 33, nil),
 							envoy.NewWeightedCluster("servicens3/servicename3", 34, nil),
 						},
-						0,
+						envoy.RouteTimeouts{},
 						map[string]string{"foo": "bar"},
-						"rewritten.example.com"),
+						"rewritten.example.com",
+						nil),
 					},
 				),
 			}
@@ -376,20 +399,8 @@ func TestIngressTranslator(t *testing.T) {
 				},
 				sniMatches: []*envoy.SNIMatch{},
 				clusters: []*v3.Cluster{
-					envoy.NewCluster(
-						"servicens/servicename",
-						5*time.Second,
-						lbEndpoints,
-						false,
-						v3.Cluster_STATIC,
-					),
-					envoy.NewCluster(
-						"servicens2/servicename2",
-						5*time.Second,
-						lbEndpoints,
-						false,
-						v3.Cluster_STATIC,
-					),
+					envoy.NewEDSCluster("servicens/servicename", 5*time.Second, false),
+					envoy.NewEDSCluster("servicens2/servicename2", 5*time.Second, false),
 					envoy.NewCluster(
 						"servicens3/servicename3",
 						5*time.Second,
@@ -398,6 +409,7 @@ func TestIngressTranslator(t *testing.T) {
 						v3.Cluster_LOGICAL_DNS,
 					),
 				},
+				loadAssignments:         testLoadAssignments("servicens/servicename", "servicens2/servicename2"),
 				externalVirtualHosts:    vHosts,
 				externalTLSVirtualHosts: []*route.VirtualHost{},
 				internalVirtualHosts:    vHosts,
@@ -425,13 +437,14 @@ func TestIngressTranslator(t *testing.T) {
 								ExactMatch: "foo",
 							},
 						}},
-						"/",
+						envoy.PathMatch{Path: "/"},
 						[]*route.WeightedCluster_ClusterWeight{
 							envoy.NewWeightedCluster("servicens/servicename", 100, map[string]string{"baz": "gna"}),
 						},
-						0,
+						envoy.RouteTimeouts{},
 						map[string]string{"foo": "bar"},
-						"rewritten.example.com"),
+						"rewritten.example.com",
+						nil),
 					},
 				),
 			}
@@ -443,14 +456,9 @@ func TestIngressTranslator(t *testing.T) {
 				},
 				sniMatches: []*envoy.SNIMatch{},
 				clusters: []*v3.Cluster{
-					envoy.NewCluster(
-						"servicens/servicename",
-						5*time.Second,
-						lbEndpoints,
-						false,
-						v3.Cluster_STATIC,
-					),
+					envoy.NewEDSCluster("servicens/servicename", 5*time.Second, false),
 				},
+				loadAssignments:         testLoadAssignments("servicens/servicename"),
 				externalVirtualHosts:    vHosts,
 				externalTLSVirtualHosts: []*route.VirtualHost{},
 				internalVirtualHosts:    vHosts,
@@ -478,13 +486,14 @@ func TestIngressTranslator(t *testing.T) {
 								ExactMatch: "foo",
 							},
 						}},
-						"/test",
+						envoy.PathMatch{Path: "/test"},
 						[]*route.WeightedCluster_ClusterWeight{
 							envoy.NewWeightedCluster("servicens/servicename", 100, map[string]string{"baz": "gna"}),
 						},
-						0,
+						envoy.RouteTimeouts{},
 						map[string]string{"foo": "bar"},
-						"rewritten.example.com"),
+						"rewritten.example.com",
+						nil),
 					},
 				),
 			}
@@ -504,6 +513,7 @@ func TestIngressTranslator(t *testing.T) {
 						v3.Cluster_LOGICAL_DNS,
 					),
 				},
+				loadAssignments:         testLoadAssignments(),
 				externalVirtualHosts:    vHosts,
 				externalTLSVirtualHosts: []*route.VirtualHost{},
 				internalVirtualHosts:    vHosts,
@@ -539,14 +549,14 @@ func TestIngressTranslator(t *testing.T) {
 			got, err := translator.translateIngress(ctx, test.in, false)
 			assert.NilError(t, err)
 			assert.DeepEqual(t, got, test.want,
-				cmp.AllowUnexported(translatedIngress{}),
+				cmp.AllowUnexported(translatedIngress{}, loadAssignment{}),
 				protocmp.Transform(),
 			)
 		})
 	}
 }
 
-// TestIngressTranslatorWithHTTPOptionDisabled runs same redirect test in TestIngressTranslator with KOURIER_HTTPOPTION_DISABLED env value.
+// TestIngressTranslatorWithHTTPOptionDisabled runs same redirect test in TestIngressTranslator with disable-http-option set.
 func TestIngressTranslatorWithHTTPOptionDisabled(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -581,13 +591,14 @@ func TestIngressTranslatorWithHTTPOptionDisabled(t *testing.T) {
 								ExactMatch: "foo",
 							},
 						}},
-						"/test",
+						envoy.PathMatch{Path: "/test"},
 						[]*route.WeightedCluster_ClusterWeight{
 							envoy.NewWeightedCluster("servicens/servicename", 100, map[string]string{"baz": "gna"}),
 						},
-						0,
+						envoy.RouteTimeouts{},
 						map[string]string{"foo": "bar"},
-						"rewritten.example.com"),
+						"rewritten.example.com",
+						nil),
 					},
 				),
 			}
@@ -605,15 +616,16 @@ func TestIngressTranslatorWithHTTPOptionDisabled(t *testing.T) {
 					CertificateChain: cert,
 					PrivateKey:       privateKey,
 				}},
+				certificates: []CertificateInfo{{
+					Secret:    types.NamespacedName{Namespace: "secretns", Name: "secretname"},
+					NotBefore: certNotBefore,
+					NotAfter:  certNotAfter,
+					Hosts:     []string{"foo.example.com"},
+				}},
 				clusters: []*v3.Cluster{
-					envoy.NewCluster(
-						"servicens/servicename",
-						5*time.Second,
-						lbEndpoints,
-						false,
-						v3.Cluster_STATIC,
-					),
+					envoy.NewEDSCluster("servicens/servicename", 5*time.Second, false),
 				},
+				loadAssignments:         testLoadAssignments("servicens/servicename"),
 				externalVirtualHosts:    vHosts,
 				externalTLSVirtualHosts: vHosts,
 				internalVirtualHosts:    vHosts,
@@ -649,13 +661,14 @@ func TestIngressTranslatorWithHTTPOptionDisabled(t *testing.T) {
 								ExactMatch: "foo",
 							},
 						}},
-						"/test",
+						envoy.PathMatch{Path: "/test"},
 						[]*route.WeightedCluster_ClusterWeight{
 							envoy.NewWeightedCluster("servicens/servicename", 100, map[string]string{"baz": "gna"}),
 						},
-						0,
+						envoy.RouteTimeouts{},
 						map[string]string{"foo": "bar"},
-						"rewritten.example.com"),
+						"rewritten.example.com",
+						nil),
 					},
 				),
 			}
@@ -674,26 +687,38 @@ func TestIngressTranslatorWithHTTPOptionDisabled(t *testing.T) {
 					CertificateChain: cert,
 					PrivateKey:       privateKey,
 				}},
+				certificates: []CertificateInfo{{
+					Secret:    types.NamespacedName{Namespace: "secretns", Name: "secretname"},
+					NotBefore: certNotBefore,
+					NotAfter:  certNotAfter,
+					Hosts:     []string{"foo.example.com"},
+				}},
 				clusters: []*v3.Cluster{
-					envoy.NewCluster(
-						"servicens/servicename",
-						5*time.Second,
-						lbEndpoints,
-						false,
-						v3.Cluster_STATIC,
-					),
+					envoy.NewEDSCluster("servicens/servicename", 5*time.Second, false),
 				},
+				loadAssignments:         testLoadAssignments("servicens/servicename"),
 				externalVirtualHosts:    []*route.VirtualHost{},
 				externalTLSVirtualHosts: []*route.VirtualHost{},
 				internalVirtualHosts:    vHosts,
+				localSNIMatches: []*envoy.SNIMatch{{
+					Hosts: []string{"foo.example.com"},
+					CertSource: types.NamespacedName{
+						Namespace: "secretns",
+						Name:      "secretname",
+					},
+					CertificateChain: cert,
+					PrivateKey:       privateKey,
+				}},
 			}
 		}(),
 	}}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			t.Setenv("KOURIER_HTTPOPTION_DISABLED", "true")
 			ctx, _ := pkgtest.SetupFakeContext(t)
+			cfg := config.DefaultConfig()
+			cfg.DisableHTTPOption = true
+			ctx = rconfig.ToContext(ctx, &rconfig.Config{Kourier: cfg})
 			kubeclient := fake.NewSimpleClientset(test.state...)
 
 			translator := NewIngressTranslator(
@@ -712,13 +737,1151 @@ func TestIngressTranslatorWithHTTPOptionDisabled(t *testing.T) {
 			got, err := translator.translateIngress(ctx, test.in, false)
 			assert.NilError(t, err)
 			assert.DeepEqual(t, got, test.want,
-				cmp.AllowUnexported(translatedIngress{}),
+				cmp.AllowUnexported(translatedIngress{}, loadAssignment{}),
 				protocmp.Transform(),
 			)
 		})
 	}
 }
 
+func TestIngressTranslatorInvalidCertificate(t *testing.T) {
+	ctx, _ := pkgtest.SetupFakeContext(t)
+	kubeclient := fake.NewSimpleClientset(
+		svc("servicens", "servicename"),
+		eps("servicens", "servicename"),
+		secret,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "secretns", Name: "missing-key"},
+			Data:       map[string][]byte{certFieldInSecret: cert},
+		},
+	)
+
+	translator := NewIngressTranslator(
+		func(ns, name string) (*corev1.Secret, error) {
+			return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		func(ns, name string) (*corev1.Endpoints, error) {
+			return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		func(ns, name string) (*corev1.Service, error) {
+			return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		&pkgtest.FakeTracker{},
+	)
+
+	// The certificate covers foo.example.com, but not bar.example.com.
+	got, err := translator.translateIngress(ctx, ing("testspace", "testname", func(ing *v1alpha1.Ingress) {
+		ing.Spec.Rules[0].Hosts = []string{"foo.example.com", "bar.example.com"}
+		ing.Spec.TLS = []v1alpha1.IngressTLS{{
+			Hosts:           []string{"foo.example.com"},
+			SecretNamespace: "secretns",
+			SecretName:      "secretname",
+		}, {
+			Hosts:           []string{"bar.example.com"},
+			SecretNamespace: "secretns",
+			SecretName:      "secretname",
+		}}
+	}), false)
+	assert.NilError(t, err)
+
+	// Only the invalid TLS entry is left out, the rest of the ingress is served.
+	assert.NilError(t, got.validate())
+	assert.Assert(t, errors.Is(got.tlsCertificateErr, ErrCertificateInvalid))
+	assert.ErrorContains(t, got.tlsCertificateErr, "in secret secretns/secretname")
+	assert.Equal(t, len(got.sniMatches), 1)
+	assert.DeepEqual(t, got.sniMatches[0].Hosts, []string{"foo.example.com"})
+	assert.DeepEqual(t, got.externalVirtualHosts[0].Domains,
+		[]string{"foo.example.com", "foo.example.com:*", "bar.example.com", "bar.example.com:*"})
+	assert.DeepEqual(t, got.externalTLSVirtualHosts[0].Domains, []string{"foo.example.com", "foo.example.com:*"})
+
+	// Without any valid certificate, the ingress is only served via plain HTTP.
+	got, err = translator.translateIngress(ctx, ing("testspace", "testname", func(ing *v1alpha1.Ingress) {
+		ing.Spec.TLS = []v1alpha1.IngressTLS{{
+			Hosts:           []string{"foo.example.com"},
+			SecretNamespace: "secretns",
+			SecretName:      "missing-key",
+		}}
+	}), false)
+	assert.NilError(t, err)
+	assert.NilError(t, got.validate())
+	assert.Assert(t, errors.Is(got.tlsCertificateErr, ErrCertificateInvalid))
+	assert.Equal(t, len(got.sniMatches), 0)
+	assert.Equal(t, len(got.externalVirtualHosts), 1)
+	assert.Equal(t, len(got.externalTLSVirtualHosts), 0)
+}
+
+func TestIngressTranslatorWildcardCertificates(t *testing.T) {
+	now := time.Now()
+	notAfter := now.Add(24 * time.Hour).UTC().Truncate(time.Second)
+	wildcardCert, wildcardKey := generateCertificate([]string{"*.example.com"}, now.Add(-time.Hour), notAfter)
+	otherCert, otherKey := generateCertificate([]string{"*.example.org"}, now.Add(-time.Hour), notAfter)
+	expiredCert, expiredKey := generateCertificate([]string{"*.example.com"}, now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	tlsSecret := func(name string, cert, key []byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "certns", Name: name},
+			Data: map[string][]byte{
+				certFieldInSecret: cert,
+				keyFieldInSecret:  key,
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		in          *v1alpha1.Ingress
+		secrets     []types.NamespacedName
+		wantMatches []*envoy.SNIMatch
+	}{{
+		name: "first covering certificate is used",
+		in:   ing("testspace", "testname"),
+		secrets: []types.NamespacedName{
+			{Namespace: "certns", Name: "missing"},
+			{Namespace: "certns", Name: "expired"},
+			{Namespace: "certns", Name: "other"},
+			{Namespace: "certns", Name: "wildcard"},
+		},
+		wantMatches: []*envoy.SNIMatch{{
+			Hosts:            []string{"foo.example.com"},
+			CertSource:       types.NamespacedName{Namespace: "certns", Name: "wildcard"},
+			CertificateChain: wildcardCert,
+			PrivateKey:       wildcardKey,
+		}},
+	}, {
+		name:    "no covering certificate",
+		in:      ing("testspace", "testname"),
+		secrets: []types.NamespacedName{{Namespace: "certns", Name: "other"}},
+	}, {
+		name: "cluster local rules are left out",
+		in: ing("testspace", "testname", func(ing *v1alpha1.Ingress) {
+			ing.Spec.Rules[0].Visibility = v1alpha1.IngressVisibilityClusterLocal
+		}),
+		secrets: []types.NamespacedName{{Namespace: "certns", Name: "wildcard"}},
+	}, {
+		name: "explicit certificates take precedence",
+		in: ing("testspace", "testname", func(ing *v1alpha1.Ingress) {
+			ing.Spec.TLS = []v1alpha1.IngressTLS{{
+				Hosts:           []string{"foo.example.com"},
+				SecretNamespace: "secretns",
+				SecretName:      "secretname",
+			}}
+		}),
+		secrets: []types.NamespacedName{{Namespace: "certns", Name: "wildcard"}},
+		wantMatches: []*envoy.SNIMatch{{
+			Hosts:            []string{"foo.example.com"},
+			CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secretname"},
+			CertificateChain: cert,
+			PrivateKey:       privateKey,
+		}},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := pkgtest.SetupFakeContext(t)
+			cfg := config.DefaultConfig()
+			cfg.WildcardCertsSecrets = test.secrets
+			ctx = rconfig.ToContext(ctx, &rconfig.Config{Kourier: cfg})
+
+			kubeclient := fake.NewSimpleClientset(
+				svc("servicens", "servicename"),
+				eps("servicens", "servicename"),
+				secret,
+				tlsSecret("wildcard", wildcardCert, wildcardKey),
+				tlsSecret("other", otherCert, otherKey),
+				tlsSecret("expired", expiredCert, expiredKey),
+			)
+
+			translator := NewIngressTranslator(
+				func(ns, name string) (*corev1.Secret, error) {
+					return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Endpoints, error) {
+					return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Service, error) {
+					return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				&pkgtest.FakeTracker{},
+			)
+
+			got, err := translator.translateIngress(ctx, test.in, false)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got.sniMatches, test.wantMatches, cmpopts.EquateEmpty())
+			assert.NilError(t, got.validate())
+
+			// The hosts are only served via HTTPS if there's a certificate for them.
+			assert.Equal(t, len(got.externalTLSVirtualHosts) != 0, len(test.wantMatches) != 0)
+		})
+	}
+}
+
+func TestIngressTranslatorMultipleCertificates(t *testing.T) {
+	now := time.Now()
+	rsaCert, rsaKey := generateRSACertificate([]string{"foo.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	ecdsaCert, ecdsaKey := generateCertificate([]string{"foo.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	tlsSecret := func(name string, cert, key []byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "secretns", Name: name},
+			Data: map[string][]byte{
+				certFieldInSecret: cert,
+				keyFieldInSecret:  key,
+			},
+		}
+	}
+	withTLS := func(secretNames ...string) func(*v1alpha1.Ingress) {
+		return func(ing *v1alpha1.Ingress) {
+			for _, name := range secretNames {
+				ing.Spec.TLS = append(ing.Spec.TLS, v1alpha1.IngressTLS{
+					Hosts:           []string{"foo.example.com"},
+					SecretNamespace: "secretns",
+					SecretName:      name,
+				})
+			}
+		}
+	}
+
+	ctx, _ := pkgtest.SetupFakeContext(t)
+	kubeclient := fake.NewSimpleClientset(
+		svc("servicens", "servicename"),
+		eps("servicens", "servicename"),
+		secret,
+		tlsSecret("rsa", rsaCert, rsaKey),
+		tlsSecret("ecdsa", ecdsaCert, ecdsaKey),
+	)
+
+	translator := NewIngressTranslator(
+		func(ns, name string) (*corev1.Secret, error) {
+			return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		func(ns, name string) (*corev1.Endpoints, error) {
+			return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		func(ns, name string) (*corev1.Service, error) {
+			return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		&pkgtest.FakeTracker{},
+	)
+
+	// An ECDSA and an RSA certificate are served side by side.
+	got, err := translator.translateIngress(ctx, ing("testspace", "testname", withTLS("ecdsa", "rsa")), false)
+	assert.NilError(t, err)
+	assert.NilError(t, got.validate())
+	assert.DeepEqual(t, got.sniMatches, []*envoy.SNIMatch{{
+		Hosts:            []string{"foo.example.com"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "ecdsa"},
+		CertificateChain: ecdsaCert,
+		PrivateKey:       ecdsaKey,
+		AdditionalCertificates: []envoy.Certificate{{
+			CertSource:       types.NamespacedName{Namespace: "secretns", Name: "rsa"},
+			CertificateChain: rsaCert,
+			PrivateKey:       rsaKey,
+		}},
+	}})
+
+	// Two ECDSA certificates can't be served side by side, so the host is left out of
+	// the HTTPS listener.
+	got, err = translator.translateIngress(ctx, ing("testspace", "testname", withTLS("ecdsa", "secretname")), false)
+	assert.NilError(t, err)
+	assert.NilError(t, got.validate())
+	assert.Assert(t, errors.Is(got.tlsCertificateErr, ErrCertificateInvalid))
+	assert.ErrorContains(t, got.tlsCertificateErr, "both hold ECDSA certificates")
+	assert.Equal(t, len(got.sniMatches), 0)
+	assert.Equal(t, len(got.externalTLSVirtualHosts), 0)
+}
+
+func TestIngressTranslatorClusterLocalTLS(t *testing.T) {
+	now := time.Now()
+	localCert, localKey := generateCertificate([]string{"*.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	localSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certns", Name: "cluster-local"},
+		Data: map[string][]byte{
+			certFieldInSecret: localCert,
+			keyFieldInSecret:  localKey,
+		},
+	}
+
+	clusterLocal := func(ing *v1alpha1.Ingress) {
+		ing.Spec.Rules[0].Visibility = v1alpha1.IngressVisibilityClusterLocal
+	}
+	withTLS := func(ing *v1alpha1.Ingress) {
+		ing.Spec.TLS = []v1alpha1.IngressTLS{{
+			Hosts:           []string{"foo.example.com"},
+			SecretNamespace: "secretns",
+			SecretName:      "secretname",
+		}}
+	}
+
+	tests := []struct {
+		name        string
+		in          *v1alpha1.Ingress
+		secret      *types.NamespacedName
+		wantMatches []*envoy.SNIMatch
+	}{{
+		name: "no certificate",
+		in:   ing("testspace", "testname", clusterLocal),
+	}, {
+		name: "certificate from TLS section",
+		in:   ing("testspace", "testname", clusterLocal, withTLS),
+		wantMatches: []*envoy.SNIMatch{{
+			Hosts:            []string{"foo.example.com"},
+			CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secretname"},
+			CertificateChain: cert,
+			PrivateKey:       privateKey,
+		}},
+	}, {
+		name:   "certificate from config",
+		in:     ing("testspace", "testname", clusterLocal),
+		secret: &types.NamespacedName{Namespace: "certns", Name: "cluster-local"},
+		wantMatches: []*envoy.SNIMatch{{
+			Hosts:            []string{"foo.example.com"},
+			CertSource:       types.NamespacedName{Namespace: "certns", Name: "cluster-local"},
+			CertificateChain: localCert,
+			PrivateKey:       localKey,
+		}},
+	}, {
+		name:   "TLS section takes precedence",
+		in:     ing("testspace", "testname", clusterLocal, withTLS),
+		secret: &types.NamespacedName{Namespace: "certns", Name: "cluster-local"},
+		wantMatches: []*envoy.SNIMatch{{
+			Hosts:            []string{"foo.example.com"},
+			CertSource:       types.NamespacedName{Namespace: "secretns", Name: "secretname"},
+			CertificateChain: cert,
+			PrivateKey:       privateKey,
+		}},
+	}, {
+		name:   "missing certificate from config",
+		in:     ing("testspace", "testname", clusterLocal),
+		secret: &types.NamespacedName{Namespace: "certns", Name: "missing"},
+	}, {
+		name:   "external rules are left out",
+		in:     ing("testspace", "testname", withTLS),
+		secret: &types.NamespacedName{Namespace: "certns", Name: "cluster-local"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := pkgtest.SetupFakeContext(t)
+			cfg := config.DefaultConfig()
+			cfg.ClusterLocalCertsSecret = test.secret
+			ctx = rconfig.ToContext(ctx, &rconfig.Config{Kourier: cfg})
+
+			kubeclient := fake.NewSimpleClientset(
+				svc("servicens", "servicename"),
+				eps("servicens", "servicename"),
+				secret,
+				localSecret,
+			)
+
+			translator := NewIngressTranslator(
+				func(ns, name string) (*corev1.Secret, error) {
+					return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Endpoints, error) {
+					return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Service, error) {
+					return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				&pkgtest.FakeTracker{},
+			)
+
+			got, err := translator.translateIngress(ctx, test.in, false)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got.localSNIMatches, test.wantMatches, cmpopts.EquateEmpty())
+			assert.NilError(t, got.validate())
+		})
+	}
+}
+
+func TestIngressTranslatorClientValidation(t *testing.T) {
+	now := time.Now()
+	caCert, _ := generateCertificate([]string{"ca.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	caSecret := func(ns, name string, bundle []byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+			Data: map[string][]byte{
+				caFieldInSecret: bundle,
+			},
+		}
+	}
+	withTLS := func(ing *v1alpha1.Ingress) {
+		ing.Spec.TLS = []v1alpha1.IngressTLS{{
+			Hosts:           []string{"foo.example.com"},
+			SecretNamespace: "secretns",
+			SecretName:      "secretname",
+		}}
+	}
+	withAnnotations := func(annotations map[string]string) func(*v1alpha1.Ingress) {
+		return func(ing *v1alpha1.Ingress) {
+			ing.Annotations = annotations
+		}
+	}
+
+	tests := []struct {
+		name           string
+		in             *v1alpha1.Ingress
+		clientCASecret *types.NamespacedName
+		want           *envoy.ClientValidation
+		wantErr        error
+	}{{
+		name: "not required",
+		in:   ing("testspace", "testname", withTLS),
+	}, {
+		name: "CA bundle from annotation",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.ClientCASecretAnnotationKey: "partner-ca",
+			config.ClientCertSANsAnnotationKey: "a.example.com, b.example.com",
+		})),
+		want: &envoy.ClientValidation{
+			CASource:        types.NamespacedName{Namespace: "testspace", Name: "partner-ca"},
+			CABundle:        caCert,
+			SubjectAltNames: []string{"a.example.com", "b.example.com"},
+		},
+	}, {
+		name: "CA bundle from config",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.RequireClientCertificateAnnotationKey: "true",
+		})),
+		clientCASecret: &types.NamespacedName{Namespace: "kourier-system", Name: "shared-ca"},
+		want: &envoy.ClientValidation{
+			CASource: types.NamespacedName{Namespace: "kourier-system", Name: "shared-ca"},
+			CABundle: caCert,
+		},
+	}, {
+		name: "explicitly not required",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.RequireClientCertificateAnnotationKey: "false",
+		})),
+		clientCASecret: &types.NamespacedName{Namespace: "kourier-system", Name: "shared-ca"},
+	}, {
+		name: "no CA bundle configured",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.RequireClientCertificateAnnotationKey: "true",
+		})),
+		wantErr: ErrInvalidAnnotation,
+	}, {
+		name: "secret in another namespace",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.ClientCASecretAnnotationKey: "kourier-system/shared-ca",
+		})),
+		wantErr: ErrInvalidAnnotation,
+	}, {
+		name: "host without a certificate",
+		in: ing("testspace", "testname", withAnnotations(map[string]string{
+			config.ClientCASecretAnnotationKey: "partner-ca",
+		})),
+		wantErr: ErrInvalidAnnotation,
+	}, {
+		name: "missing CA bundle secret",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.ClientCASecretAnnotationKey: "missing",
+		})),
+		wantErr: ErrCertificateInvalid,
+	}, {
+		name: "invalid CA bundle",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.ClientCASecretAnnotationKey: "invalid-ca",
+		})),
+		wantErr: ErrCertificateInvalid,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := pkgtest.SetupFakeContext(t)
+			cfg := config.DefaultConfig()
+			cfg.ClientCASecret = test.clientCASecret
+			ctx = rconfig.ToContext(ctx, &rconfig.Config{Kourier: cfg})
+
+			kubeclient := fake.NewSimpleClientset(
+				svc("servicens", "servicename"),
+				eps("servicens", "servicename"),
+				secret,
+				caSecret("testspace", "partner-ca", caCert),
+				caSecret("testspace", "invalid-ca", []byte("invalid")),
+				caSecret("kourier-system", "shared-ca", caCert),
+			)
+
+			translator := NewIngressTranslator(
+				func(ns, name string) (*corev1.Secret, error) {
+					return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Endpoints, error) {
+					return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Service, error) {
+					return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				&pkgtest.FakeTracker{},
+			)
+
+			got, err := translator.translateIngress(ctx, test.in, false)
+			assert.NilError(t, err)
+
+			err = got.validate()
+			if test.wantErr != nil {
+				assert.Assert(t, errors.Is(err, test.wantErr), "got error %v", err)
+				return
+			}
+			assert.NilError(t, err)
+
+			for _, match := range got.sniMatches {
+				assert.DeepEqual(t, match.ClientValidation, test.want)
+			}
+
+			// Plain HTTP requests are redirected if client certificates are required.
+			redirect := got.externalVirtualHosts[0].Routes[0].GetRedirect() != nil
+			assert.Equal(t, redirect, test.want != nil)
+		})
+	}
+}
+
+func TestIngressTranslatorUpstreamTLS(t *testing.T) {
+	now := time.Now()
+	caCert, _ := generateCertificate([]string{"ca.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	clientCert, clientKey := generateCertificate([]string{"kourier.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	newSecret := func(name string, data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kourier-system", Name: name},
+			Data:       data,
+		}
+	}
+	httpsPort := func(ing *v1alpha1.Ingress) {
+		ing.Spec.Rules[0].HTTP.Paths[0].Splits[0].ServicePort = intstr.FromString("https")
+	}
+	twoSplits := func(ing *v1alpha1.Ingress) {
+		splits := ing.Spec.Rules[0].HTTP.Paths[0].Splits
+		ing.Spec.Rules[0].HTTP.Paths[0].Splits = append(splits, splits...)
+	}
+	caSecret := &types.NamespacedName{Namespace: "kourier-system", Name: "upstream-ca"}
+	clientCertsSecret := &types.NamespacedName{Namespace: "kourier-system", Name: "upstream-client"}
+
+	tests := []struct {
+		name              string
+		in                *v1alpha1.Ingress
+		enable            bool
+		caSecret          *types.NamespacedName
+		sans              []string
+		clientCertsSecret *types.NamespacedName
+		want              *envoy.UpstreamTLS
+		wantErr           error
+	}{{
+		name:     "plain text",
+		in:       ing("testspace", "testname"),
+		caSecret: caSecret,
+	}, {
+		name:     "port named https",
+		in:       ing("testspace", "testname", httpsPort),
+		caSecret: caSecret,
+		want:     &envoy.UpstreamTLS{CASource: *caSecret, CABundle: caCert, MatchSNI: true},
+	}, {
+		name:     "enabled for all services",
+		in:       ing("testspace", "testname"),
+		enable:   true,
+		caSecret: caSecret,
+		want:     &envoy.UpstreamTLS{CASource: *caSecret, CABundle: caCert, MatchSNI: true},
+	}, {
+		name:              "client certificate",
+		in:                ing("testspace", "testname", httpsPort),
+		caSecret:          caSecret,
+		clientCertsSecret: clientCertsSecret,
+		want: &envoy.UpstreamTLS{
+			CASource: *caSecret,
+			CABundle: caCert,
+			MatchSNI: true,
+			ClientCertificate: &envoy.Certificate{
+				CertSource:       *clientCertsSecret,
+				CertificateChain: clientCert,
+				PrivateKey:       clientKey,
+			},
+		},
+	}, {
+		name:     "subject alternative names",
+		in:       ing("testspace", "testname", httpsPort),
+		caSecret: caSecret,
+		sans:     []string{"*.servicens.svc"},
+		want:     &envoy.UpstreamTLS{CASource: *caSecret, CABundle: caCert, SubjectAltNames: []string{"*.servicens.svc"}, MatchSNI: true},
+	}, {
+		name:    "no CA bundle configured",
+		in:      ing("testspace", "testname", httpsPort),
+		wantErr: ErrCertificateInvalid,
+	}, {
+		name:     "missing CA bundle secret",
+		in:       ing("testspace", "testname", httpsPort),
+		caSecret: &types.NamespacedName{Namespace: "kourier-system", Name: "missing"},
+		wantErr:  ErrCertificateInvalid,
+	}, {
+		name:     "missing CA bundle secret with multiple splits",
+		in:       ing("testspace", "testname", httpsPort, twoSplits),
+		caSecret: &types.NamespacedName{Namespace: "kourier-system", Name: "missing"},
+		wantErr:  ErrCertificateInvalid,
+	}, {
+		name:              "invalid client certificate",
+		in:                ing("testspace", "testname", httpsPort),
+		caSecret:          caSecret,
+		clientCertsSecret: &types.NamespacedName{Namespace: "kourier-system", Name: "invalid-client"},
+		wantErr:           ErrCertificateInvalid,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := pkgtest.SetupFakeContext(t)
+			cfg := config.DefaultConfig()
+			cfg.EnableUpstreamTLS = test.enable
+			cfg.UpstreamCASecret = test.caSecret
+			cfg.UpstreamSubjectAltNames = test.sans
+			cfg.UpstreamClientCertsSecret = test.clientCertsSecret
+			ctx = rconfig.ToContext(ctx, &rconfig.Config{Kourier: cfg})
+
+			kubeclient := fake.NewSimpleClientset(
+				svc("servicens", "servicename", func(svc *corev1.Service) {
+					svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+						Name:       "https",
+						Port:       443,
+						TargetPort: intstr.FromInt(8443),
+					})
+				}),
+				eps("servicens", "servicename"),
+				newSecret("upstream-ca", map[string][]byte{caFieldInSecret: caCert}),
+				newSecret("upstream-client", map[string][]byte{certFieldInSecret: clientCert, keyFieldInSecret: clientKey}),
+				newSecret("invalid-client", map[string][]byte{certFieldInSecret: []byte("invalid")}),
+			)
+
+			// The secrets are fetched once, whatever the number of splits.
+			fetched := make(map[string]int)
+			translator := NewIngressTranslator(
+				func(ns, name string) (*corev1.Secret, error) {
+					fetched[ns+"/"+name]++
+					return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Endpoints, error) {
+					return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Service, error) {
+					return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				&pkgtest.FakeTracker{},
+			)
+
+			got, err := translator.translateIngress(ctx, test.in, false)
+			assert.NilError(t, err)
+			for name, count := range fetched {
+				assert.Equal(t, count, 1, "secret %s fetched %d times", name, count)
+			}
+
+			err = got.validate()
+			if test.wantErr != nil {
+				assert.Assert(t, errors.Is(err, test.wantErr), "got error %v", err)
+				return
+			}
+			assert.NilError(t, err)
+
+			want := envoy.NewEDSCluster("servicens/servicename", 5*time.Second, false)
+			var wantSecrets map[string][]*auth.Secret
+			if test.want != nil {
+				err := envoy.SetUpstreamTLS(want, test.want, network.GetServiceHostname("servicename", "servicens"), false)
+				assert.NilError(t, err)
+				wantSecrets = map[string][]*auth.Secret{"servicens/servicename": test.want.Secrets()}
+			}
+			assert.DeepEqual(t, got.clusters, []*v3.Cluster{want}, protocmp.Transform())
+			assert.DeepEqual(t, got.upstreamSecrets, wantSecrets, protocmp.Transform())
+		})
+	}
+}
+
+func TestIngressTranslatorNetworkConfig(t *testing.T) {
+	now := time.Now()
+	caCert, _ := generateCertificate([]string{"ca.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	clusterLocal := func(ing *v1alpha1.Ingress) {
+		ing.Spec.Rules[0].Visibility = v1alpha1.IngressVisibilityClusterLocal
+	}
+	withTLS := func(ing *v1alpha1.Ingress) {
+		ing.Spec.TLS = []v1alpha1.IngressTLS{{
+			Hosts:           []string{"foo.example.com"},
+			SecretNamespace: "secretns",
+			SecretName:      "secretname",
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		in      *v1alpha1.Ingress
+		network *config.Network
+		check   func(*testing.T, *translatedIngress)
+	}{{
+		// Knative Serving applies it through the HTTPOption of the ingresses, which
+		// must not be overridden for the ingresses without a certificate.
+		name:    "HTTP redirected",
+		in:      ing("testspace", "testname"),
+		network: &config.Network{HTTPProtocol: networking.HTTPRedirected},
+		check: func(t *testing.T, got *translatedIngress) {
+			assert.Check(t, got.externalVirtualHosts[0].Routes[0].GetRoute() != nil)
+		},
+	}, {
+		name:    "cluster-local domain TLS",
+		in:      ing("testspace", "testname", clusterLocal, withTLS),
+		network: &config.Network{ClusterLocalDomainTLS: true},
+		check: func(t *testing.T, got *translatedIngress) {
+			assert.Equal(t, got.internalVirtualHosts[0].RequireTls, route.VirtualHost_ALL)
+		},
+	}, {
+		name:    "cluster-local domain TLS without certificate",
+		in:      ing("testspace", "testname", clusterLocal),
+		network: &config.Network{ClusterLocalDomainTLS: true},
+		check: func(t *testing.T, got *translatedIngress) {
+			assert.Equal(t, got.internalVirtualHosts[0].RequireTls, route.VirtualHost_NONE)
+		},
+	}, {
+		name:    "internal encryption",
+		in:      ing("testspace", "testname"),
+		network: &config.Network{InternalEncryption: true, ActivatorCA: "serving-ca", ActivatorSAN: "knative"},
+		check: func(t *testing.T, got *translatedIngress) {
+			want := envoy.NewEDSCluster("servicens/servicename", 5*time.Second, false)
+			err := envoy.SetUpstreamTLS(want, &envoy.UpstreamTLS{
+				CASource:        types.NamespacedName{Namespace: system.Namespace(), Name: "serving-ca"},
+				CABundle:        caCert,
+				SubjectAltNames: []string{"knative"},
+			}, network.GetServiceHostname("servicename", "servicens"), false)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got.clusters, []*v3.Cluster{want}, protocmp.Transform())
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := pkgtest.SetupFakeContext(t)
+			ctx = rconfig.ToContext(ctx, &rconfig.Config{Kourier: config.DefaultConfig(), Network: test.network})
+
+			kubeclient := fake.NewSimpleClientset(
+				svc("servicens", "servicename"),
+				eps("servicens", "servicename"),
+				secret,
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Namespace: system.Namespace(), Name: "serving-ca"},
+					Data: map[string][]byte{
+						caFieldInSecret: caCert,
+					},
+				},
+			)
+
+			translator := NewIngressTranslator(
+				func(ns, name string) (*corev1.Secret, error) {
+					return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Endpoints, error) {
+					return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Service, error) {
+					return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				&pkgtest.FakeTracker{},
+			)
+
+			got, err := translator.translateIngress(ctx, test.in, false)
+			assert.NilError(t, err)
+			assert.NilError(t, got.validate())
+			test.check(t, got)
+		})
+	}
+}
+
+func TestIngressTranslatorHeaderMatches(t *testing.T) {
+	ctx, _ := pkgtest.SetupFakeContext(t)
+	kubeclient := fake.NewSimpleClientset(
+		svc("servicens", "servicename"),
+		eps("servicens", "servicename"),
+	)
+
+	translator := NewIngressTranslator(
+		func(ns, name string) (*corev1.Secret, error) {
+			return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		func(ns, name string) (*corev1.Endpoints, error) {
+			return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		func(ns, name string) (*corev1.Service, error) {
+			return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		&pkgtest.FakeTracker{},
+	)
+
+	got, err := translator.translateIngress(ctx, ing("testspace", "testname", func(ing *v1alpha1.Ingress) {
+		ing.Annotations = map[string]string{
+			config.HeaderMatchesAnnotationKey: "/test x-user-id prefix beta-",
+		}
+	}), false)
+	assert.NilError(t, err)
+	assert.NilError(t, got.validate())
+
+	// The matchers of the annotation are added to the ones of the ingress.
+	want := []*route.HeaderMatcher{{
+		Name:                 "testheader",
+		HeaderMatchSpecifier: &route.HeaderMatcher_ExactMatch{ExactMatch: "foo"},
+	}, {
+		Name:                 "x-user-id",
+		HeaderMatchSpecifier: &route.HeaderMatcher_PrefixMatch{PrefixMatch: "beta-"},
+	}}
+	assert.DeepEqual(t, got.externalVirtualHosts[0].Routes[0].Match.Headers, want, protocmp.Transform())
+
+	got, err = translator.translateIngress(ctx, ing("testspace", "testname", func(ing *v1alpha1.Ingress) {
+		ing.Annotations = map[string]string{
+			config.HeaderMatchesAnnotationKey: "x-user-id regex beta-(",
+		}
+	}), false)
+	assert.NilError(t, err)
+	assert.Assert(t, errors.Is(got.validate(), ErrInvalidAnnotation))
+
+	// A path routed by several paths of a rule, e.g. for a tag, is ambiguous. The
+	// same path in rules of different hosts is not.
+	withTag := func(annotation string) func(*v1alpha1.Ingress) {
+		return func(ing *v1alpha1.Ingress) {
+			ing.Annotations = map[string]string{
+				config.HeaderMatchesAnnotationKey: annotation,
+			}
+			rule := &ing.Spec.Rules[0]
+			untagged := *rule.HTTP.Paths[0].DeepCopy()
+			untagged.Headers = nil
+			local := *rule.DeepCopy()
+			local.Hosts = []string{"foo.testspace.svc.cluster.local"}
+			local.Visibility = v1alpha1.IngressVisibilityClusterLocal
+			rule.HTTP.Paths = append(rule.HTTP.Paths, untagged)
+			ing.Spec.Rules = append(ing.Spec.Rules, local)
+		}
+	}
+
+	got, err = translator.translateIngress(ctx, ing("testspace", "testname", withTag("/test x-user-id prefix beta-")), false)
+	assert.NilError(t, err)
+	assert.Assert(t, errors.Is(got.validate(), ErrInvalidAnnotation))
+
+	got, err = translator.translateIngress(ctx, ing("testspace", "testname", withTag("x-user-id prefix beta-")), false)
+	assert.NilError(t, err)
+	assert.NilError(t, got.validate())
+
+	got, err = translator.translateIngress(ctx, ing("testspace", "testname", func(ing *v1alpha1.Ingress) {
+		ing.Annotations = map[string]string{
+			config.HeaderMatchesAnnotationKey: "/test x-user-id prefix beta-",
+		}
+		local := *ing.Spec.Rules[0].DeepCopy()
+		local.Hosts = []string{"foo.testspace.svc.cluster.local"}
+		local.Visibility = v1alpha1.IngressVisibilityClusterLocal
+		ing.Spec.Rules = append(ing.Spec.Rules, local)
+	}), false)
+	assert.NilError(t, err)
+	assert.NilError(t, got.validate())
+}
+
+func TestIngressTranslatorPathMatches(t *testing.T) {
+	ctx, _ := pkgtest.SetupFakeContext(t)
+	kubeclient := fake.NewSimpleClientset(
+		svc("servicens", "servicename"),
+		eps("servicens", "servicename"),
+	)
+
+	translator := NewIngressTranslator(
+		func(ns, name string) (*corev1.Secret, error) {
+			return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		func(ns, name string) (*corev1.Endpoints, error) {
+			return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		func(ns, name string) (*corev1.Service, error) {
+			return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		&pkgtest.FakeTracker{},
+	)
+
+	withPaths := func(annotation string) func(*v1alpha1.Ingress) {
+		return func(ing *v1alpha1.Ingress) {
+			ing.Annotations = map[string]string{
+				config.PathMatchesAnnotationKey: annotation,
+			}
+			paths := ing.Spec.Rules[0].HTTP.Paths
+			catchAll := *paths[0].DeepCopy()
+			catchAll.Path = "/"
+			ing.Spec.Rules[0].HTTP.Paths = []v1alpha1.HTTPIngressPath{catchAll, paths[0]}
+		}
+	}
+
+	got, err := translator.translateIngress(ctx, ing("testspace", "testname", withPaths("prefix\n/test exact")), false)
+	assert.NilError(t, err)
+	assert.NilError(t, got.validate())
+
+	// The exact match comes before the catch-all prefix, despite the order of the ingress.
+	routes := got.externalVirtualHosts[0].Routes
+	assert.Equal(t, len(routes), 2)
+	assert.DeepEqual(t, routes[0].Match.PathSpecifier, &route.RouteMatch_Path{Path: "/test"}, protocmp.Transform())
+	assert.DeepEqual(t, routes[1].Match.PathSpecifier, &route.RouteMatch_Prefix{Prefix: "/"}, protocmp.Transform())
+
+	got, err = translator.translateIngress(ctx, ing("testspace", "testname", withPaths("/test glob")), false)
+	assert.NilError(t, err)
+	assert.Assert(t, errors.Is(got.validate(), ErrInvalidAnnotation))
+}
+
+func TestIngressTranslatorTimeouts(t *testing.T) {
+	ctx, _ := pkgtest.SetupFakeContext(t)
+	cfg := config.DefaultConfig()
+	cfg.Timeout = config.TimeoutConfig{
+		Request: time.Minute,
+		Idle:    30 * time.Second,
+	}
+	ctx = rconfig.ToContext(ctx, &rconfig.Config{Kourier: cfg})
+
+	kubeclient := fake.NewSimpleClientset(
+		svc("servicens", "servicename"),
+		eps("servicens", "servicename"),
+	)
+
+	translator := NewIngressTranslator(
+		func(ns, name string) (*corev1.Secret, error) {
+			return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		func(ns, name string) (*corev1.Endpoints, error) {
+			return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		func(ns, name string) (*corev1.Service, error) {
+			return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		&pkgtest.FakeTracker{},
+	)
+
+	got, err := translator.translateIngress(ctx, ing("testspace", "testname", func(ing *v1alpha1.Ingress) {
+		ing.Annotations = map[string]string{
+			config.AnnotationPrefix + "route-timeout":           "10m",
+			config.AnnotationPrefix + "grpc-timeout-header-max": "1m",
+		}
+	}), false)
+	assert.NilError(t, err)
+	assert.NilError(t, got.validate())
+
+	action := got.externalVirtualHosts[0].Routes[0].GetRoute()
+	assert.Equal(t, action.GetTimeout().AsDuration(), 10*time.Minute)
+	assert.Equal(t, action.GetIdleTimeout().AsDuration(), 30*time.Second)
+	assert.Equal(t, action.GetMaxStreamDuration().GetGrpcTimeoutHeaderMax().AsDuration(), time.Minute)
+
+	got, err = translator.translateIngress(ctx, ing("testspace", "testname", func(ing *v1alpha1.Ingress) {
+		ing.Annotations = map[string]string{
+			config.AnnotationPrefix + "route-timeout": "soon",
+		}
+	}), false)
+	assert.NilError(t, err)
+	assert.Assert(t, errors.Is(got.validate(), ErrInvalidAnnotation))
+}
+
+func TestIngressTranslatorCORS(t *testing.T) {
+	withTLS := func(ing *v1alpha1.Ingress) {
+		ing.Spec.TLS = []v1alpha1.IngressTLS{{
+			Hosts:           []string{"foo.example.com"},
+			SecretNamespace: "secretns",
+			SecretName:      "secretname",
+		}}
+	}
+	withAnnotations := func(annotations map[string]string) func(*v1alpha1.Ingress) {
+		return func(ing *v1alpha1.Ingress) {
+			ing.Annotations = annotations
+		}
+	}
+	exactOrigin := func(origin string) *matcher.StringMatcher {
+		return &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Exact{Exact: origin}}
+	}
+
+	tests := []struct {
+		name    string
+		in      *v1alpha1.Ingress
+		cors    config.CORSConfig
+		want    *route.CorsPolicy
+		wantErr error
+	}{{
+		name: "CORS disabled",
+		in:   ing("testspace", "testname", withTLS),
+	}, {
+		name: "global CORS policy",
+		in:   ing("testspace", "testname", withTLS),
+		cors: config.CORSConfig{
+			AllowOrigins: []string{"https://example.com"},
+			AllowMethods: []string{"GET"},
+		},
+		want: &route.CorsPolicy{
+			AllowOriginStringMatch: []*matcher.StringMatcher{exactOrigin("https://example.com")},
+			AllowMethods:           "GET",
+		},
+	}, {
+		name: "CORS policy of the ingress",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.AnnotationPrefix + "cors-allow-origins":     "https://app.example.com",
+			config.AnnotationPrefix + "cors-allow-credentials": "true",
+		})),
+		want: &route.CorsPolicy{
+			AllowOriginStringMatch: []*matcher.StringMatcher{exactOrigin("https://app.example.com")},
+			AllowCredentials:       wrapperspb.Bool(true),
+		},
+	}, {
+		name: "invalid annotation",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.AnnotationPrefix + "cors-allow-origins": "regex:(",
+		})),
+		wantErr: ErrInvalidAnnotation,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := pkgtest.SetupFakeContext(t)
+			cfg := config.DefaultConfig()
+			cfg.CORS = test.cors
+			ctx = rconfig.ToContext(ctx, &rconfig.Config{Kourier: cfg})
+
+			kubeclient := fake.NewSimpleClientset(
+				svc("servicens", "servicename"),
+				eps("servicens", "servicename"),
+				secret,
+			)
+
+			translator := NewIngressTranslator(
+				func(ns, name string) (*corev1.Secret, error) {
+					return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Endpoints, error) {
+					return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Service, error) {
+					return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				&pkgtest.FakeTracker{},
+			)
+
+			got, err := translator.translateIngress(ctx, test.in, false)
+			assert.NilError(t, err)
+			if test.wantErr != nil {
+				assert.Assert(t, errors.Is(got.validate(), test.wantErr))
+				return
+			}
+			assert.NilError(t, got.validate())
+
+			for _, vhosts := range [][]*route.VirtualHost{got.externalVirtualHosts, got.externalTLSVirtualHosts, got.internalVirtualHosts} {
+				assert.Equal(t, len(vhosts), 1)
+				assert.DeepEqual(t, vhosts[0].Cors, test.want, protocmp.Transform())
+			}
+		})
+	}
+}
+
+func TestIngressTranslatorRetryPolicy(t *testing.T) {
+	withTLS := func(ing *v1alpha1.Ingress) {
+		ing.Spec.TLS = []v1alpha1.IngressTLS{{
+			Hosts:           []string{"foo.example.com"},
+			SecretNamespace: "secretns",
+			SecretName:      "secretname",
+		}}
+	}
+	withAnnotations := func(annotations map[string]string) func(*v1alpha1.Ingress) {
+		return func(ing *v1alpha1.Ingress) {
+			ing.Annotations = annotations
+		}
+	}
+
+	tests := []struct {
+		name    string
+		in      *v1alpha1.Ingress
+		retry   config.RetryConfig
+		want    *route.RetryPolicy
+		wantErr error
+	}{{
+		name:  "retries disabled",
+		in:    ing("testspace", "testname", withTLS),
+		retry: config.DefaultConfig().Retry,
+	}, {
+		name: "global retry policy",
+		in:   ing("testspace", "testname", withTLS),
+		retry: config.RetryConfig{
+			On:            []string{"connect-failure", "reset"},
+			Attempts:      2,
+			PerTryTimeout: time.Second,
+		},
+		want: &route.RetryPolicy{
+			RetryOn:       "connect-failure,reset",
+			NumRetries:    wrapperspb.UInt32(2),
+			PerTryTimeout: durationpb.New(time.Second),
+		},
+	}, {
+		name: "retry policy overridden by annotations",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.AnnotationPrefix + "retry-on":                     "retriable-status-codes",
+			config.AnnotationPrefix + "retry-retriable-status-codes": "503",
+		})),
+		retry: config.RetryConfig{
+			On:            []string{"connect-failure"},
+			Attempts:      2,
+			PerTryTimeout: time.Second,
+		},
+		want: &route.RetryPolicy{
+			RetryOn:              "retriable-status-codes",
+			NumRetries:           wrapperspb.UInt32(2),
+			PerTryTimeout:        durationpb.New(time.Second),
+			RetriableStatusCodes: []uint32{503},
+		},
+	}, {
+		name: "retries disabled by annotation",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.AnnotationPrefix + "retry-on": "",
+		})),
+		retry: config.RetryConfig{
+			On:       []string{"connect-failure"},
+			Attempts: 2,
+		},
+	}, {
+		name: "invalid annotation",
+		in: ing("testspace", "testname", withTLS, withAnnotations(map[string]string{
+			config.AnnotationPrefix + "retry-attempts": "0",
+		})),
+		retry:   config.DefaultConfig().Retry,
+		wantErr: ErrInvalidAnnotation,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := pkgtest.SetupFakeContext(t)
+			cfg := config.DefaultConfig()
+			cfg.Retry = test.retry
+			ctx = rconfig.ToContext(ctx, &rconfig.Config{Kourier: cfg})
+
+			kubeclient := fake.NewSimpleClientset(
+				svc("servicens", "servicename"),
+				eps("servicens", "servicename"),
+				secret,
+			)
+
+			translator := NewIngressTranslator(
+				func(ns, name string) (*corev1.Secret, error) {
+					return kubeclient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Endpoints, error) {
+					return kubeclient.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				func(ns, name string) (*corev1.Service, error) {
+					return kubeclient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+				},
+				&pkgtest.FakeTracker{},
+			)
+
+			got, err := translator.translateIngress(ctx, test.in, false)
+			assert.NilError(t, err)
+			if test.wantErr != nil {
+				assert.Assert(t, errors.Is(got.validate(), test.wantErr))
+				return
+			}
+			assert.NilError(t, got.validate())
+
+			// The policy applies to the plain HTTP and the HTTPS routes alike.
+			for _, vhost := range [][]*route.VirtualHost{got.externalVirtualHosts, got.externalTLSVirtualHosts} {
+				assert.Equal(t, len(vhost), 1)
+				assert.DeepEqual(t, vhost[0].Routes[0].GetRoute().GetRetryPolicy(), test.want, protocmp.Transform())
+			}
+		})
+	}
+}
+
 func ing(ns, name string, opts ...func(*v1alpha1.Ingress)) *v1alpha1.Ingress {
 	ingress := &v1alpha1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -820,10 +1983,25 @@ var lbEndpoints = []*endpoint.LbEndpoint{
 	envoy.NewLBEndpoint("5.5.5.5", 8080),
 }
 
+// testLoadAssignments returns the load assignments of the given clusters, all backed
+// by the endpoints returned by eps.
+func testLoadAssignments(clusterNames ...string) map[string]*loadAssignment {
+	res := make(map[string]*loadAssignment, len(clusterNames))
+	for _, name := range clusterNames {
+		res[name] = &loadAssignment{
+			assignment: envoy.NewClusterLoadAssignment(name, lbEndpoints),
+			targetPort: 8080,
+		}
+	}
+	return res
+}
+
 var (
-	cert       = []byte("cert")
-	privateKey = []byte("key")
-	secret     = &corev1.Secret{
+	// Certificates only have a precision of seconds.
+	certNotBefore    = time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	certNotAfter     = time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	cert, privateKey = generateCertificate([]string{"foo.example.com"}, certNotBefore, certNotAfter)
+	secret           = &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "secretns",
 			Name:      "secretname",
@@ -0,0 +1,162 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/net-kourier/pkg/config"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+)
+
+// pathMatchTypes holds the match types of the path-matches annotation by the path
+// of the routes they apply to. The one applying to all other routes is keyed by "".
+type pathMatchTypes map[string]envoy.PathMatchType
+
+// forPath returns the match type of the routes of the given path.
+func (t pathMatchTypes) forPath(path string) envoy.PathMatchType {
+	if matchType, ok := t[path]; ok {
+		return matchType
+	}
+	return t[""]
+}
+
+// routePath returns the path of the routes of the given ingress path, which
+// defaults to "/".
+func routePath(httpPath v1alpha1.HTTPIngressPath) string {
+	if httpPath.Path == "" {
+		return "/"
+	}
+	return httpPath.Path
+}
+
+// ingressPaths returns the paths of the routes of the given ingress.
+func ingressPaths(ingress *v1alpha1.Ingress) sets.String {
+	paths := sets.NewString()
+	for _, rule := range ingress.Spec.Rules {
+		for _, httpPath := range rule.HTTP.Paths {
+			paths.Insert(routePath(httpPath))
+		}
+	}
+	return paths
+}
+
+// annotatedPathMatchTypes returns the match types of the path-matches annotation of
+// the given ingress.
+func annotatedPathMatchTypes(ingress *v1alpha1.Ingress) (pathMatchTypes, error) {
+	annotation, ok := ingress.Annotations[config.PathMatchesAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	matchTypes, err := parsePathMatchTypes(annotation, ingressPaths(ingress))
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidAnnotation, config.PathMatchesAnnotationKey, err)
+	}
+	return matchTypes, nil
+}
+
+// parsePathMatchTypes parses the value of the path-matches annotation. Every line
+// holds the match type of a path as
+//
+//	[path] type
+//
+// where type is prefix, exact or regex. A type without a path applies to all
+// paths without a type of their own. The paths must be among the given ones, and
+// the ones matched as regex must be valid regular expressions.
+func parsePathMatchTypes(annotation string, paths sets.String) (pathMatchTypes, error) {
+	matchTypes := make(pathMatchTypes)
+	for i, line := range strings.Split(annotation, "\n") {
+		fields := strings.Fields(line)
+		var path, matchType string
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			matchType = fields[0]
+		case 2:
+			path, matchType = fields[0], fields[1]
+			if !paths.Has(path) {
+				return nil, fmt.Errorf("line %d: the ingress has no path %s", i+1, path)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: expected [path] type", i+1)
+		}
+
+		switch envoy.PathMatchType(matchType) {
+		case envoy.PathMatchPrefix, envoy.PathMatchExact, envoy.PathMatchRegex:
+			matchTypes[path] = envoy.PathMatchType(matchType)
+		default:
+			return nil, fmt.Errorf("line %d: unsupported match type %q", i+1, matchType)
+		}
+	}
+
+	for _, path := range paths.List() {
+		if matchTypes.forPath(path) != envoy.PathMatchRegex {
+			continue
+		}
+		// Go's regular expressions share the RE2 syntax of Envoy's safe regexes.
+		if _, err := regexp.Compile(path); err != nil {
+			return nil, fmt.Errorf("path %s is not a valid regular expression: %w", path, err)
+		}
+	}
+	return matchTypes, nil
+}
+
+// sortPaths returns the given ingress paths ordered by the specificity of their
+// routes, as the first route matching a request wins. Exact matches come first,
+// then prefixes from the longest to the shortest and regular expressions last.
+// Paths of equal specificity with more header matches, including the given
+// annotated ones, come first, the order of the ingress is kept otherwise. The
+// paths are only reordered if the ingress has match types, i.e. the path-matches
+// annotation, so that the routes of other ingresses keep their order.
+func sortPaths(httpPaths []v1alpha1.HTTPIngressPath, matchTypes pathMatchTypes, matchers headerMatchers) []v1alpha1.HTTPIngressPath {
+	if matchTypes == nil {
+		return httpPaths
+	}
+
+	headerCount := func(httpPath v1alpha1.HTTPIngressPath) int {
+		return len(httpPath.Headers) + len(matchers.forPath(routePath(httpPath)))
+	}
+	rank := func(httpPath v1alpha1.HTTPIngressPath) int {
+		switch matchTypes.forPath(routePath(httpPath)) {
+		case envoy.PathMatchExact:
+			return 0
+		case envoy.PathMatchRegex:
+			return 2
+		default:
+			return 1
+		}
+	}
+
+	sorted := make([]v1alpha1.HTTPIngressPath, len(httpPaths))
+	copy(sorted, httpPaths)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if ri, rj := rank(sorted[i]), rank(sorted[j]); ri != rj {
+			return ri < rj
+		} else if pi, pj := routePath(sorted[i]), routePath(sorted[j]); ri == 1 && len(pi) != len(pj) {
+			return len(pi) > len(pj)
+		}
+		return headerCount(sorted[i]) > headerCount(sorted[j])
+	})
+	return sorted
+}
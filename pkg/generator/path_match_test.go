@@ -0,0 +1,124 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+)
+
+func TestParsePathMatchTypes(t *testing.T) {
+	paths := sets.NewString("/", "/api", "/v[0-9]+/.*")
+
+	tests := []struct {
+		name       string
+		annotation string
+		want       pathMatchTypes
+		wantErr    bool
+	}{{
+		name: "empty",
+		want: pathMatchTypes{},
+	}, {
+		name: "types per path and default",
+		annotation: `
+			prefix
+			/api exact
+			/v[0-9]+/.* regex
+		`,
+		want: pathMatchTypes{
+			"":            envoy.PathMatchPrefix,
+			"/api":        envoy.PathMatchExact,
+			"/v[0-9]+/.*": envoy.PathMatchRegex,
+		},
+	}, {
+		name:       "unknown path",
+		annotation: "/apiv2 exact",
+		wantErr:    true,
+	}, {
+		name:       "unsupported match type",
+		annotation: "/api glob",
+		wantErr:    true,
+	}, {
+		name:       "too many fields",
+		annotation: "/api exact please",
+		wantErr:    true,
+	}, {
+		name:       "all paths matched as regular expressions",
+		annotation: "regex",
+		want:       pathMatchTypes{"": envoy.PathMatchRegex},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parsePathMatchTypes(test.annotation, paths)
+			if test.wantErr {
+				assert.Assert(t, err != nil, "got match types %v", got)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got, test.want)
+		})
+	}
+
+	_, err := parsePathMatchTypes("regex", sets.NewString("/api(", "/"))
+	assert.ErrorContains(t, err, "not a valid regular expression")
+}
+
+func TestSortPaths(t *testing.T) {
+	withHeader := map[string]v1alpha1.HeaderMatch{"x-tag": {Exact: "beta"}}
+	httpPaths := []v1alpha1.HTTPIngressPath{
+		{Path: ""},
+		{Path: "/v[0-9]+"},
+		{Path: "/api"},
+		{Path: "/api/v2"},
+		{Path: "/api", Headers: withHeader},
+		{Path: "/status"},
+	}
+	matchTypes := pathMatchTypes{
+		"/v[0-9]+": envoy.PathMatchRegex,
+		"/status":  envoy.PathMatchExact,
+	}
+
+	sorted := func(matchTypes pathMatchTypes, matchers headerMatchers) []string {
+		var got []string
+		for _, httpPath := range sortPaths(httpPaths, matchTypes, matchers) {
+			got = append(got, routePath(httpPath)+" "+httpPath.Headers["x-tag"].Exact)
+		}
+		return got
+	}
+
+	want := []string{"/status ", "/api/v2 ", "/api beta", "/api ", "/ ", "/v[0-9]+ "}
+	assert.DeepEqual(t, sorted(matchTypes, nil), want)
+
+	// The paths of the ingress are left untouched.
+	assert.Equal(t, httpPaths[0].Path, "")
+	assert.Equal(t, httpPaths[1].Path, "/v[0-9]+")
+
+	// The paths are kept in order without match types.
+	want = []string{"/ ", "/v[0-9]+ ", "/api ", "/api/v2 ", "/api beta", "/status "}
+	assert.DeepEqual(t, sorted(nil, nil), want)
+
+	// Annotated header matchers count as the ones of the ingress.
+	matchTypes = pathMatchTypes{"": envoy.PathMatchRegex}
+	matchers := headerMatchers{"/status": {{Name: "x-debug"}, {Name: "x-user"}}}
+	want = []string{"/status ", "/api beta", "/ ", "/v[0-9]+ ", "/api ", "/api/v2 "}
+	assert.DeepEqual(t, sorted(matchTypes, matchers), want)
+}
@@ -17,7 +17,9 @@ limitations under the License.
 package generator
 
 import (
-	"k8s.io/apimachinery/pkg/types"
+	"sort"
+	"strings"
+
 	"k8s.io/apimachinery/pkg/util/sets"
 	envoy "knative.dev/net-kourier/pkg/envoy/api"
 )
@@ -33,17 +35,33 @@ type dedupedSNIMatch struct {
 // an existing list of sniMatches to avoid allocating a lot of configuration memory for
 // tls configurations that are essentially equal.
 // SNIMatches are deduplicated and collapsed by collapsing the list of hosts of all
-// matches that have the same certificate source (i.e. reference the same Secret).
-type sniMatches map[types.NamespacedName]*dedupedSNIMatch
+// matches that have the same certificate source (i.e. reference the same Secret) and
+// verify clients the same way.
+type sniMatches map[string]*dedupedSNIMatch
+
+// sniMatchKey returns the key of the matches that can be collapsed with the given one.
+func sniMatchKey(match *envoy.SNIMatch) string {
+	key := strings.Join(match.SecretNames(), ",")
+	if validation := match.ClientValidation; validation != nil {
+		// Neither names nor SANs can contain a newline, so this is unambiguous.
+		key += "\n" + validation.CASource.String() + "\n" + strings.Join(validation.SubjectAltNames, "\n")
+	}
+	return key
+}
 
 func (s sniMatches) consume(match *envoy.SNIMatch) {
-	state := s[match.CertSource]
+	key := sniMatchKey(match)
+	state := s[key]
 	if state == nil {
+		// Copy the match as its hosts are extended below, which must not leak into the
+		// translated ingress the match belongs to.
+		copied := *match
+		copied.Hosts = append(make([]string, 0, len(match.Hosts)), match.Hosts...)
 		state = &dedupedSNIMatch{
-			sniMatch: match,
+			sniMatch: &copied,
 			hosts:    sets.NewString(match.Hosts...),
 		}
-		s[match.CertSource] = state
+		s[key] = state
 		return
 	}
 
@@ -55,15 +73,75 @@ func (s sniMatches) consume(match *envoy.SNIMatch) {
 	}
 }
 
-// list returns the deduplicated and collapsed list of SNIMatches.
+// list returns the deduplicated and collapsed list of SNIMatches, sorted by their
+// certificate source first.
 func (s sniMatches) list() []*envoy.SNIMatch {
 	if len(s) == 0 {
 		return nil
 	}
 
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
 	matches := make([]*envoy.SNIMatch, 0, len(s))
-	for _, match := range s {
-		matches = append(matches, match.sniMatch)
+	for _, key := range keys {
+		matches = append(matches, s[key].sniMatch)
 	}
 	return matches
 }
+
+// mergeSNIMatches merges the given matches, so that every host is part of a single
+// match serving the certificates of all the given matches it's part of. This allows
+// to serve e.g. an RSA and an ECDSA certificate for the same hosts, and avoids
+// conflicting filter chains for hosts that are part of multiple matches.
+//
+// The given matches must have no additional certificates yet. Hosts served the same
+// certificates are kept together, in the order they're first seen.
+func mergeSNIMatches(matches []*envoy.SNIMatch) []*envoy.SNIMatch {
+	var hosts []string
+	hostMatches := make(map[string][]*envoy.SNIMatch)
+	for _, match := range matches {
+		for _, host := range match.Hosts {
+			if _, ok := hostMatches[host]; !ok {
+				hosts = append(hosts, host)
+			}
+			hostMatches[host] = append(hostMatches[host], match)
+		}
+	}
+
+	merged := make([]*envoy.SNIMatch, 0, len(matches))
+	bySecrets := make(map[string]*envoy.SNIMatch, len(matches))
+	for _, host := range hosts {
+		first := hostMatches[host][0]
+		match := &envoy.SNIMatch{
+			CertSource:       first.CertSource,
+			CertificateChain: first.CertificateChain,
+			PrivateKey:       first.PrivateKey,
+			ClientValidation: first.ClientValidation,
+		}
+		sources := sets.NewString(first.CertSource.String())
+		for _, other := range hostMatches[host][1:] {
+			if !sources.Has(other.CertSource.String()) {
+				sources.Insert(other.CertSource.String())
+				match.AdditionalCertificates = append(match.AdditionalCertificates, envoy.Certificate{
+					CertSource:       other.CertSource,
+					CertificateChain: other.CertificateChain,
+					PrivateKey:       other.PrivateKey,
+				})
+			}
+		}
+
+		key := strings.Join(match.SecretNames(), ",")
+		if existing := bySecrets[key]; existing != nil {
+			existing.Hosts = append(existing.Hosts, host)
+			continue
+		}
+		match.Hosts = []string{host}
+		bySecrets[key] = match
+		merged = append(merged, match)
+	}
+	return merged
+}
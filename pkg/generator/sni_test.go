@@ -17,7 +17,6 @@ limitations under the License.
 package generator
 
 import (
-	"sort"
 	"testing"
 
 	"gotest.tools/v3/assert"
@@ -90,6 +89,54 @@ func TestDeduplication(t *testing.T) {
 			Hosts:      []string{"foo2", "bar2"},
 			CertSource: s2,
 		}},
+	}, {
+		name: "same secret, different client validation",
+		in: []*envoy.SNIMatch{{
+			Hosts:      []string{"foo"},
+			CertSource: s1,
+		}, {
+			Hosts:            []string{"bar"},
+			CertSource:       s1,
+			ClientValidation: &envoy.ClientValidation{CASource: s2},
+		}, {
+			Hosts:            []string{"baz"},
+			CertSource:       s1,
+			ClientValidation: &envoy.ClientValidation{CASource: s2, SubjectAltNames: []string{"client"}},
+		}, {
+			Hosts:            []string{"gna"},
+			CertSource:       s1,
+			ClientValidation: &envoy.ClientValidation{CASource: s2},
+		}},
+		out: []*envoy.SNIMatch{{
+			Hosts:      []string{"foo"},
+			CertSource: s1,
+		}, {
+			Hosts:            []string{"bar", "gna"},
+			CertSource:       s1,
+			ClientValidation: &envoy.ClientValidation{CASource: s2},
+		}, {
+			Hosts:            []string{"baz"},
+			CertSource:       s1,
+			ClientValidation: &envoy.ClientValidation{CASource: s2, SubjectAltNames: []string{"client"}},
+		}},
+	}, {
+		name: "same secret, different additional certificates",
+		in: []*envoy.SNIMatch{{
+			Hosts:      []string{"foo"},
+			CertSource: s1,
+		}, {
+			Hosts:                  []string{"bar"},
+			CertSource:             s1,
+			AdditionalCertificates: []envoy.Certificate{{CertSource: s2}},
+		}},
+		out: []*envoy.SNIMatch{{
+			Hosts:      []string{"foo"},
+			CertSource: s1,
+		}, {
+			Hosts:                  []string{"bar"},
+			CertSource:             s1,
+			AdditionalCertificates: []envoy.Certificate{{CertSource: s2}},
+		}},
 	}}
 
 	for _, test := range tests {
@@ -100,16 +147,108 @@ func TestDeduplication(t *testing.T) {
 			}
 			got := matches.list()
 
-			// Sort the lists as we go via maps in the implementatio, so the order is
-			// not guaranteed.
-			sort.Slice(test.out, func(i, j int) bool {
-				return test.out[i].CertSource.String() < test.out[j].CertSource.String()
-			})
-			sort.Slice(got, func(i, j int) bool {
-				return got[i].CertSource.String() < got[j].CertSource.String()
-			})
-
+			// The list is sorted by certificate source.
 			assert.DeepEqual(t, test.out, got)
 		})
 	}
 }
+
+func TestDeduplicationDoesNotModifyInput(t *testing.T) {
+	source := types.NamespacedName{
+		Namespace: "secret-ns",
+		Name:      "secret",
+	}
+	first := &envoy.SNIMatch{
+		Hosts:      []string{"foo"},
+		CertSource: source,
+	}
+	second := &envoy.SNIMatch{
+		Hosts:      []string{"bar"},
+		CertSource: source,
+	}
+
+	matches := sniMatches{}
+	matches.consume(first)
+	matches.consume(second)
+
+	assert.DeepEqual(t, []string{"foo", "bar"}, matches.list()[0].Hosts)
+	assert.DeepEqual(t, []string{"foo"}, first.Hosts)
+}
+
+func TestMergeSNIMatches(t *testing.T) {
+	rsa := &envoy.SNIMatch{
+		Hosts:            []string{"foo", "bar"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "rsa"},
+		CertificateChain: []byte("rsa-cert"),
+		PrivateKey:       []byte("rsa-key"),
+	}
+	ecdsa := &envoy.SNIMatch{
+		Hosts:            []string{"foo", "bar"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "ecdsa"},
+		CertificateChain: []byte("ecdsa-cert"),
+		PrivateKey:       []byte("ecdsa-key"),
+	}
+	other := &envoy.SNIMatch{
+		Hosts:            []string{"bar", "baz"},
+		CertSource:       types.NamespacedName{Namespace: "secretns", Name: "other"},
+		CertificateChain: []byte("other-cert"),
+		PrivateKey:       []byte("other-key"),
+	}
+	asAdditional := func(match *envoy.SNIMatch) envoy.Certificate {
+		return envoy.Certificate{
+			CertSource:       match.CertSource,
+			CertificateChain: match.CertificateChain,
+			PrivateKey:       match.PrivateKey,
+		}
+	}
+
+	tests := []struct {
+		name string
+		in   []*envoy.SNIMatch
+		out  []*envoy.SNIMatch
+	}{{
+		name: "distinct hosts",
+		in:   []*envoy.SNIMatch{rsa},
+		out:  []*envoy.SNIMatch{rsa},
+	}, {
+		name: "same hosts",
+		in:   []*envoy.SNIMatch{ecdsa, rsa},
+		out: []*envoy.SNIMatch{{
+			Hosts:                  []string{"foo", "bar"},
+			CertSource:             ecdsa.CertSource,
+			CertificateChain:       ecdsa.CertificateChain,
+			PrivateKey:             ecdsa.PrivateKey,
+			AdditionalCertificates: []envoy.Certificate{asAdditional(rsa)},
+		}},
+	}, {
+		name: "same secret twice",
+		in:   []*envoy.SNIMatch{rsa, rsa},
+		out:  []*envoy.SNIMatch{rsa},
+	}, {
+		name: "overlapping hosts",
+		in:   []*envoy.SNIMatch{rsa, other},
+		out: []*envoy.SNIMatch{{
+			Hosts:            []string{"foo"},
+			CertSource:       rsa.CertSource,
+			CertificateChain: rsa.CertificateChain,
+			PrivateKey:       rsa.PrivateKey,
+		}, {
+			Hosts:                  []string{"bar"},
+			CertSource:             rsa.CertSource,
+			CertificateChain:       rsa.CertificateChain,
+			PrivateKey:             rsa.PrivateKey,
+			AdditionalCertificates: []envoy.Certificate{asAdditional(other)},
+		}, {
+			Hosts:            []string{"baz"},
+			CertSource:       other.CertSource,
+			CertificateChain: other.CertificateChain,
+			PrivateKey:       other.PrivateKey,
+		}},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.DeepEqual(t, test.out, mergeSNIMatches(test.in))
+		})
+	}
+}
@@ -54,7 +54,7 @@ func readyRoute() *route.Route {
 	cluster := envoy.NewWeightedCluster("service_stats", 100, nil)
 	var wrs []*route.WeightedCluster_ClusterWeight
 	wrs = append(wrs, cluster)
-	route := envoy.NewRoute("gateway_ready", nil, "/ready", wrs, 1*time.Second, nil, "")
+	route := envoy.NewRoute("gateway_ready", nil, envoy.PathMatch{Path: "/ready"}, wrs, envoy.RouteTimeouts{Request: 1 * time.Second}, nil, "", nil)
 
 	return route
 }
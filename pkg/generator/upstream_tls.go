@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/net-kourier/pkg/config"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
+	network "knative.dev/networking/pkg"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+	"knative.dev/pkg/system"
+)
+
+// upstreamTLSPortName is the name of the service ports TLS is always originated to.
+const upstreamTLSPortName = "https"
+
+// upstreamTLS returns the certificates of the TLS connections to the endpoints of
+// the services of the given ingress. The CA bundle is taken from the activator-ca of
+// config-network if config-kourier doesn't specify one.
+//
+// Errors the ingress is rejected for wrap ErrCertificateInvalid. The secrets are
+// tracked even if they don't exist (yet), so the ingress is translated again once
+// they do.
+func (translator *IngressTranslator) upstreamTLS(ingress *v1alpha1.Ingress, cfg *config.Kourier, networkCfg *config.Network) (*envoy.UpstreamTLS, error) {
+	var caSecret types.NamespacedName
+	var sans []string
+	var matchSNI bool
+	if cfg.UpstreamCASecret != nil {
+		caSecret = *cfg.UpstreamCASecret
+		// Any certificate signed by the CA would be accepted otherwise, whatever
+		// service it was issued for.
+		sans = cfg.UpstreamSubjectAltNames
+		matchSNI = true
+	} else if networkCfg.ActivatorCA != "" {
+		// The certificates of internal encryption are issued for the activator SAN
+		// rather than the hostnames of the services.
+		caSecret = types.NamespacedName{Namespace: system.Namespace(), Name: networkCfg.ActivatorCA}
+		if networkCfg.ActivatorSAN != "" {
+			sans = []string{networkCfg.ActivatorSAN}
+		}
+	} else {
+		// Rather reject the ingress than sending its requests in plain text or to
+		// endpoints that can't be verified.
+		return nil, fmt.Errorf("%w: upstream TLS requires upstream-ca-secret in %s or activator-ca in %s",
+			ErrCertificateInvalid, config.ConfigName, network.ConfigName)
+	}
+
+	if err := trackSecret(translator.tracker, caSecret.Namespace, caSecret.Name, ingress); err != nil {
+		return nil, err
+	}
+	secret, err := translator.secretGetter(caSecret.Namespace, caSecret.Name)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: upstream CA bundle secret %s not found", ErrCertificateInvalid, caSecret)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch secret: %w", err)
+	}
+	if err := validateCABundle(secret.Data[caFieldInSecret]); err != nil {
+		return nil, fmt.Errorf("%w in upstream CA bundle secret %s: %v", ErrCertificateInvalid, caSecret, err)
+	}
+	tls := &envoy.UpstreamTLS{
+		CASource:        caSecret,
+		CABundle:        secret.Data[caFieldInSecret],
+		SubjectAltNames: sans,
+		MatchSNI:        matchSNI,
+	}
+
+	if cfg.UpstreamClientCertsSecret == nil {
+		return tls, nil
+	}
+
+	certSecret := *cfg.UpstreamClientCertsSecret
+	if err := trackSecret(translator.tracker, certSecret.Namespace, certSecret.Name, ingress); err != nil {
+		return nil, err
+	}
+	secret, err = translator.secretGetter(certSecret.Namespace, certSecret.Name)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: upstream client certificate secret %s not found", ErrCertificateInvalid, certSecret)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch secret: %w", err)
+	}
+	certificateChain, privateKey := secret.Data[certFieldInSecret], secret.Data[keyFieldInSecret]
+	if err := validateCertificate(certificateChain, privateKey, nil, time.Now()); err != nil {
+		return nil, fmt.Errorf("%w in upstream client certificate secret %s: %v", ErrCertificateInvalid, certSecret, err)
+	}
+	tls.ClientCertificate = &envoy.Certificate{
+		CertSource:       certSecret,
+		CertificateChain: certificateChain,
+		PrivateKey:       privateKey,
+	}
+	return tls, nil
+}
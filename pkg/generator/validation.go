@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"fmt"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
+)
+
+// validatable is implemented by all the generated Envoy types, see the
+// *.pb.validate.go files.
+type validatable interface {
+	Validate() error
+}
+
+// validate checks the resources generated for the ingress against the constraints
+// of the Envoy API. The result is remembered, as the translation doesn't change.
+func (translated *translatedIngress) validate() error {
+	if !translated.validated {
+		translated.validationErr = translated.doValidate()
+		translated.validated = true
+	}
+	return translated.validationErr
+}
+
+func (translated *translatedIngress) doValidate() error {
+	if translated.certificateErr != nil {
+		return translated.certificateErr
+	}
+	if translated.annotationErr != nil {
+		return translated.annotationErr
+	}
+	for _, cluster := range translated.clusters {
+		if err := cluster.Validate(); err != nil {
+			return fmt.Errorf("invalid cluster %q: %w", cluster.Name, err)
+		}
+	}
+	for name, loadAssignment := range translated.loadAssignments {
+		if err := loadAssignment.assignment.Validate(); err != nil {
+			return fmt.Errorf("invalid endpoints of cluster %q: %w", name, err)
+		}
+	}
+	for _, secrets := range translated.upstreamSecrets {
+		for _, secret := range secrets {
+			if err := secret.Validate(); err != nil {
+				return fmt.Errorf("invalid secret %q: %w", secret.Name, err)
+			}
+		}
+	}
+	for _, vhosts := range [][]*route.VirtualHost{
+		translated.externalVirtualHosts,
+		translated.externalTLSVirtualHosts,
+		translated.internalVirtualHosts,
+	} {
+		for _, vhost := range vhosts {
+			if err := vhost.Validate(); err != nil {
+				return fmt.Errorf("invalid virtual host %q: %w", vhost.Name, err)
+			}
+		}
+	}
+	for _, match := range translated.allSNIMatches() {
+		name := envoy.SecretName(match.CertSource)
+		if err := envoy.NewSecret(name, match.CertificateChain, match.PrivateKey).Validate(); err != nil {
+			return fmt.Errorf("invalid secret %q: %w", name, err)
+		}
+		for _, cert := range match.AdditionalCertificates {
+			name := envoy.SecretName(cert.CertSource)
+			if err := envoy.NewSecret(name, cert.CertificateChain, cert.PrivateKey).Validate(); err != nil {
+				return fmt.Errorf("invalid secret %q: %w", name, err)
+			}
+		}
+		if match.ClientValidation != nil {
+			name := envoy.ValidationSecretName(match.ClientValidation.CASource)
+			if err := envoy.NewValidationSecret(name, match.ClientValidation.CABundle).Validate(); err != nil {
+				return fmt.Errorf("invalid secret %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSnapshot checks all the resources of the snapshot against the constraints
+// of the Envoy API and verifies that the resources referenced by other resources are
+// part of the snapshot.
+func validateSnapshot(snapshot *cache.Snapshot) error {
+	for _, resources := range snapshot.Resources {
+		for name, item := range resources.Items {
+			if err := validateResource(item.Resource); err != nil {
+				return fmt.Errorf("invalid resource %q: %w", name, err)
+			}
+		}
+	}
+
+	if err := snapshot.Consistent(); err != nil {
+		return fmt.Errorf("inconsistent snapshot: %w", err)
+	}
+	return nil
+}
+
+func validateResource(resource cachetypes.Resource) error {
+	if v, ok := resource.(validatable); ok {
+		return v.Validate()
+	}
+	return nil
+}
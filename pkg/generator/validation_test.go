@@ -0,0 +1,72 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"testing"
+	"time"
+
+	v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"gotest.tools/v3/assert"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
+)
+
+func TestValidateSnapshot(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources map[resource.Type][]cachetypes.Resource
+		wantErr   string
+	}{{
+		name: "valid",
+		resources: map[resource.Type][]cachetypes.Resource{
+			resource.ClusterType: {&v3.Cluster{Name: "cluster"}},
+		},
+	}, {
+		name: "invalid resource",
+		resources: map[resource.Type][]cachetypes.Resource{
+			resource.RouteType: {&route.RouteConfiguration{
+				Name:         "routes",
+				VirtualHosts: []*route.VirtualHost{{Name: "vhost"}},
+			}},
+		},
+		wantErr: `invalid resource "routes"`,
+	}, {
+		name: "missing endpoints",
+		resources: map[resource.Type][]cachetypes.Resource{
+			resource.ClusterType: {envoy.NewEDSCluster("cluster", time.Second, false)},
+		},
+		wantErr: "inconsistent snapshot",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			snapshot, err := cache.NewSnapshot("", test.resources)
+			assert.NilError(t, err)
+
+			err = validateSnapshot(&snapshot)
+			if test.wantErr == "" {
+				assert.NilError(t, err)
+			} else {
+				assert.ErrorContains(t, err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	envoy "knative.dev/net-kourier/pkg/envoy/api"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+	"knative.dev/pkg/logging"
+)
+
+// wildcardSNIMatches matches the hosts of the external rules of the given ingress
+// that have no certificate in its TLS section against the certificates of the given
+// wildcard secrets. Each host is served the first valid certificate covering it.
+// Hosts not covered by any of them are left to the default certificate.
+func (translator *IngressTranslator) wildcardSNIMatches(ctx context.Context, ingress *v1alpha1.Ingress, secretRefs []types.NamespacedName) ([]*envoy.SNIMatch, []CertificateInfo, error) {
+	if len(secretRefs) == 0 {
+		return nil, nil, nil
+	}
+	logger := logging.FromContext(ctx)
+
+	seen := sets.NewString()
+	for _, ingressTLS := range ingress.Spec.TLS {
+		seen.Insert(ingressTLS.Hosts...)
+	}
+	var hosts []string
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Visibility != v1alpha1.IngressVisibilityExternalIP {
+			continue
+		}
+		for _, host := range rule.Hosts {
+			if !seen.Has(host) {
+				seen.Insert(host)
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	var (
+		matches      []*envoy.SNIMatch
+		certificates []CertificateInfo
+		now          = time.Now()
+	)
+	for _, secretRef := range secretRefs {
+		if len(hosts) == 0 {
+			break
+		}
+
+		// Track the secret even if it doesn't exist (yet), so the ingress is translated
+		// again once it does.
+		if err := trackSecret(translator.tracker, secretRef.Namespace, secretRef.Name, ingress); err != nil {
+			return nil, nil, err
+		}
+		secret, err := translator.secretGetter(secretRef.Namespace, secretRef.Name)
+		if apierrors.IsNotFound(err) {
+			logger.Warnf("Wildcard certificate secret %s not found", secretRef)
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch secret: %w", err)
+		}
+
+		certificateChain, privateKey := secret.Data[certFieldInSecret], secret.Data[keyFieldInSecret]
+		if err := validateCertificate(certificateChain, privateKey, nil, now); err != nil {
+			logger.Warnw(fmt.Sprintf("Ignoring invalid wildcard certificate in secret %s", secretRef), "error", err)
+			continue
+		}
+		leaf, err := parseLeafCertificate(certificateChain)
+		if err != nil {
+			continue
+		}
+
+		var covered, uncovered []string
+		for _, host := range hosts {
+			if leaf.VerifyHostname(host) == nil {
+				covered = append(covered, host)
+			} else {
+				uncovered = append(uncovered, host)
+			}
+		}
+		hosts = uncovered
+		if len(covered) == 0 {
+			continue
+		}
+
+		if info, err := newCertificateInfo(secretRef, certificateChain, covered); err == nil {
+			certificates = append(certificates, info)
+		}
+		matches = append(matches, &envoy.SNIMatch{
+			Hosts:            covered,
+			CertSource:       secretRef,
+			CertificateChain: certificateChain,
+			PrivateKey:       privateKey,
+		})
+	}
+	return matches, certificates, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	envoy "knative.dev/net-kourier/pkg/envoy/server"
+	"knative.dev/net-kourier/pkg/generator"
+)
+
+// pushedIngress is the first snapshot pushed with the given generation of an ingress.
+type pushedIngress struct {
+	generation int64
+	seq        uint64
+}
+
+// ingressAcks keeps track of the snapshots containing each ingress, to determine
+// whether all gateways ACKed the current generation of an ingress.
+type ingressAcks struct {
+	sessions *envoy.SessionTracker
+	// enqueue is called for ingresses which were waiting for an ACK once they are
+	// ACKed by all gateways.
+	enqueue func(types.NamespacedName)
+
+	mu       sync.Mutex
+	pushed   map[types.NamespacedName]pushedIngress
+	awaiting map[types.NamespacedName]struct{}
+}
+
+func newIngressAcks(sessions *envoy.SessionTracker, enqueue func(types.NamespacedName)) *ingressAcks {
+	return &ingressAcks{
+		sessions: sessions,
+		enqueue:  enqueue,
+		pushed:   make(map[types.NamespacedName]pushedIngress),
+		awaiting: make(map[types.NamespacedName]struct{}),
+	}
+}
+
+// snapshotPushed records the ingresses contained in the snapshot with the given
+// sequence number.
+func (a *ingressAcks) snapshotPushed(seq uint64, snapshot generator.Snapshot) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, generation := range snapshot.Ingresses {
+		// Later snapshots contain the same generation of the ingress, so the first one
+		// containing it is what has to be ACKed.
+		if pushed, ok := a.pushed[key]; !ok || pushed.generation != generation {
+			a.pushed[key] = pushedIngress{generation: generation, seq: seq}
+		}
+	}
+	for key := range a.pushed {
+		if _, ok := snapshot.Ingresses[key]; !ok {
+			delete(a.pushed, key)
+		}
+	}
+}
+
+// isAcked returns true if all gateways ACKed a snapshot containing the given
+// generation of the ingress. If not, the ingress is enqueued once they did.
+func (a *ingressAcks) isAcked(key types.NamespacedName, generation int64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if pushed, ok := a.pushed[key]; ok && pushed.generation == generation && a.sessions.Acked(pushed.seq) {
+		delete(a.awaiting, key)
+		return true
+	}
+	a.awaiting[key] = struct{}{}
+	return false
+}
+
+// forget stops tracking the given ingress.
+func (a *ingressAcks) forget(key types.NamespacedName) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.awaiting, key)
+}
+
+// onAcked enqueues all awaiting ingresses, which were ACKed by all gateways.
+func (a *ingressAcks) onAcked() {
+	a.mu.Lock()
+	var acked []types.NamespacedName
+	for key := range a.awaiting {
+		if pushed, ok := a.pushed[key]; ok && a.sessions.Acked(pushed.seq) {
+			acked = append(acked, key)
+			delete(a.awaiting, key)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, key := range acked {
+		a.enqueue(key)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"testing"
+
+	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"gotest.tools/v3/assert"
+	"k8s.io/apimachinery/pkg/types"
+	envoy "knative.dev/net-kourier/pkg/envoy/server"
+	"knative.dev/net-kourier/pkg/generator"
+)
+
+func TestIngressAcks(t *testing.T) {
+	var enqueued []types.NamespacedName
+	var acks *ingressAcks
+	sessions := envoy.NewSessionTracker(func() { acks.onAcked() })
+	acks = newIngressAcks(sessions, func(key types.NamespacedName) {
+		enqueued = append(enqueued, key)
+	})
+
+	ing := types.NamespacedName{Namespace: "ns", Name: "ing"}
+	stream := envoy.StreamKey{ID: 1}
+	sessions.StreamOpened(stream)
+
+	push := func(routesVersion string, ingresses map[types.NamespacedName]int64) {
+		var snapshot cache.Snapshot
+		snapshot.Resources[cachetypes.Route] = cache.Resources{Version: routesVersion}
+		acks.snapshotPushed(sessions.SnapshotPushed(snapshot), generator.Snapshot{
+			Snapshot:  snapshot,
+			Ingresses: ingresses,
+		})
+	}
+
+	// Not pushed yet.
+	assert.Assert(t, !acks.isAcked(ing, 1))
+
+	// Even without gateways connected.
+	sessions.StreamClosed(stream)
+	assert.Assert(t, !acks.isAcked(ing, 1))
+	sessions.StreamOpened(stream)
+
+	push("v1", map[types.NamespacedName]int64{ing: 1})
+	assert.Assert(t, !acks.isAcked(ing, 1))
+
+	sessions.ResponseSent(stream, resource.RouteType, "1", "v1")
+	sessions.RequestReceived(stream, "node", resource.RouteType, "1", nil)
+	assert.DeepEqual(t, enqueued, []types.NamespacedName{ing})
+	assert.Assert(t, acks.isAcked(ing, 1))
+
+	// Snapshots not changing the ingress don't affect it.
+	push("v2", map[types.NamespacedName]int64{ing: 1})
+	assert.Assert(t, acks.isAcked(ing, 1))
+
+	// A newer generation has to be ACKed again.
+	assert.Assert(t, !acks.isAcked(ing, 2))
+	push("v3", map[types.NamespacedName]int64{ing: 2})
+	assert.Assert(t, !acks.isAcked(ing, 2))
+	sessions.ResponseSent(stream, resource.RouteType, "2", "v3")
+	sessions.RequestReceived(stream, "", resource.RouteType, "2", nil)
+	assert.DeepEqual(t, enqueued, []types.NamespacedName{ing, ing})
+	assert.Assert(t, acks.isAcked(ing, 2))
+
+	// Removed ingresses are not ACKed anymore.
+	push("v4", nil)
+	assert.Assert(t, !acks.isAcked(ing, 2))
+	assert.DeepEqual(t, enqueued, []types.NamespacedName{ing, ing})
+}
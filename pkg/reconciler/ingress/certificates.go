@@ -0,0 +1,236 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/net-kourier/pkg/generator"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+)
+
+const (
+	// certificateExpiryWarning is how long before a certificate expires events are
+	// emitted on the ingresses using it.
+	certificateExpiryWarning = 30 * 24 * time.Hour
+
+	// certificateExpiryRefreshInterval is how often the certificate expiry metrics
+	// are recorded again, if no snapshot is built meanwhile.
+	certificateExpiryRefreshInterval = time.Hour
+
+	certificateExpiringReason       = "CertificateExpiring"
+	certificateHostNotCoveredReason = "CertificateHostNotCovered"
+)
+
+var (
+	certificateExpiryStat = stats.Float64(
+		"certificate_expiry_days",
+		"Days until the certificate of a TLS secret expires",
+		"d")
+	hostCertificateExpiryStat = stats.Float64(
+		"host_certificate_expiry_days",
+		"Days until the certificate served for a host expires",
+		"d")
+
+	secretNamespaceKey = tag.MustNewKey("secret_namespace")
+	secretNameKey      = tag.MustNewKey("secret_name")
+	hostKey            = tag.MustNewKey("host")
+
+	certificateExpiryViews = []*view.View{{
+		Description: certificateExpiryStat.Description(),
+		Measure:     certificateExpiryStat,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{secretNamespaceKey, secretNameKey},
+	}, {
+		Description: hostCertificateExpiryStat.Description(),
+		Measure:     hostCertificateExpiryStat,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{hostKey},
+	}}
+)
+
+func init() {
+	if err := view.Register(certificateExpiryViews...); err != nil {
+		panic(err)
+	}
+}
+
+// certificateExpiryRecorder records the days until the certificates of the last
+// snapshot expire.
+//
+// Single series can't be removed from the views without dropping all of them, which
+// would leave a gap in the scraped data, so the series of secrets and hosts that are
+// gone keep their last value until the controller restarts.
+type certificateExpiryRecorder struct {
+	mu           sync.Mutex
+	certificates []generator.CertificateInfo
+}
+
+// update records the expiry of the given certificates, replacing the ones recorded
+// before.
+func (r *certificateExpiryRecorder) update(ctx context.Context, certificates []generator.CertificateInfo, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.certificates = certificates
+	recordCertificateExpiry(ctx, r.certificates, now)
+}
+
+// refresh records the expiry of the certificates recorded last again, so the days
+// stay up to date if no snapshots are built.
+func (r *certificateExpiryRecorder) refresh(ctx context.Context, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recordCertificateExpiry(ctx, r.certificates, now)
+}
+
+// recordCertificateExpiry records the days until the given certificates expire, per
+// secret and per host covered.
+func recordCertificateExpiry(ctx context.Context, certificates []generator.CertificateInfo, now time.Time) {
+	logger := logging.FromContext(ctx)
+
+	for _, cert := range certificates {
+		days := cert.NotAfter.Sub(now).Hours() / 24
+
+		secretCtx, err := tag.New(ctx,
+			tag.Upsert(secretNamespaceKey, cert.Secret.Namespace),
+			tag.Upsert(secretNameKey, cert.Secret.Name))
+		if err != nil {
+			logger.Errorw("Failed to tag certificate expiry", zap.Error(err))
+			continue
+		}
+		metrics.Record(secretCtx, certificateExpiryStat.M(days))
+
+		uncovered := make(map[string]struct{}, len(cert.UncoveredHosts))
+		for _, host := range cert.UncoveredHosts {
+			uncovered[host] = struct{}{}
+		}
+		for _, host := range cert.Hosts {
+			if _, ok := uncovered[host]; ok {
+				continue
+			}
+			hostCtx, err := tag.New(ctx, tag.Upsert(hostKey, host))
+			if err != nil {
+				logger.Errorw("Failed to tag certificate expiry", zap.Error(err))
+				continue
+			}
+			metrics.Record(hostCtx, hostCertificateExpiryStat.M(days))
+		}
+	}
+}
+
+// certificateReporter emits events on the ingresses for certificates that are about
+// to expire or don't cover all hosts they are used for. As the ingresses are
+// reconciled again on every resync, the events are only emitted when the warnings
+// of an ingress change.
+type certificateReporter struct {
+	mu sync.Mutex
+	// reported are the warnings emitted last, per ingress.
+	reported map[types.NamespacedName]sets.String
+}
+
+func newCertificateReporter() *certificateReporter {
+	return &certificateReporter{reported: make(map[types.NamespacedName]sets.String)}
+}
+
+// report emits the warnings about the given certificates of the ingress that weren't
+// emitted the last time it was reported.
+func (r *certificateReporter) report(ctx context.Context, ing *v1alpha1.Ingress, certificates []generator.CertificateInfo, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}
+	warnings := certificateWarnings(certificates, now)
+	reported := r.reported[key]
+	messages := sets.NewString()
+	for _, warning := range warnings {
+		messages.Insert(warning.message)
+	}
+	if messages.Len() == 0 {
+		delete(r.reported, key)
+	} else {
+		r.reported[key] = messages
+	}
+
+	recorder := controller.GetEventRecorder(ctx)
+	if recorder == nil {
+		return
+	}
+	for _, warning := range warnings {
+		if !reported.Has(warning.message) {
+			recorder.Event(ing, corev1.EventTypeWarning, warning.reason, warning.message)
+		}
+	}
+}
+
+// forget drops the warnings reported for the given ingress, if any.
+func (r *certificateReporter) forget(key types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.reported, key)
+}
+
+type certificateWarning struct {
+	reason  string
+	message string
+}
+
+// certificateWarnings returns the warnings about the given certificates that are
+// about to expire or don't cover all hosts they are used for.
+func certificateWarnings(certificates []generator.CertificateInfo, now time.Time) []certificateWarning {
+	var warnings []certificateWarning
+	for _, cert := range certificates {
+		if cert.NotAfter.Sub(now) < certificateExpiryWarning {
+			warnings = append(warnings, certificateWarning{certificateExpiringReason,
+				fmt.Sprintf("Certificate in secret %s expires at %s", cert.Secret, cert.NotAfter.Format(time.RFC3339))})
+		}
+		for _, host := range cert.UncoveredHosts {
+			warnings = append(warnings, certificateWarning{certificateHostNotCoveredReason,
+				fmt.Sprintf("Certificate in secret %s does not cover host %q", cert.Secret, host)})
+		}
+	}
+	return warnings
+}
+
+// nextCertificateChange returns the next time after now one of the given
+// certificates becomes valid or expires, if any.
+func nextCertificateChange(certificates []generator.CertificateInfo, now time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, cert := range certificates {
+		for _, t := range []time.Time{cert.NotBefore, cert.NotAfter} {
+			if t.After(now) && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	return next, !next.IsZero()
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"gotest.tools/v3/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/net-kourier/pkg/generator"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/metrics"
+)
+
+func TestReportCertificates(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	secret := types.NamespacedName{Namespace: "ns", Name: "secret"}
+
+	tests := []struct {
+		name         string
+		certificates []generator.CertificateInfo
+		want         []string
+	}{{
+		name: "valid",
+		certificates: []generator.CertificateInfo{{
+			Secret:   secret,
+			NotAfter: now.Add(90 * 24 * time.Hour),
+			Hosts:    []string{"foo.example.com"},
+		}},
+	}, {
+		name: "expiring",
+		certificates: []generator.CertificateInfo{{
+			Secret:   secret,
+			NotAfter: now.Add(24 * time.Hour),
+			Hosts:    []string{"foo.example.com"},
+		}},
+		want: []string{"Warning CertificateExpiring Certificate in secret ns/secret expires at 2022-01-02T00:00:00Z"},
+	}, {
+		name: "host not covered",
+		certificates: []generator.CertificateInfo{{
+			Secret:         secret,
+			NotAfter:       now.Add(90 * 24 * time.Hour),
+			Hosts:          []string{"foo.example.com", "bar.example.com"},
+			UncoveredHosts: []string{"bar.example.com"},
+		}},
+		want: []string{`Warning CertificateHostNotCovered Certificate in secret ns/secret does not cover host "bar.example.com"`},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+
+			newCertificateReporter().report(ctx, &v1alpha1.Ingress{}, test.certificates, now)
+			close(recorder.Events)
+
+			var got []string
+			for event := range recorder.Events {
+				got = append(got, event)
+			}
+			assert.DeepEqual(t, got, test.want)
+		})
+	}
+}
+
+func TestReportCertificatesOnChange(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	recorder := record.NewFakeRecorder(10)
+	ctx := controller.WithEventRecorder(context.Background(), recorder)
+	ing := &v1alpha1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "testspace", Name: "testname"}}
+	expiring := generator.CertificateInfo{
+		Secret:   types.NamespacedName{Namespace: "ns", Name: "secret"},
+		NotAfter: now.Add(24 * time.Hour),
+		Hosts:    []string{"foo.example.com"},
+	}
+	rotated := expiring
+	rotated.NotAfter = now.Add(48 * time.Hour)
+
+	events := func() []string {
+		var got []string
+		for len(recorder.Events) > 0 {
+			got = append(got, <-recorder.Events)
+		}
+		return got
+	}
+
+	r := newCertificateReporter()
+	r.report(ctx, ing, []generator.CertificateInfo{expiring}, now)
+	assert.DeepEqual(t, events(), []string{"Warning CertificateExpiring Certificate in secret ns/secret expires at 2022-01-02T00:00:00Z"})
+
+	// Reconciling the ingress again doesn't repeat the warning.
+	r.report(ctx, ing, []generator.CertificateInfo{expiring}, now.Add(time.Hour))
+	assert.Equal(t, len(events()), 0)
+
+	// Other ingresses using the certificate are warned too.
+	other := &v1alpha1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "testspace", Name: "other"}}
+	r.report(ctx, other, []generator.CertificateInfo{expiring}, now)
+	assert.Equal(t, len(events()), 1)
+
+	// A different certificate is warned about.
+	r.report(ctx, ing, []generator.CertificateInfo{rotated}, now)
+	assert.DeepEqual(t, events(), []string{"Warning CertificateExpiring Certificate in secret ns/secret expires at 2022-01-03T00:00:00Z"})
+
+	// Once the ingress is deleted, the warnings are emitted again if it's recreated.
+	r.forget(types.NamespacedName{Namespace: "testspace", Name: "testname"})
+	r.report(ctx, ing, []generator.CertificateInfo{rotated}, now)
+	assert.Equal(t, len(events()), 1)
+}
+
+func TestNextCertificateChange(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, ok := nextCertificateChange(nil, now)
+	assert.Assert(t, !ok)
+
+	// Certificates that expired already don't change anymore.
+	_, ok = nextCertificateChange([]generator.CertificateInfo{{
+		NotBefore: now.Add(-48 * time.Hour),
+		NotAfter:  now.Add(-24 * time.Hour),
+	}}, now)
+	assert.Assert(t, !ok)
+
+	next, ok := nextCertificateChange([]generator.CertificateInfo{{
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(90 * 24 * time.Hour),
+	}, {
+		// Not valid yet.
+		NotBefore: now.Add(time.Hour),
+		NotAfter:  now.Add(30 * 24 * time.Hour),
+	}, {
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(24 * time.Hour),
+	}}, now)
+	assert.Assert(t, ok)
+	assert.Equal(t, next, now.Add(time.Hour))
+}
+
+func TestCertificateExpiryRecorder(t *testing.T) {
+	metrics.InitForTesting()
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	first := generator.CertificateInfo{
+		Secret:   types.NamespacedName{Namespace: "ns", Name: "first"},
+		NotAfter: now.Add(10 * 24 * time.Hour),
+		Hosts:    []string{"foo.example.com"},
+	}
+	second := generator.CertificateInfo{
+		Secret:   types.NamespacedName{Namespace: "ns", Name: "second"},
+		NotAfter: now.Add(20 * 24 * time.Hour),
+		Hosts:    []string{"bar.example.com"},
+	}
+
+	recorded := func(name string) map[string]float64 {
+		t.Helper()
+		rows, err := view.RetrieveData(name)
+		assert.NilError(t, err)
+		res := make(map[string]float64, len(rows))
+		for _, row := range rows {
+			var tags []string
+			for _, tag := range row.Tags {
+				tags = append(tags, tag.Value)
+			}
+			res[strings.Join(tags, "/")] = row.Data.(*view.LastValueData).Value
+		}
+		return res
+	}
+
+	r := &certificateExpiryRecorder{}
+	r.update(ctx, []generator.CertificateInfo{first, second}, now)
+	assert.DeepEqual(t, recorded(certificateExpiryStat.Name()), map[string]float64{"first/ns": 10, "second/ns": 20})
+	assert.DeepEqual(t, recorded(hostCertificateExpiryStat.Name()), map[string]float64{"foo.example.com": 10, "bar.example.com": 20})
+
+	// The series of the secrets and hosts that are gone keep their last value, rather
+	// than resetting all series.
+	r.update(ctx, []generator.CertificateInfo{second}, now.Add(24*time.Hour))
+	assert.DeepEqual(t, recorded(certificateExpiryStat.Name()), map[string]float64{"first/ns": 10, "second/ns": 19})
+	assert.DeepEqual(t, recorded(hostCertificateExpiryStat.Name()), map[string]float64{"foo.example.com": 10, "bar.example.com": 19})
+
+	// Refreshing records the days left at the given time.
+	r.refresh(ctx, now.Add(48*time.Hour))
+	assert.DeepEqual(t, recorded(certificateExpiryStat.Name()), map[string]float64{"first/ns": 10, "second/ns": 18})
+}
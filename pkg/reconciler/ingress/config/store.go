@@ -19,8 +19,12 @@ package config
 import (
 	"context"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"knative.dev/net-kourier/pkg/config"
+	network "knative.dev/networking/pkg"
 	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/system"
 )
 
 type cfgKey struct{}
@@ -28,6 +32,8 @@ type cfgKey struct{}
 // Config contains the configmaps requires for revision reconciliation.
 type Config struct {
 	Kourier *config.Kourier
+	// Network holds the settings of Knative's config-network, nil if not loaded.
+	Network *config.Network
 }
 
 // FromContext loads the configuration from the context.
@@ -41,9 +47,19 @@ func FromContextOrDefaults(ctx context.Context) *Config {
 	}
 	return &Config{
 		Kourier: config.DefaultConfig(),
+		Network: config.DefaultNetwork(),
 	}
 }
 
+// NetworkOrDefaults returns the settings of Knative's config-network, or their
+// defaults if they're not loaded.
+func (c *Config) NetworkOrDefaults() *config.Network {
+	if c.Network == nil {
+		return config.DefaultNetwork()
+	}
+	return c.Network
+}
+
 // ToContext persists the configuration to the context.
 func ToContext(ctx context.Context, c *Config) context.Context {
 	return context.WithValue(ctx, cfgKey{}, c)
@@ -56,19 +72,44 @@ type Store struct {
 
 // NewStore creates a new store of Configs and optionally calls functions when ConfigMaps are updated for Revisions
 func NewStore(logger configmap.Logger, onAfterStore ...func(name string, value interface{})) *Store {
-	store := &Store{
-		UntypedStore: configmap.NewUntypedStore(
-			"kourier",
-			logger,
-			configmap.Constructors{
-				config.ConfigName: config.NewConfigFromConfigMap,
+	store := &Store{}
+	store.UntypedStore = configmap.NewUntypedStore(
+		"kourier",
+		logger,
+		configmap.Constructors{
+			config.ConfigName: func(cm *corev1.ConfigMap) (*config.Kourier, error) {
+				cfg, err := config.NewConfigFromConfigMap(cm)
+				// The untyped store exits if the first config fails to load, which an
+				// invalid config-kourier must not make the controller do, e.g. if it was
+				// written before the webhook validated it. The defaults apply instead,
+				// until a valid config replaces them.
+				if err != nil && store.UntypedLoad(config.ConfigName) == nil {
+					logger.Errorf("Error initializing kourier config %q, using the defaults: %v", cm.Name, err)
+					return config.DefaultConfig(), nil
+				}
+				return cfg, err
 			},
-			onAfterStore...,
-		),
-	}
+			network.ConfigName: config.NewNetworkFromConfigMap,
+		},
+		onAfterStore...,
+	)
 	return store
 }
 
+// WatchConfigs watches config-kourier and Knative's config-network. The latter is
+// optional if the watcher supports defaults, its defaults apply while it doesn't
+// exist.
+func (s *Store) WatchConfigs(w configmap.Watcher) {
+	w.Watch(config.ConfigName, s.OnConfigChanged)
+	if dw, ok := w.(configmap.DefaultingWatcher); ok {
+		dw.WatchWithDefault(corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: system.Namespace(), Name: network.ConfigName},
+		}, s.OnConfigChanged)
+	} else {
+		w.Watch(network.ConfigName, s.OnConfigChanged)
+	}
+}
+
 // ToContext persists the config on the context.
 func (s *Store) ToContext(ctx context.Context) context.Context {
 	return ToContext(ctx, s.Load())
@@ -76,7 +117,9 @@ func (s *Store) ToContext(ctx context.Context) context.Context {
 
 // Load returns the config from the store.
 func (s *Store) Load() *Config {
+	networkCfg, _ := s.UntypedLoad(network.ConfigName).(*config.Network)
 	return &Config{
 		Kourier: s.UntypedLoad(config.ConfigName).(*config.Kourier).DeepCopy(),
+		Network: networkCfg.DeepCopy(),
 	}
 }
@@ -21,8 +21,15 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakekubeclient "k8s.io/client-go/kubernetes/fake"
 	"knative.dev/net-kourier/pkg/config"
+	network "knative.dev/networking/pkg"
+	"knative.dev/pkg/configmap/informer"
 	logtesting "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/system"
+	_ "knative.dev/pkg/system/testing"
 
 	. "knative.dev/pkg/configmap/testing"
 )
@@ -52,3 +59,88 @@ func TestStoreImmutableConfig(t *testing.T) {
 		t.Error("Kourier config is not immutable")
 	}
 }
+
+func TestStoreExampleDefaults(t *testing.T) {
+	// Copying the example must not change the configuration.
+	_, example := ConfigMapsFromTestFile(t, config.ConfigName)
+	got, err := config.NewConfigFromConfigMap(example)
+	if err != nil {
+		t.Fatal("NewConfigFromConfigMap(example) =", err)
+	}
+	if diff := cmp.Diff(config.DefaultConfig(), got); diff != "" {
+		t.Errorf("Unexpected example config (-want, +got):\n%v", diff)
+	}
+}
+
+func TestStoreInvalidConfig(t *testing.T) {
+	store := NewStore(logtesting.TestLogger(t))
+	invalid := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: config.ConfigName},
+		Data: map[string]string{
+			"enable-service-access-logging": "invalid",
+		},
+	}
+
+	// An invalid config falls back to the defaults rather than exiting initially.
+	store.OnConfigChanged(invalid)
+	if diff := cmp.Diff(config.DefaultConfig(), store.Load().Kourier); diff != "" {
+		t.Errorf("Unexpected initial config (-want, +got):\n%v", diff)
+	}
+
+	// Later, it keeps the last valid config.
+	kourierConfig := ConfigMapFromTestFile(t, config.ConfigName)
+	store.OnConfigChanged(kourierConfig)
+	store.OnConfigChanged(invalid)
+	expected, _ := config.NewConfigFromConfigMap(kourierConfig)
+	if diff := cmp.Diff(expected, store.Load().Kourier); diff != "" {
+		t.Errorf("Unexpected updated config (-want, +got):\n%v", diff)
+	}
+}
+
+func TestStoreLoadNetwork(t *testing.T) {
+	store := NewStore(logtesting.TestLogger(t))
+	store.OnConfigChanged(ConfigMapFromTestFile(t, config.ConfigName))
+
+	// The defaults apply until config-network is loaded.
+	cfg := FromContext(store.ToContext(context.Background()))
+	if cfg.Network != nil {
+		t.Errorf("Network = %v, want nil", cfg.Network)
+	}
+	if diff := cmp.Diff(config.DefaultNetwork(), cfg.NetworkOrDefaults()); diff != "" {
+		t.Errorf("Unexpected defaults network config (-want, +got):\n%v", diff)
+	}
+
+	store.OnConfigChanged(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: network.ConfigName},
+		Data: map[string]string{
+			network.HTTPProtocolKey: "redirected",
+			"internal-encryption":   "true",
+		},
+	})
+
+	cfg = FromContext(store.ToContext(context.Background()))
+	if cfg.Network.HTTPProtocol != network.HTTPRedirected || !cfg.Network.InternalEncryption {
+		t.Errorf("Network = %+v, want redirected HTTP and internal encryption", cfg.Network)
+	}
+}
+
+func TestStoreWatchConfigsWithoutNetwork(t *testing.T) {
+	kourierConfig := ConfigMapFromTestFile(t, config.ConfigName)
+	kourierConfig.Namespace = system.Namespace()
+	watcher := informer.NewInformedWatcher(fakekubeclient.NewSimpleClientset(kourierConfig), system.Namespace())
+
+	store := NewStore(logtesting.TestLogger(t))
+	store.WatchConfigs(watcher)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	// Starting fails if a required config map doesn't exist.
+	if err := watcher.Start(stopCh); err != nil {
+		t.Fatal("Start() =", err)
+	}
+
+	cfg := store.Load()
+	if diff := cmp.Diff(config.DefaultNetwork(), cfg.Network); diff != "" {
+		t.Errorf("Unexpected network config (-want, +got):\n%v", diff)
+	}
+}
@@ -19,14 +19,18 @@ package ingress
 import (
 	"context"
 	"strings"
+	"time"
 
 	v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	xds "github.com/envoyproxy/go-control-plane/pkg/server/v3"
 	"go.uber.org/zap"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"knative.dev/net-kourier/pkg/config"
 	envoy "knative.dev/net-kourier/pkg/envoy/server"
@@ -47,6 +51,7 @@ import (
 	"knative.dev/pkg/controller"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/reconciler"
+	"knative.dev/pkg/system"
 )
 
 const (
@@ -74,22 +79,51 @@ func NewController(ctx context.Context, cmw configmap.Watcher) *controller.Impl
 	podInformer := podinformer.Get(ctx)
 	secretInformer := secretinformer.Get(ctx)
 
+	for name, key := range config.DeprecatedEnv() {
+		logger.Warnf("Environment variable %s is deprecated and will be removed, set %q in the %s config map instead",
+			name, key, config.ConfigName)
+	}
+
 	// Create a new Cache, with the Readiness endpoint enabled, and the list of current Ingresses.
-	caches, err := generator.NewCaches(ctx, kubernetesClient, config.ExternalAuthz.Enabled)
+	caches, err := generator.NewCaches(ctx)
 	if err != nil {
 		logger.Fatalw("Failed create new caches", zap.Error(err))
 	}
 
 	r := &Reconciler{
-		caches:   caches,
-		extAuthz: config.ExternalAuthz.Enabled,
+		caches:              caches,
+		certificateExpiry:   &certificateExpiryRecorder{},
+		certificateReporter: newCertificateReporter(),
 	}
+	r.snapshots = newSnapshotBuilder(r.pushEnvoyConfig)
 
+	var configStore *rconfig.Store
 	impl := v1alpha1ingress.NewImpl(ctx, r, config.KourierIngressClassName, func(impl *controller.Impl) controller.Options {
-		resync := configmap.TypeFilter(&config.Kourier{})(func(string, interface{}) {
+		// Parts of the configuration, like the default certificate or the external
+		// authorization service, don't depend on any ingress.
+		updateEnvoyConfig := func() {
+			go func() {
+				if err := r.updateEnvoyConfig(configStore.ToContext(ctx)); err != nil {
+					logger.Errorw("Failed to update envoy config", zap.Error(err))
+				}
+			}()
+		}
+		resync := configmap.TypeFilter(&config.Kourier{})(func(_ string, value interface{}) {
 			impl.FilteredGlobalResync(isKourierIngress, ingressInformer.Informer())
+			// The default certificate might be a different secret now. It's added once the
+			// secret informer sees it otherwise.
+			if secret := defaultCertSecret(ctx, value.(*config.Kourier), func(ns, name string) (*corev1.Secret, error) {
+				return secretInformer.Lister().Secrets(ns).Get(name)
+			}); secret != nil {
+				caches.SetDefaultCertSecret(secret)
+			}
+			updateEnvoyConfig()
 		})
-		configStore := rconfig.NewStore(logger.Named("config-store"), resync)
+		networkResync := configmap.TypeFilter(&config.Network{})(func(string, interface{}) {
+			impl.FilteredGlobalResync(isKourierIngress, ingressInformer.Informer())
+			updateEnvoyConfig()
+		})
+		configStore = rconfig.NewStore(logger.Named("config-store"), resync, networkResync)
 		configStore.WatchConfigs(cmw)
 		return controller.Options{
 			ConfigStore:       configStore,
@@ -97,6 +131,7 @@ func NewController(ctx context.Context, cmw configmap.Watcher) *controller.Impl
 		}
 	})
 
+	r.enqueueAfter = impl.EnqueueKeyAfter
 	r.resyncConflicts = func() {
 		impl.FilteredGlobalResync(func(obj interface{}) bool {
 			lbReady := obj.(*v1alpha1.Ingress).Status.GetCondition(v1alpha1.IngressConditionLoadBalancerReady).GetReason()
@@ -106,46 +141,116 @@ func NewController(ctx context.Context, cmw configmap.Watcher) *controller.Impl
 		}, ingressInformer.Informer())
 	}
 
+	// onNACK handles the errors reported by the gateways when rejecting a snapshot.
+	onNACK := func(stream envoy.StreamKey, typeURL string, errorDetail *rpcstatus.Status) {
+		logger.Warnf("Error pushing snapshot to gateway: code: %v message %s", errorDetail.Code, errorDetail.Message)
+
+		// We know we can handle this error without a global resync.
+		if strings.HasPrefix(errorDetail.Message, unknownWeightedClusterPrefix) {
+			// The error message contains the service name as referenced by the ingress.
+			svc := strings.TrimPrefix(strings.TrimSuffix(errorDetail.Message, "'"), unknownWeightedClusterPrefix)
+			ns, name, err := cache.SplitMetaNamespaceKey(svc)
+			if err != nil {
+				logger.Errorw("Failed to parse service name from error", zap.Error(err))
+				return
+			}
+
+			logger.Infof("Triggering reconcile for all ingresses referencing %q", svc)
+			impl.Tracker.OnChanged(&corev1.Service{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "Service",
+					APIVersion: "v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: ns,
+					Name:      name,
+				},
+			})
+			return
+		}
+
+		// Roll back to the last known-good snapshot and find the ingress causing the
+		// error. This must not block the stream. No snapshot must be built meanwhile,
+		// as it might still contain the offending ingress and undo the rollback.
+		if seq, ok := r.sessions.NackedSnapshot(stream, typeURL); ok {
+			go r.snapshots.exclusive(func() {
+				r.nacks.onNACK(seq, errorDetail.Message)
+			})
+			return
+		}
+
+		// Fallback to a global resync of non-ready ingresses if the snapshot is unknown.
+		impl.FilteredGlobalResync(func(obj interface{}) bool {
+			return isKourierIngress(obj) && !obj.(*v1alpha1.Ingress).IsReady()
+		}, ingressInformer.Informer())
+	}
+
+	// The session tracker records the versions ACKed by every gateway, so ingresses are
+	// only marked ready once all gateways accepted them.
+	r.sessions = envoy.NewSessionTracker(func() {
+		r.acks.onAcked()
+		r.nacks.onAcked()
+	})
+	r.acks = newIngressAcks(r.sessions, impl.EnqueueKey)
+
+	// The NACK handler keeps the gateways on the last known-good snapshot and leaves
+	// the ingresses they reject out of the snapshots.
+	r.nacks = newNackHandler(logger.Named("nack-handler"), r.sessions, caches,
+		func(snapshot generator.Snapshot) {
+			if err := r.xdsServer.SetSnapshot(nodeID, snapshot.Snapshot); err != nil {
+				logger.Errorw("Failed to roll back snapshot", zap.Error(err))
+			}
+		},
+		func() {
+			// Pushing might block on building a snapshot, which must not happen on the
+			// xDS streams.
+			go func() {
+				if err := r.updateEnvoyConfig(configStore.ToContext(ctx)); err != nil {
+					logger.Errorw("Failed to update envoy config", zap.Error(err))
+				}
+			}()
+		},
+		impl.EnqueueKey)
+
 	envoyXdsServer := envoy.NewXdsServer(
 		managementPort,
 		&xds.CallbackFuncs{
-			StreamRequestFunc: func(_ int64, req *v3.DiscoveryRequest) error {
-				if req.ErrorDetail == nil {
-					return nil
+			StreamOpenFunc: func(_ context.Context, id int64, _ string) error {
+				r.sessions.StreamOpened(envoy.StreamKey{ID: id})
+				return nil
+			},
+			StreamClosedFunc: func(id int64) {
+				r.sessions.StreamClosed(envoy.StreamKey{ID: id})
+			},
+			StreamRequestFunc: func(id int64, req *v3.DiscoveryRequest) error {
+				stream := envoy.StreamKey{ID: id}
+				r.sessions.RequestReceived(stream, req.GetNode().GetId(), req.TypeUrl, req.ResponseNonce, req.ErrorDetail)
+				if req.ErrorDetail != nil {
+					onNACK(stream, req.TypeUrl, req.ErrorDetail)
 				}
-				logger.Warnf("Error pushing snapshot to gateway: code: %v message %s", req.ErrorDetail.Code, req.ErrorDetail.Message)
-
-				// We know we can handle this error without a global resync.
-				if strings.HasPrefix(req.ErrorDetail.Message, unknownWeightedClusterPrefix) {
-					// The error message contains the service name as referenced by the ingress.
-					svc := strings.TrimPrefix(strings.TrimSuffix(req.ErrorDetail.Message, "'"), unknownWeightedClusterPrefix)
-					ns, name, err := cache.SplitMetaNamespaceKey(svc)
-					if err != nil {
-						logger.Errorw("Failed to parse service name from error", zap.Error(err))
-						return nil
-					}
-
-					logger.Infof("Triggering reconcile for all ingresses referencing %q", svc)
-					impl.Tracker.OnChanged(&corev1.Service{
-						TypeMeta: metav1.TypeMeta{
-							Kind:       "Service",
-							APIVersion: "v1",
-						},
-						ObjectMeta: metav1.ObjectMeta{
-							Namespace: ns,
-							Name:      name,
-						},
-					})
-					return nil
+				return nil
+			},
+			StreamResponseFunc: func(_ context.Context, id int64, _ *v3.DiscoveryRequest, resp *v3.DiscoveryResponse) {
+				r.sessions.ResponseSent(envoy.StreamKey{ID: id}, resp.TypeUrl, resp.Nonce, resp.VersionInfo)
+			},
+			DeltaStreamOpenFunc: func(_ context.Context, id int64, _ string) error {
+				r.sessions.StreamOpened(envoy.StreamKey{ID: id, Delta: true})
+				return nil
+			},
+			DeltaStreamClosedFunc: func(id int64) {
+				r.sessions.StreamClosed(envoy.StreamKey{ID: id, Delta: true})
+			},
+			StreamDeltaRequestFunc: func(id int64, req *v3.DeltaDiscoveryRequest) error {
+				stream := envoy.StreamKey{ID: id, Delta: true}
+				r.sessions.RequestReceived(stream, req.GetNode().GetId(), req.TypeUrl, req.ResponseNonce, req.ErrorDetail)
+				if req.ErrorDetail != nil {
+					onNACK(stream, req.TypeUrl, req.ErrorDetail)
 				}
-
-				// Fallback to a global resync of non-ready ingresses for every other error.
-				impl.FilteredGlobalResync(func(obj interface{}) bool {
-					return isKourierIngress(obj) && !obj.(*v1alpha1.Ingress).IsReady()
-				}, ingressInformer.Informer())
-
 				return nil
 			},
+			StreamDeltaResponseFunc: func(id int64, _ *v3.DeltaDiscoveryRequest, resp *v3.DeltaDiscoveryResponse) {
+				r.sessions.ResponseSent(envoy.StreamKey{ID: id, Delta: true}, resp.TypeUrl, resp.Nonce, resp.SystemVersionInfo)
+			},
 		},
 	)
 	r.xdsServer = envoyXdsServer
@@ -180,13 +285,19 @@ func NewController(ctx context.Context, cmw configmap.Watcher) *controller.Impl
 		impl.Tracker)
 	r.ingressTranslator = &ingressTranslator
 
+	// The config store is only populated once the informers are started, so read the
+	// configuration the gateways are primed with directly.
+	startupCfg := startupConfig(ctx, kubernetesClient)
+	startupCtx := rconfig.ToContext(ctx, &rconfig.Config{Kourier: startupCfg})
+
+	// Likewise for the default certificate. The gateways are primed without it if it
+	// can't be read, it's added once the secret informer sees it.
+	caches.SetDefaultCertSecret(defaultCertSecret(ctx, startupCfg, func(ns, name string) (*corev1.Secret, error) {
+		return kubernetesClient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+	}))
+
 	// Initialize the Envoy snapshot.
-	snapshot, err := r.caches.ToEnvoySnapshot(ctx)
-	if err != nil {
-		logger.Fatalw("Failed to create snapshot", zap.Error(err))
-	}
-	err = r.xdsServer.SetSnapshot(nodeID, snapshot)
-	if err != nil {
+	if err := r.pushEnvoyConfig(startupCtx); err != nil {
 		logger.Fatalw("Failed to set snapshot", zap.Error(err))
 	}
 
@@ -213,15 +324,30 @@ func NewController(ctx context.Context, cmw configmap.Watcher) *controller.Impl
 
 	for _, ingress := range ingressesToSync {
 		if err := generator.UpdateInfoForIngress(
-			ctx, caches, ingress, &startupTranslator, config.ExternalAuthz.Enabled); err != nil {
+			startupCtx, caches, ingress, &startupTranslator, rconfig.FromContext(startupCtx).Kourier.ExternalAuthz.Enabled()); err != nil {
 			logger.Fatalw("Failed prewarm ingress", zap.Error(err))
 		}
 	}
-	// Update the entire batch of ready ingresses at once.
-	if err := r.updateEnvoyConfig(ctx); err != nil {
+	// Update the entire batch of ready ingresses at once. This must not be batched, as
+	// the management server must only be started once the configuration is in place.
+	if err := r.pushEnvoyConfig(startupCtx); err != nil {
 		logger.Fatalw("Failed to set initial envoy config", zap.Error(err))
 	}
 
+	// The days until the certificates expire change without any snapshot being built.
+	go func() {
+		ticker := time.NewTicker(certificateExpiryRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				r.certificateExpiry.refresh(ctx, now)
+			}
+		}
+	}()
+
 	// Let's start the management server **after** the configuration has been seeded.
 	go func() {
 		logger.Info("Starting Management Server on Port ", managementPort)
@@ -270,6 +396,15 @@ func NewController(ctx context.Context, cmw configmap.Watcher) *controller.Impl
 				return
 			}
 
+			// The endpoints are served via EDS, so if they back a cluster we already
+			// know, it's enough to push the new load assignments.
+			if r.caches.UpdateEndpoints(new.(*corev1.Endpoints)) {
+				if err := r.updateEnvoyConfig(configStore.ToContext(ctx)); err != nil {
+					logger.Errorw("Failed to update endpoints", zap.Error(err))
+				}
+				return
+			}
+
 			viaTracker(new)
 		},
 	})
@@ -281,6 +416,33 @@ func NewController(ctx context.Context, cmw configmap.Watcher) *controller.Impl
 		),
 	))
 
+	// The default certificate is tracked via the informer as well, so it's not read
+	// for every snapshot and a rotated certificate is pushed right away.
+	updateDefaultCert := func(secret *corev1.Secret) {
+		if r.caches.SetDefaultCertSecret(secret) {
+			if err := r.updateEnvoyConfig(configStore.ToContext(ctx)); err != nil {
+				logger.Errorw("Failed to update default certificate", zap.Error(err))
+			}
+		}
+	}
+	secretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			secret, ok := secretFromObj(obj)
+			return ok && generator.IsDefaultCertSecret(secret, configStore.Load().Kourier)
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				updateDefaultCert(obj.(*corev1.Secret))
+			},
+			UpdateFunc: func(_, new interface{}) {
+				updateDefaultCert(new.(*corev1.Secret))
+			},
+			DeleteFunc: func(interface{}) {
+				updateDefaultCert(nil)
+			},
+		},
+	})
+
 	podInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
 		FilterFunc: reconciler.LabelFilterFunc(gatewayLabelKey, gatewayLabelValue, false),
 		Handler: cache.ResourceEventHandlerFuncs{
@@ -297,6 +459,57 @@ func NewController(ctx context.Context, cmw configmap.Watcher) *controller.Impl
 	return impl
 }
 
+// startupConfig reads the Kourier configuration from the API server. Invalid
+// configuration is logged and the defaults are used instead.
+func startupConfig(ctx context.Context, kubernetesClient kubernetes.Interface) *config.Kourier {
+	logger := logging.FromContext(ctx)
+
+	cm, err := kubernetesClient.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, config.ConfigName, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			logger.Errorw("Failed to fetch Kourier config, using defaults", zap.Error(err))
+			return config.DefaultConfig()
+		}
+		// The deprecated environment variables still apply.
+		cm = &corev1.ConfigMap{}
+	}
+
+	cfg, err := config.NewConfigFromConfigMap(cm)
+	if err != nil {
+		logger.Errorw("Invalid Kourier config, using defaults", zap.Error(err))
+		return config.DefaultConfig()
+	}
+	return cfg
+}
+
+// secretFromObj returns the secret of an informer event, unwrapping the tombstones of
+// deletions the informer missed.
+func secretFromObj(obj interface{}) (*corev1.Secret, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	secret, ok := obj.(*corev1.Secret)
+	return secret, ok
+}
+
+// defaultCertSecret returns the secret holding the default certificate of the given
+// configuration, nil if there's none or it can't be read. Failing to read an
+// existing secret is logged, unlike the secret not existing (yet).
+func defaultCertSecret(ctx context.Context, cfg *config.Kourier, getSecret func(ns, name string) (*corev1.Secret, error)) *corev1.Secret {
+	if !cfg.HasCertsSecret() {
+		return nil
+	}
+	secret, err := getSecret(cfg.CertsSecretNamespace, cfg.CertsSecretName)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			logging.FromContext(ctx).Errorw("Failed to fetch the default certificate secret, not serving it",
+				zap.String("secret", cfg.CertsSecretNamespace+"/"+cfg.CertsSecretName), zap.Error(err))
+		}
+		return nil
+	}
+	return secret
+}
+
 func getReadyIngresses(ctx context.Context, knativeClient networkingClientSet.NetworkingV1alpha1Interface) ([]*v1alpha1.Ingress, error) {
 	ingresses, err := knativeClient.Ingresses("").List(ctx, metav1.ListOptions{})
 	if err != nil {
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/net-kourier/pkg/config"
+	logtesting "knative.dev/pkg/logging/testing"
+)
+
+func TestSecretFromObj(t *testing.T) {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "certs"}}
+
+	tests := []struct {
+		name string
+		obj  interface{}
+		want *corev1.Secret
+	}{{
+		name: "secret",
+		obj:  secret,
+		want: secret,
+	}, {
+		name: "tombstone",
+		obj:  cache.DeletedFinalStateUnknown{Key: "ns/certs", Obj: secret},
+		want: secret,
+	}, {
+		name: "tombstone of another type",
+		obj:  cache.DeletedFinalStateUnknown{Key: "ns/pod", Obj: &corev1.Pod{}},
+	}, {
+		name: "another type",
+		obj:  &corev1.Pod{},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := secretFromObj(test.obj)
+			if ok != (test.want != nil) || got != test.want {
+				t.Errorf("secretFromObj() = %v, %v, want %v", got, ok, test.want)
+			}
+		})
+	}
+}
+
+func TestDefaultCertSecret(t *testing.T) {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "certs"}}
+	withCertsSecret := config.DefaultConfig()
+	withCertsSecret.CertsSecretNamespace = "ns"
+	withCertsSecret.CertsSecretName = "certs"
+
+	tests := []struct {
+		name   string
+		cfg    *config.Kourier
+		secret *corev1.Secret
+		err    error
+		want   *corev1.Secret
+	}{{
+		name: "no default certificate",
+		cfg:  config.DefaultConfig(),
+	}, {
+		name:   "found",
+		cfg:    withCertsSecret,
+		secret: secret,
+		want:   secret,
+	}, {
+		name: "not found",
+		cfg:  withCertsSecret,
+		err:  apierrors.NewNotFound(corev1.Resource("secrets"), "certs"),
+	}, {
+		name: "failed to fetch",
+		cfg:  withCertsSecret,
+		err:  apierrors.NewForbidden(corev1.Resource("secrets"), "certs", errors.New("denied")),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := logtesting.TestContextWithLogger(t)
+			got := defaultCertSecret(ctx, test.cfg, func(ns, name string) (*corev1.Secret, error) {
+				if ns != "ns" || name != "certs" {
+					t.Errorf("Fetched secret %s/%s, want ns/certs", ns, name)
+				}
+				return test.secret, test.err
+			})
+			if got != test.want {
+				t.Errorf("defaultCertSecret() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
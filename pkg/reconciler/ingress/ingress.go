@@ -20,11 +20,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"k8s.io/apimachinery/pkg/types"
 	"knative.dev/net-kourier/pkg/config"
 	envoy "knative.dev/net-kourier/pkg/envoy/server"
 	"knative.dev/net-kourier/pkg/generator"
+	rconfig "knative.dev/net-kourier/pkg/reconciler/ingress/config"
 	"knative.dev/networking/pkg/apis/networking/v1alpha1"
 	"knative.dev/networking/pkg/client/injection/reconciler/networking/v1alpha1/ingress"
 	"knative.dev/networking/pkg/status"
@@ -32,18 +34,30 @@ import (
 	"knative.dev/pkg/reconciler"
 )
 
-const conflictReason = "DomainConflict"
+const (
+	conflictReason           = "DomainConflict"
+	invalidReason            = "InvalidConfiguration"
+	certificateInvalidReason = "CertificateInvalid"
+)
 
 type Reconciler struct {
 	xdsServer         *envoy.XdsServer
+	sessions          *envoy.SessionTracker
+	acks              *ingressAcks
+	nacks             *nackHandler
 	caches            *generator.Caches
 	statusManager     *status.Prober
 	ingressTranslator *generator.IngressTranslator
-	extAuthz          bool
+	snapshots         *snapshotBuilder
+	certificateExpiry *certificateExpiryRecorder
+	// certificateReporter emits the certificate warnings of the ingresses.
+	certificateReporter *certificateReporter
 
 	// resyncConflicts triggers a filtered global resync to reenqueue all ingresses in
 	// a "Conflict" state.
 	resyncConflicts func()
+	// enqueueAfter enqueues the given ingress after the given delay.
+	enqueueAfter func(key types.NamespacedName, delay time.Duration)
 }
 
 var _ ingress.Interface = (*Reconciler)(nil)
@@ -65,11 +79,47 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, ing *v1alpha1.Ingress) r
 		return fmt.Errorf("failed to update ingress: %w", err)
 	}
 
+	key := types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}
+	now := time.Now()
+	certificates := r.caches.Certificates(key)
+	r.certificateReporter.report(ctx, ing, certificates, now)
+	// The certificates are validated when translating the ingress, so translate it
+	// again once one of them becomes valid or expires.
+	if next, ok := nextCertificateChange(certificates, now); ok {
+		r.enqueueAfter(key, next.Sub(now)+time.Second)
+	}
+
+	if err := r.caches.ValidationError(key); errors.Is(err, generator.ErrCertificateInvalid) {
+		// The ingress is left out of the snapshots until it or its secrets change.
+		logging.FromContext(ctx).Info(err.Error())
+		ing.Status.MarkLoadBalancerFailed(certificateInvalidReason, "Ingress rejected: "+err.Error())
+		return nil
+	} else if err != nil {
+		// The generated configuration would be rejected by the gateways, so it's left out
+		// of the snapshots until the ingress changes.
+		logging.FromContext(ctx).Info(err.Error())
+		ing.Status.MarkLoadBalancerFailed(invalidReason, "Ingress translated to invalid configuration: "+err.Error())
+		return nil
+	}
+	if message, ok := r.nacks.rejection(key, ing.Generation); ok {
+		// The gateways rejected the configuration of this generation of the ingress, so
+		// it's left out until it changes.
+		ing.Status.MarkLoadBalancerFailed(rejectedReason, "Ingress rejected by the gateways: "+message)
+		return nil
+	}
+	if err := r.caches.TLSCertificateError(key); err != nil {
+		// The ingress is served, except for the hosts without a valid certificate, which
+		// are left out of the HTTPS listeners until the ingress or its secrets change.
+		logging.FromContext(ctx).Info(err.Error())
+		ing.Status.MarkLoadBalancerFailed(certificateInvalidReason, "Hosts not served via HTTPS: "+err.Error())
+		return nil
+	}
+
 	ing.Status.MarkNetworkConfigured()
 	if !ing.IsReady() || !isExpectedLoadBalancer(ing) {
-		ready, err := r.statusManager.IsReady(ctx, before)
+		ready, err := r.isReady(ctx, before)
 		if err != nil {
-			return fmt.Errorf("failed to probe Ingress: %w", err)
+			return err
 		}
 		if ready {
 			external, internal := config.ServiceHostnames()
@@ -85,6 +135,27 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, ing *v1alpha1.Ingress) r
 	return nil
 }
 
+// isReady returns true if all gateways ACKed a snapshot containing the current
+// generation of the ingress and, unless disabled, probing the gateways confirms
+// that they serve it.
+func (r *Reconciler) isReady(ctx context.Context, ing *v1alpha1.Ingress) (bool, error) {
+	key := types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}
+	if !r.acks.isAcked(key, ing.Generation) {
+		logging.FromContext(ctx).Debug("Waiting for gateways to ACK the Ingress")
+		return false, nil
+	}
+
+	if !rconfig.FromContextOrDefaults(ctx).Kourier.EnableReadinessProbing {
+		return true, nil
+	}
+
+	ready, err := r.statusManager.IsReady(ctx, ing)
+	if err != nil {
+		return false, fmt.Errorf("failed to probe Ingress: %w", err)
+	}
+	return ready, nil
+}
+
 // isExpectedLoadBalancer verifies if expected Loadbalancer is set in status field.
 func isExpectedLoadBalancer(ing *v1alpha1.Ingress) bool {
 	external, internal := config.ServiceHostnames()
@@ -118,6 +189,9 @@ func (r *Reconciler) ObserveDeletion(ctx context.Context, key types.NamespacedNa
 	logger.Infof("Ingress deleted, updating config")
 
 	r.statusManager.CancelIngressProbingByKey(key)
+	r.acks.forget(key)
+	r.nacks.forget(key)
+	r.certificateReporter.forget(key)
 
 	if err := r.caches.DeleteIngressInfo(ctx, key.Name, key.Namespace); err != nil {
 		return err
@@ -141,14 +215,22 @@ func (r *Reconciler) updateIngress(ctx context.Context, ingress *v1alpha1.Ingres
 	logger.Infof("Updating Ingress")
 
 	if err := generator.UpdateInfoForIngress(
-		ctx, r.caches, ingress, r.ingressTranslator, r.extAuthz); err != nil {
+		ctx, r.caches, ingress, r.ingressTranslator, rconfig.FromContextOrDefaults(ctx).Kourier.ExternalAuthz.Enabled()); err != nil {
 		return err
 	}
 
 	return r.updateEnvoyConfig(ctx)
 }
 
+// updateEnvoyConfig requests the current state of the caches to be pushed to the
+// gateways. The push might happen asynchronously, batched with further requests.
 func (r *Reconciler) updateEnvoyConfig(ctx context.Context) error {
+	return r.snapshots.trigger(ctx)
+}
+
+// pushEnvoyConfig builds a snapshot from the current state of the caches and pushes
+// it to the gateways.
+func (r *Reconciler) pushEnvoyConfig(ctx context.Context) error {
 	logger := logging.FromContext(ctx)
 	logger.Debugf("Preparing Envoy Snapshot")
 
@@ -157,5 +239,24 @@ func (r *Reconciler) updateEnvoyConfig(ctx context.Context) error {
 		return err
 	}
 
-	return r.xdsServer.SetSnapshot(nodeID, newSnapshot)
+	r.certificateExpiry.update(ctx, newSnapshot.Certificates, time.Now())
+
+	// Record the snapshot even if it's not pushed below. It might contain a new
+	// generation of an ingress, which didn't change the resources.
+	seq := r.sessions.SnapshotPushed(newSnapshot.Snapshot)
+	r.acks.snapshotPushed(seq, newSnapshot)
+	r.nacks.snapshotPushed(seq, newSnapshot)
+
+	// The snapshot versions are derived from its contents. Don't push anything to the
+	// gateways if nothing changed.
+	if r.xdsServer.HasSnapshot(nodeID, newSnapshot.Snapshot) {
+		logger.Debugf("Envoy Snapshot unchanged, skipping update")
+		// There won't be an ACK for this snapshot, but the gateways might have ACKed
+		// the same versions already.
+		r.acks.onAcked()
+		r.nacks.onAcked()
+		return nil
+	}
+
+	return r.xdsServer.SetSnapshot(nodeID, newSnapshot.Snapshot)
 }
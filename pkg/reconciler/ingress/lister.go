@@ -63,6 +63,11 @@ func (l *gatewayPodTargetLister) ListProbeTargets(ctx context.Context, ing *v1al
 func (l *gatewayPodTargetLister) getIngressUrls(ing *v1alpha1.Ingress, gatewayIps []string) ([]status.ProbeTarget, error) {
 	ips := sets.NewString(gatewayIps...)
 
+	tlsHosts := sets.NewString()
+	for _, tls := range ing.Spec.TLS {
+		tlsHosts.Insert(tls.Hosts...)
+	}
+
 	targets := make([]status.ProbeTarget, 0, len(ing.Spec.Rules))
 	for _, rule := range ing.Spec.Rules {
 		var target status.ProbeTarget
@@ -81,6 +86,14 @@ func (l *gatewayPodTargetLister) getIngressUrls(ing *v1alpha1.Ingress, gatewayIp
 				target.PodPort = strconv.Itoa(int(config.HTTPPortProb))
 				target.URLs = domainsToURL(domains, scheme)
 			}
+		} else if tlsHosts.HasAll(domains...) {
+			// The cluster-local hosts with a certificate of their own are probed via
+			// the cluster-local HTTPS listener.
+			target = status.ProbeTarget{
+				PodIPs:  ips,
+				PodPort: strconv.Itoa(int(config.HTTPSPortInternal)),
+				URLs:    domainsToURL(domains, "https"),
+			}
 		} else {
 			target = status.ProbeTarget{
 				PodIPs:  ips,
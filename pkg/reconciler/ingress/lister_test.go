@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"net/url"
+	"testing"
+
+	"gotest.tools/v3/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+	"knative.dev/networking/pkg/status"
+	pkgtest "knative.dev/pkg/logging/testing"
+)
+
+func TestGetIngressUrls(t *testing.T) {
+	rules := []v1alpha1.IngressRule{{
+		Hosts:      []string{"foo.example.com"},
+		Visibility: v1alpha1.IngressVisibilityExternalIP,
+	}, {
+		Hosts:      []string{"foo.testspace.svc.cluster.local"},
+		Visibility: v1alpha1.IngressVisibilityClusterLocal,
+	}}
+	ips := sets.NewString("10.0.0.1")
+	target := func(port, scheme, host string) status.ProbeTarget {
+		return status.ProbeTarget{
+			PodIPs:  ips,
+			PodPort: port,
+			URLs:    []*url.URL{{Scheme: scheme, Host: host, Path: "/"}},
+		}
+	}
+
+	tests := []struct {
+		name string
+		tls  []v1alpha1.IngressTLS
+		want []status.ProbeTarget
+	}{{
+		name: "plain HTTP",
+		want: []status.ProbeTarget{
+			target("8090", "http", "foo.example.com"),
+			target("8081", "http", "foo.testspace.svc.cluster.local"),
+		},
+	}, {
+		name: "external TLS",
+		tls: []v1alpha1.IngressTLS{{
+			Hosts: []string{"foo.example.com"},
+		}},
+		want: []status.ProbeTarget{
+			target("9443", "https", "foo.example.com"),
+			target("8081", "http", "foo.testspace.svc.cluster.local"),
+		},
+	}, {
+		name: "cluster-local TLS",
+		tls: []v1alpha1.IngressTLS{{
+			Hosts: []string{"foo.example.com", "foo.testspace.svc.cluster.local"},
+		}},
+		want: []status.ProbeTarget{
+			target("9443", "https", "foo.example.com"),
+			target("8444", "https", "foo.testspace.svc.cluster.local"),
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lister := &gatewayPodTargetLister{logger: pkgtest.TestLogger(t)}
+			ing := &v1alpha1.Ingress{
+				Spec: v1alpha1.IngressSpec{
+					Rules: rules,
+					TLS:   test.tls,
+				},
+			}
+
+			got, err := lister.getIngressUrls(ing, ips.List())
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got, test.want)
+		})
+	}
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	envoy "knative.dev/net-kourier/pkg/envoy/server"
+	"knative.dev/net-kourier/pkg/generator"
+)
+
+// rejectedReason is the reason set on ingresses the configuration of which was
+// rejected by the gateways.
+const rejectedReason = "ConfigurationRejected"
+
+// maxPendingSnapshots is the number of snapshots pushed after the last known-good
+// one that are remembered to attribute NACKs.
+const maxPendingSnapshots = 100
+
+// ingressExcluder allows to leave ingresses out of the snapshots. It's implemented
+// by generator.Caches.
+type ingressExcluder interface {
+	SetExcludedIngresses(excluded map[types.NamespacedName]int64)
+	AttributeError(message string, candidates []types.NamespacedName) []types.NamespacedName
+}
+
+type rejection struct {
+	generation int64
+	message    string
+}
+
+// bisection narrows down the ingress causing a NACK, by pushing snapshots that only
+// contain half of the suspected ingresses until a single one is left.
+type bisection struct {
+	// message is the error reported by the gateways with the last NACK.
+	message string
+	// generations are the generations of the ingresses in the NACKed snapshot.
+	generations map[types.NamespacedName]int64
+	// suspects contains the ingress causing the NACK.
+	suspects []types.NamespacedName
+	// included are the suspects included in the probe snapshot. The other suspects
+	// are left out.
+	included []types.NamespacedName
+	// unverified are ingresses left out of the snapshots while bisecting, which are
+	// neither known to be good nor bad.
+	unverified []types.NamespacedName
+	// probe is the sequence number of the snapshot with the included suspects, zero
+	// until it's pushed.
+	probe uint64
+}
+
+// nackHandler remembers the last snapshot ACKed by all gateways and, when a snapshot
+// is NACKed, rolls the gateways back to it, finds the offending ingress and leaves it
+// out of the snapshots until it changes.
+type nackHandler struct {
+	logger   *zap.SugaredLogger
+	sessions *envoy.SessionTracker
+	excluder ingressExcluder
+	// rollback sets the given snapshot for the gateways.
+	rollback func(generator.Snapshot)
+	// push requests a new snapshot to be pushed. Must not block.
+	push func()
+	// enqueue is called for ingresses that got rejected.
+	enqueue func(types.NamespacedName)
+
+	mu sync.Mutex
+	// snapshots are the snapshots pushed after the last known-good one.
+	snapshots map[uint64]generator.Snapshot
+	// lastGood is the last snapshot ACKed by all gateways, if any.
+	lastGood  *generator.Snapshot
+	rejected  map[types.NamespacedName]rejection
+	bisection *bisection
+}
+
+func newNackHandler(logger *zap.SugaredLogger, sessions *envoy.SessionTracker, excluder ingressExcluder,
+	rollback func(generator.Snapshot), push func(), enqueue func(types.NamespacedName)) *nackHandler {
+	return &nackHandler{
+		logger:    logger,
+		sessions:  sessions,
+		excluder:  excluder,
+		rollback:  rollback,
+		push:      push,
+		enqueue:   enqueue,
+		snapshots: make(map[uint64]generator.Snapshot),
+		rejected:  make(map[types.NamespacedName]rejection),
+	}
+}
+
+// rejection returns the error reported by the gateways if they rejected the given
+// generation of the ingress.
+func (h *nackHandler) rejection(key types.NamespacedName, generation int64) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rejected, ok := h.rejected[key]
+	if !ok || rejected.generation != generation {
+		return "", false
+	}
+	return rejected.message, true
+}
+
+// forget drops the rejection of the given ingress, if any.
+func (h *nackHandler) forget(key types.NamespacedName) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.rejected[key]; ok {
+		delete(h.rejected, key)
+		h.excluder.SetExcludedIngresses(h.excluded())
+	}
+}
+
+// snapshotPushed records a snapshot pushed with the given sequence number.
+func (h *nackHandler) snapshotPushed(seq uint64, snapshot generator.Snapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.snapshots[seq] = snapshot
+	for len(h.snapshots) > maxPendingSnapshots {
+		oldest := seq
+		for s := range h.snapshots {
+			if s < oldest {
+				oldest = s
+			}
+		}
+		delete(h.snapshots, oldest)
+	}
+
+	// A snapshot built before the bisection started might still contain the left out
+	// suspects, so it doesn't count as probe.
+	if b := h.bisection; b != nil && b.probe == 0 && !containsAny(snapshot, h.excluded()) {
+		b.probe = seq
+	}
+}
+
+// onAcked updates the last known-good snapshot and continues bisecting if the probe
+// snapshot was ACKed.
+func (h *nackHandler) onAcked() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var acked uint64
+	for seq := range h.snapshots {
+		if seq > acked && h.sessions.Acked(seq) {
+			acked = seq
+		}
+	}
+	if acked == 0 {
+		return
+	}
+
+	lastGood := h.snapshots[acked]
+	h.lastGood = &lastGood
+	for seq := range h.snapshots {
+		if seq <= acked {
+			delete(h.snapshots, seq)
+		}
+	}
+
+	if b := h.bisection; b != nil && b.probe != 0 && b.probe <= acked {
+		// The included suspects are fine, so the offending ingress is left out.
+		h.narrow(difference(b.suspects, b.included))
+	}
+}
+
+// onNACK handles the gateways rejecting the snapshot with the given sequence number
+// with the given error message. Must be called while no snapshot is built, see
+// snapshotBuilder.exclusive, so the rollback isn't overridden by a stale snapshot.
+func (h *nackHandler) onNACK(seq uint64, message string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshot, ok := h.snapshots[seq]
+	if !ok {
+		// Already handled, or too old to matter.
+		return
+	}
+	delete(h.snapshots, seq)
+
+	if b := h.bisection; b != nil {
+		if seq != b.probe {
+			// Only the probes matter while bisecting.
+			return
+		}
+		// One of the included suspects is the offending ingress.
+		b.message = message
+		b.unverified = append(b.unverified, difference(b.suspects, b.included)...)
+		h.narrow(b.included)
+		return
+	}
+
+	// The suspects are the ingresses that changed since the last known-good snapshot,
+	// narrowed down to the ones mentioned by the error, if any.
+	suspects := make([]types.NamespacedName, 0, len(snapshot.Ingresses))
+	for key, generation := range snapshot.Ingresses {
+		if h.lastGood == nil {
+			suspects = append(suspects, key)
+		} else if lastGeneration, ok := h.lastGood.Ingresses[key]; !ok || lastGeneration != generation {
+			suspects = append(suspects, key)
+		}
+	}
+	sort.Slice(suspects, func(i, j int) bool {
+		return suspects[i].String() < suspects[j].String()
+	})
+	if attributed := h.excluder.AttributeError(message, suspects); len(attributed) > 0 {
+		suspects = attributed
+	}
+
+	if h.lastGood != nil {
+		h.logger.Info("Rolling back to the last known-good snapshot")
+		h.rollback(*h.lastGood)
+	}
+
+	h.bisection = &bisection{
+		message:     message,
+		generations: snapshot.Ingresses,
+	}
+	h.narrow(suspects)
+}
+
+// narrow continues bisecting with the given suspects. Must be called while holding mu.
+func (h *nackHandler) narrow(suspects []types.NamespacedName) {
+	b := h.bisection
+
+	switch len(suspects) {
+	case 0:
+		h.logger.Errorf("Failed to attribute rejected snapshot to an ingress: %s", b.message)
+		h.bisection = nil
+	case 1:
+		key := suspects[0]
+		h.logger.Warnf("Ingress %s rejected by the gateways: %s", key, b.message)
+		h.rejected[key] = rejection{
+			generation: b.generations[key],
+			message:    b.message,
+		}
+		h.bisection = nil
+		h.enqueue(key)
+	default:
+		b.suspects = suspects
+		b.included = suspects[:len(suspects)/2]
+		b.probe = 0
+	}
+
+	h.excluder.SetExcludedIngresses(h.excluded())
+	h.push()
+}
+
+// excluded returns the ingresses to leave out of the snapshots. Must be called while
+// holding mu.
+func (h *nackHandler) excluded() map[types.NamespacedName]int64 {
+	excluded := make(map[types.NamespacedName]int64, len(h.rejected))
+	for key, rejected := range h.rejected {
+		excluded[key] = rejected.generation
+	}
+	if b := h.bisection; b != nil {
+		for _, key := range append(difference(b.suspects, b.included), b.unverified...) {
+			excluded[key] = b.generations[key]
+		}
+	}
+	return excluded
+}
+
+// containsAny returns true if the snapshot contains any of the given generations of
+// ingresses.
+func containsAny(snapshot generator.Snapshot, ingresses map[types.NamespacedName]int64) bool {
+	for key, generation := range ingresses {
+		if g, ok := snapshot.Ingresses[key]; ok && g == generation {
+			return true
+		}
+	}
+	return false
+}
+
+// difference returns the keys in a, which are not in b.
+func difference(a, b []types.NamespacedName) []types.NamespacedName {
+	inB := make(map[types.NamespacedName]struct{}, len(b))
+	for _, key := range b {
+		inB[key] = struct{}{}
+	}
+
+	res := make([]types.NamespacedName, 0, len(a))
+	for _, key := range a {
+		if _, ok := inB[key]; !ok {
+			res = append(res, key)
+		}
+	}
+	return res
+}
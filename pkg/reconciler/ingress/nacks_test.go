@@ -0,0 +1,152 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"strconv"
+	"testing"
+
+	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"gotest.tools/v3/assert"
+	"k8s.io/apimachinery/pkg/types"
+	envoy "knative.dev/net-kourier/pkg/envoy/server"
+	"knative.dev/net-kourier/pkg/generator"
+	logtesting "knative.dev/pkg/logging/testing"
+)
+
+type fakeExcluder struct {
+	excluded   map[types.NamespacedName]int64
+	attributed []types.NamespacedName
+}
+
+func (f *fakeExcluder) SetExcludedIngresses(excluded map[types.NamespacedName]int64) {
+	f.excluded = excluded
+}
+
+func (f *fakeExcluder) AttributeError(string, []types.NamespacedName) []types.NamespacedName {
+	return f.attributed
+}
+
+func TestNackHandlerBisects(t *testing.T) {
+	a := types.NamespacedName{Namespace: "ns", Name: "a"}
+	b := types.NamespacedName{Namespace: "ns", Name: "b"}
+	c := types.NamespacedName{Namespace: "ns", Name: "c"}
+	d := types.NamespacedName{Namespace: "ns", Name: "d"}
+
+	var (
+		nacks      *nackHandler
+		pushes     int
+		rolledBack []generator.Snapshot
+		enqueued   []types.NamespacedName
+	)
+	excluder := &fakeExcluder{}
+	sessions := envoy.NewSessionTracker(func() { nacks.onAcked() })
+	nacks = newNackHandler(logtesting.TestLogger(t), sessions, excluder,
+		func(snapshot generator.Snapshot) { rolledBack = append(rolledBack, snapshot) },
+		func() { pushes++ },
+		func(key types.NamespacedName) { enqueued = append(enqueued, key) })
+
+	stream := envoy.StreamKey{ID: 1}
+	sessions.StreamOpened(stream)
+
+	// push pushes a snapshot with the given ingresses and returns its sequence number.
+	nonce := 0
+	push := func(ingresses map[types.NamespacedName]int64) uint64 {
+		nonce++
+		version := strconv.Itoa(nonce)
+		var snapshot cache.Snapshot
+		snapshot.Resources[cachetypes.Route] = cache.Resources{Version: version}
+		seq := sessions.SnapshotPushed(snapshot)
+		nacks.snapshotPushed(seq, generator.Snapshot{Snapshot: snapshot, Ingresses: ingresses})
+		sessions.ResponseSent(stream, resource.RouteType, version, version)
+		return seq
+	}
+	ack := func() {
+		sessions.RequestReceived(stream, "", resource.RouteType, strconv.Itoa(nonce), nil)
+	}
+	nack := func(message string) {
+		sessions.RequestReceived(stream, "", resource.RouteType, strconv.Itoa(nonce), &rpcstatus.Status{Message: message})
+		seq, ok := sessions.NackedSnapshot(stream, resource.RouteType)
+		assert.Assert(t, ok)
+		nacks.onNACK(seq, message)
+	}
+
+	good := map[types.NamespacedName]int64{a: 1, b: 1, c: 1, d: 1}
+	push(good)
+	ack()
+
+	// All ingresses changed, so any of them might be the culprit.
+	push(map[types.NamespacedName]int64{a: 2, b: 2, c: 2, d: 2})
+	nack("first")
+	assert.Equal(t, len(rolledBack), 1)
+	assert.DeepEqual(t, rolledBack[0].Ingresses, good)
+	assert.DeepEqual(t, excluder.excluded, map[types.NamespacedName]int64{c: 2, d: 2})
+	assert.Equal(t, pushes, 1)
+
+	// Half of the suspects are fine.
+	push(map[types.NamespacedName]int64{a: 2, b: 2})
+	ack()
+	assert.DeepEqual(t, excluder.excluded, map[types.NamespacedName]int64{d: 2})
+	assert.Equal(t, pushes, 2)
+
+	// A NACK for anything but the probe is ignored while bisecting.
+	nacks.onNACK(1, "ignored")
+	assert.Equal(t, pushes, 2)
+
+	push(map[types.NamespacedName]int64{a: 2, b: 2, c: 2})
+	nack("second")
+	assert.DeepEqual(t, enqueued, []types.NamespacedName{c})
+	assert.DeepEqual(t, excluder.excluded, map[types.NamespacedName]int64{c: 2})
+	assert.Equal(t, pushes, 3)
+
+	message, ok := nacks.rejection(c, 2)
+	assert.Assert(t, ok)
+	assert.Equal(t, message, "second")
+	_, ok = nacks.rejection(c, 3)
+	assert.Assert(t, !ok, "a new generation is not rejected")
+
+	nacks.forget(c)
+	_, ok = nacks.rejection(c, 2)
+	assert.Assert(t, !ok)
+	assert.DeepEqual(t, excluder.excluded, map[types.NamespacedName]int64{})
+}
+
+func TestNackHandlerAttributesError(t *testing.T) {
+	a := types.NamespacedName{Namespace: "ns", Name: "a"}
+	b := types.NamespacedName{Namespace: "ns", Name: "b"}
+
+	var enqueued []types.NamespacedName
+	excluder := &fakeExcluder{attributed: []types.NamespacedName{b}}
+	sessions := envoy.NewSessionTracker(func() {})
+	nacks := newNackHandler(logtesting.TestLogger(t), sessions, excluder,
+		func(generator.Snapshot) {},
+		func() {},
+		func(key types.NamespacedName) { enqueued = append(enqueued, key) })
+
+	nacks.snapshotPushed(1, generator.Snapshot{Ingresses: map[types.NamespacedName]int64{a: 1, b: 1}})
+	nacks.onNACK(1, "invalid route (ns/b)")
+
+	assert.DeepEqual(t, enqueued, []types.NamespacedName{b})
+	assert.DeepEqual(t, excluder.excluded, map[types.NamespacedName]int64{b: 1})
+
+	// The same NACK is only handled once.
+	nacks.onNACK(1, "invalid route (ns/b)")
+	assert.DeepEqual(t, enqueued, []types.NamespacedName{b})
+}
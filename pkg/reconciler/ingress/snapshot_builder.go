@@ -0,0 +1,172 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.uber.org/zap"
+	rconfig "knative.dev/net-kourier/pkg/reconciler/ingress/config"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+)
+
+var (
+	coalescedUpdatesStat = stats.Int64(
+		"snapshot_coalesced_updates",
+		"Number of configuration updates coalesced into a single Envoy snapshot",
+		stats.UnitDimensionless)
+	snapshotDelayStat = stats.Int64(
+		"snapshot_delay",
+		"Time between the first coalesced configuration update and the build of the Envoy snapshot",
+		stats.UnitMilliseconds)
+)
+
+func init() {
+	if err := view.Register(&view.View{
+		Description: coalescedUpdatesStat.Description(),
+		Measure:     coalescedUpdatesStat,
+		Aggregation: view.Distribution(1, 2, 5, 10, 20, 50, 100, 200, 500, 1000),
+	}, &view.View{
+		Description: snapshotDelayStat.Description(),
+		Measure:     snapshotDelayStat,
+		Aggregation: view.Distribution(10, 50, 100, 250, 500, 1000, 2500, 5000, 10000),
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// snapshotBuilder coalesces the requests to update the Envoy configuration, so only
+// one snapshot is built and pushed for all the changes to the caches that happen
+// within the configured batch window.
+//
+// The window restarts with every request, until the configured max delay since the
+// first pending request is reached.
+type snapshotBuilder struct {
+	// build builds and pushes a snapshot from the current state of the caches.
+	build func(ctx context.Context) error
+
+	mu sync.Mutex
+	// pending is the number of requests since the last build.
+	pending int
+	// firstPending is the time of the first request since the last build.
+	firstPending time.Time
+	// ctx is the context of the last request, which carries the latest config.
+	ctx   context.Context
+	timer *time.Timer
+
+	// buildMu ensures that snapshots are built and pushed one after the other, so an
+	// older snapshot never overrides a newer one.
+	buildMu sync.Mutex
+}
+
+func newSnapshotBuilder(build func(ctx context.Context) error) *snapshotBuilder {
+	return &snapshotBuilder{build: build}
+}
+
+// trigger requests a new snapshot to be built. If batching is disabled, the snapshot
+// is built synchronously and any error is returned. Otherwise, it is built once the
+// batch window elapses and errors are only logged, causing the build to be retried.
+func (b *snapshotBuilder) trigger(ctx context.Context) error {
+	cfg := rconfig.FromContextOrDefaults(ctx).Kourier
+	if cfg.SnapshotBatchWindow <= 0 {
+		b.buildMu.Lock()
+		defer b.buildMu.Unlock()
+		return b.build(ctx)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.pending == 0 {
+		b.firstPending = now
+	}
+	b.pending++
+	b.ctx = ctx
+
+	delay := cfg.SnapshotBatchWindow
+	if maxDelay := b.firstPending.Add(cfg.SnapshotMaxDelay).Sub(now); cfg.SnapshotMaxDelay > 0 && maxDelay < delay {
+		delay = maxDelay
+	}
+	b.schedule(delay)
+	return nil
+}
+
+// exclusive runs f while no snapshot is built or pushed, so f can set a snapshot
+// without a concurrent build overriding it right after.
+func (b *snapshotBuilder) exclusive(f func()) {
+	b.buildMu.Lock()
+	defer b.buildMu.Unlock()
+	f()
+}
+
+// schedule (re)starts the timer to flush after the given delay. Must be called while
+// holding mu.
+func (b *snapshotBuilder) schedule(delay time.Duration) {
+	if b.timer == nil {
+		b.timer = time.AfterFunc(delay, b.flush)
+		return
+	}
+	b.timer.Reset(delay)
+}
+
+// flush builds a snapshot for all the pending requests.
+func (b *snapshotBuilder) flush() {
+	b.buildMu.Lock()
+	defer b.buildMu.Unlock()
+
+	b.mu.Lock()
+	if b.pending == 0 {
+		// Already flushed by a timer that fired concurrently.
+		b.mu.Unlock()
+		return
+	}
+	ctx, pending, firstPending := b.ctx, b.pending, b.firstPending
+	b.pending = 0
+	b.mu.Unlock()
+
+	metrics.RecordBatch(ctx,
+		coalescedUpdatesStat.M(int64(pending)),
+		snapshotDelayStat.M(time.Since(firstPending).Milliseconds()))
+
+	if err := b.build(ctx); err != nil {
+		logger := logging.FromContext(ctx)
+		logger.Errorw("Failed to update the Envoy configuration, retrying", zap.Error(err))
+
+		// Retry after the max delay, unless a new request came in meanwhile, which
+		// schedules a build already.
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.pending == 0 {
+			b.firstPending = time.Now()
+			b.pending = pending
+			b.ctx = ctx
+
+			cfg := rconfig.FromContextOrDefaults(ctx).Kourier
+			retryDelay := cfg.SnapshotMaxDelay
+			if retryDelay < cfg.SnapshotBatchWindow {
+				retryDelay = cfg.SnapshotBatchWindow
+			}
+			b.schedule(retryDelay)
+		}
+	}
+}
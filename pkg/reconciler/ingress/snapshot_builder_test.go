@@ -0,0 +1,142 @@
+/*
+Copyright 2022 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"knative.dev/net-kourier/pkg/config"
+	rconfig "knative.dev/net-kourier/pkg/reconciler/ingress/config"
+)
+
+func contextWithBatching(window, maxDelay time.Duration) context.Context {
+	cfg := config.DefaultConfig()
+	cfg.SnapshotBatchWindow = window
+	cfg.SnapshotMaxDelay = maxDelay
+	return rconfig.ToContext(context.Background(), &rconfig.Config{Kourier: cfg})
+}
+
+func TestSnapshotBuilderDisabled(t *testing.T) {
+	var builds int32
+	buildErr := errors.New("build failed")
+	b := newSnapshotBuilder(func(context.Context) error {
+		atomic.AddInt32(&builds, 1)
+		return buildErr
+	})
+
+	ctx := contextWithBatching(0, 0)
+	assert.Equal(t, b.trigger(ctx), buildErr)
+	assert.Equal(t, b.trigger(ctx), buildErr)
+	assert.Equal(t, atomic.LoadInt32(&builds), int32(2))
+}
+
+func TestSnapshotBuilderCoalesces(t *testing.T) {
+	var builds int32
+	b := newSnapshotBuilder(func(context.Context) error {
+		atomic.AddInt32(&builds, 1)
+		return nil
+	})
+
+	ctx := contextWithBatching(50*time.Millisecond, time.Minute)
+	for i := 0; i < 10; i++ {
+		assert.NilError(t, b.trigger(ctx))
+	}
+	assert.Equal(t, atomic.LoadInt32(&builds), int32(0))
+
+	waitForBuilds(t, &builds, 1)
+
+	// No further builds without further requests.
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, atomic.LoadInt32(&builds), int32(1))
+}
+
+func TestSnapshotBuilderMaxDelay(t *testing.T) {
+	var builds int32
+	b := newSnapshotBuilder(func(context.Context) error {
+		atomic.AddInt32(&builds, 1)
+		return nil
+	})
+
+	// Keep restarting the window, the max delay forces a build anyway.
+	ctx := contextWithBatching(100*time.Millisecond, 200*time.Millisecond)
+	start := time.Now()
+	for atomic.LoadInt32(&builds) == 0 {
+		assert.Assert(t, time.Since(start) < 5*time.Second, "no build within max delay")
+		assert.NilError(t, b.trigger(ctx))
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSnapshotBuilderRetries(t *testing.T) {
+	var builds int32
+	b := newSnapshotBuilder(func(context.Context) error {
+		if atomic.AddInt32(&builds, 1) == 1 {
+			return errors.New("build failed")
+		}
+		return nil
+	})
+
+	assert.NilError(t, b.trigger(contextWithBatching(10*time.Millisecond, 20*time.Millisecond)))
+	waitForBuilds(t, &builds, 2)
+}
+
+func waitForBuilds(t *testing.T, builds *int32, want int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(builds) < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d builds, want %d", atomic.LoadInt32(builds), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSnapshotBuilderExclusive(t *testing.T) {
+	building := make(chan struct{})
+	release := make(chan struct{})
+	b := newSnapshotBuilder(func(context.Context) error {
+		close(building)
+		<-release
+		return nil
+	})
+
+	ctx := contextWithBatching(0, 0)
+	go b.trigger(ctx)
+	<-building
+
+	// The exclusive function waits for the running build.
+	ran := make(chan struct{})
+	go b.exclusive(func() { close(ran) })
+	select {
+	case <-ran:
+		t.Fatal("exclusive function ran during a build")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("exclusive function didn't run after the build")
+	}
+}
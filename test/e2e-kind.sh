@@ -22,6 +22,33 @@ KOURIER_GATEWAY_NAMESPACE=kourier-system
 KOURIER_CONTROL_NAMESPACE=knative-serving
 CLUSTER_SUFFIX=${CLUSTER_SUFFIX:-cluster.local}
 
+# Waits until the admin endpoint $1 of all gateways matches the pattern $2, or doesn't
+# match it if $3 is "absent". Changes to config-kourier are pushed to the gateways
+# asynchronously, so tests must not start before they're in effect.
+function wait_for_gateway_config() {
+  local endpoint="$1" pattern="$2" want="${3:-present}"
+  for _ in {1..150}; do
+    local ready=true
+    for pod in $(kubectl -n "${KOURIER_GATEWAY_NAMESPACE}" get pods -lapp=3scale-kourier-gateway -oname); do
+      local out
+      out="$(kubectl -n "${KOURIER_GATEWAY_NAMESPACE}" exec "${pod}" -- curl -sf --unix /tmp/envoy.admin "http://localhost/${endpoint}" || echo "failed")"
+      if [[ "${out}" == "failed" ]]; then
+        ready=false
+      elif grep -q "${pattern}" <<< "${out}"; then
+        [[ "${want}" == "present" ]] || ready=false
+      else
+        [[ "${want}" == "absent" ]] || ready=false
+      fi
+    done
+    if [[ "${ready}" == "true" ]]; then
+      return 0
+    fi
+    sleep 2
+  done
+  echo "Timed out waiting for ${pattern} to be ${want} in ${endpoint} of the gateways"
+  return 1
+}
+
 $(dirname $0)/upload-test-images.sh
 
 echo ">> Setup test resources"
@@ -55,8 +82,8 @@ kubectl -n "${KOURIER_CONTROL_NAMESPACE}" scale deployment net-kourier-controlle
 
 echo ">> Setup one certificate"
 $(dirname $0)/generate-cert.sh
-kubectl -n "${KOURIER_CONTROL_NAMESPACE}" set env deployment net-kourier-controller CERTS_SECRET_NAMESPACE="${KOURIER_CONTROL_NAMESPACE}" CERTS_SECRET_NAME=wildcard-certs
-kubectl -n "${KOURIER_CONTROL_NAMESPACE}" rollout status deployment/net-kourier-controller --timeout=300s
+kubectl -n "${KOURIER_CONTROL_NAMESPACE}" patch configmap/config-kourier --type merge -p '{"data":{"certs-secret-namespace":"'"${KOURIER_CONTROL_NAMESPACE}"'","certs-secret-name":"wildcard-certs"}}'
+wait_for_gateway_config certs '\*\.example\.com'
 
 echo ">> Running OneTLSCert tests"
 go test -race -count=1 -timeout=20m -tags=e2e ./test/cert/... \
@@ -67,8 +94,8 @@ go test -race -count=1 -timeout=20m -tags=e2e ./test/cert/... \
 echo ">> Setup ExtAuthz"
 ko apply -f test/config/extauthz
 kubectl -n "${KOURIER_CONTROL_NAMESPACE}" wait --timeout=300s --for=condition=Available deployment/externalauthz
-kubectl -n "${KOURIER_CONTROL_NAMESPACE}" set env deployment net-kourier-controller KOURIER_EXTAUTHZ_HOST=externalauthz.knative-serving:6000
-kubectl -n "${KOURIER_CONTROL_NAMESPACE}" rollout status deployment/net-kourier-controller --timeout=300s
+kubectl -n "${KOURIER_CONTROL_NAMESPACE}" patch configmap/config-kourier --type merge -p '{"data":{"extauthz-host":"externalauthz.knative-serving:6000"}}'
+wait_for_gateway_config clusters '^extAuthz::'
 
 echo ">> Running ExtAuthz tests"
 go test -race -count=1 -timeout=20m -tags=e2e ./test/extauthz/... \
@@ -77,8 +104,8 @@ go test -race -count=1 -timeout=20m -tags=e2e ./test/extauthz/... \
   --cluster-suffix="$CLUSTER_SUFFIX"
 
 echo ">> Unset ExtAuthz"
-kubectl -n "${KOURIER_CONTROL_NAMESPACE}" set env deployment net-kourier-controller KOURIER_EXTAUTHZ_HOST-
-kubectl -n "${KOURIER_CONTROL_NAMESPACE}" rollout status deployment/net-kourier-controller
+kubectl -n "${KOURIER_CONTROL_NAMESPACE}" patch configmap/config-kourier --type json -p '[{"op":"remove","path":"/data/extauthz-host"}]'
+wait_for_gateway_config clusters '^extAuthz::' absent
 
 echo ">> Setup Proxy Protocol"
 kubectl -n "${KOURIER_CONTROL_NAMESPACE}" patch configmap/config-kourier --type merge -p '{"data":{"enable-proxy-protocol":"true"}}'